@@ -1,54 +1,667 @@
 // Package traefik_analytics is a Traefik plugin that collects request analytics
-// and stores them in a PostgreSQL database.
+// and stores them in PostgreSQL or one of the other supported backends.
 package traefik_analytics
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
-
-	_ "github.com/lib/pq"
 )
 
 // Config holds the plugin configuration.
 type Config struct {
-	DatabaseDSN string `json:"databaseDSN,omitempty"`
+	// StorageType selects the storage backend, e.g. "postgres" or "clickhouse".
+	StorageType string `json:"storageType,omitempty"`
+	// StorageTypes selects several backends at once and takes precedence
+	// over StorageType. Every backend gets its own queue and worker.
+	StorageTypes []string `json:"storageTypes,omitempty"`
+	DatabaseDSN  string   `json:"databaseDSN,omitempty"`
+	// AutoMigrate creates the table of the postgres, mysql and timescaledb
+	// backends on startup and applies the schema changes of newer plugin
+	// versions, including missing columns. The sqlite backend always does.
+	// Without it, only the columns the table has are written.
+	AutoMigrate bool `json:"autoMigrate,omitempty"`
+	// TableName and SchemaName set the table of the postgres, mysql,
+	// sqlite, timescaledb and clickhouse backends. SchemaName is the
+	// database for mysql and clickhouse, and is not supported by sqlite;
+	// without it, the default schema of the connection is used.
+	TableName  string `json:"tableName,omitempty"`
+	SchemaName string `json:"schemaName,omitempty"`
+	// ResponseTimeUnit is the unit of the response_time column of the
+	// postgres, mysql, sqlite and timescaledb backends: "ns" for integer
+	// nanoseconds, "ms" for fractional milliseconds (DOUBLE PRECISION,
+	// DOUBLE or REAL), or "interval" for a postgres INTERVAL. It defaults
+	// to the type in the schema files: INTERVAL for postgres and
+	// timescaledb, nanoseconds otherwise. AutoMigrate creates new tables
+	// with the matching type but does not change existing columns.
+	ResponseTimeUnit string `json:"responseTimeUnit,omitempty"`
+	// Columns maps the columns of the postgres, mysql, sqlite and
+	// timescaledb backends to those of an existing table, e.g.
+	// {"request_time": "ts", "path": "uri"}, using the column names of
+	// schema.sql. Only mapped columns are written and request_time is
+	// required. The table is never created or migrated, and without a
+	// mapped record_id, records written again are not skipped.
+	Columns     map[string]string `json:"columns,omitempty"`
+	Shards      ShardingConfig    `json:"shards,omitempty"`
+	HostRouting HostRoutingConfig `json:"hostRouting,omitempty"`
+	// Partitioning partitions the table of the postgres backend by time.
+	Partitioning PartitioningConfig `json:"partitioning,omitempty"`
+	SQLPool      SQLPoolConfig      `json:"sqlPool,omitempty"`
+	// RetentionDays removes records older than the given number of days
+	// from the postgres, mysql, sqlite, timescaledb and clickhouse backends.
+	// Zero keeps records forever.
+	RetentionDays int `json:"retentionDays,omitempty"`
+	// BatchSize is the maximum number of records written to a backend at
+	// once. A smaller batch is written when its oldest record has waited
+	// for FlushInterval.
+	BatchSize     int    `json:"batchSize,omitempty"`
+	FlushInterval string `json:"flushInterval,omitempty"`
+	// WorkerCount is the number of workers writing to each backend, each
+	// with its own connection. The file, prometheus and sqlite backends
+	// always use one.
+	WorkerCount int `json:"workerCount,omitempty"`
+	// SharedWriter lets all middleware instances writing to the same
+	// database share one queue and set of workers, instead of each running
+	// its own. Instances whose storage settings, such as batchSize or
+	// retryBackoff, differ get separate ones.
+	SharedWriter bool `json:"sharedWriter,omitempty"`
+	// ShutdownTimeout bounds the time spent writing queued records when
+	// Traefik stops the middleware, e.g. on restart or reload.
+	ShutdownTimeout string      `json:"shutdownTimeout,omitempty"`
+	Spill           SpillConfig `json:"spill,omitempty"`
+	WAL             WALConfig   `json:"wal,omitempty"`
+	// QueueSize is the number of records queued for each backend.
+	// QueueMaxBytes additionally caps the estimated memory they hold; zero
+	// means no cap.
+	QueueSize     int   `json:"queueSize,omitempty"`
+	QueueMaxBytes int64 `json:"queueMaxBytes,omitempty"`
+	// Backpressure sets what happens to records while a backend falls
+	// behind. The numbers of affected records are published with expvar.
+	Backpressure   BackpressureConfig   `json:"backpressure,omitempty"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+	RetryBackoff   RetryBackoffConfig   `json:"retryBackoff,omitempty"`
+	DeadLetter     DeadLetterConfig     `json:"deadLetter,omitempty"`
+
+	// Router, Service and EntryPoint label the records of this middleware
+	// instance. Traefik does not expose them to plugins, so they are either
+	// set here or taken from the request headers named by the *Header
+	// options, e.g. set by a headers middleware earlier in the chain. A
+	// header value takes precedence over the static one.
+	Router           string `json:"router,omitempty"`
+	Service          string `json:"service,omitempty"`
+	EntryPoint       string `json:"entryPoint,omitempty"`
+	RouterHeader     string `json:"routerHeader,omitempty"`
+	ServiceHeader    string `json:"serviceHeader,omitempty"`
+	EntryPointHeader string `json:"entryPointHeader,omitempty"`
+
+	ClientIP    ClientIPConfig    `json:"clientIP,omitempty"`
+	AnonymizeIP AnonymizeIPConfig `json:"anonymizeIP,omitempty"`
+	// PrivacySignals sets how requests with DNT or Sec-GPC headers are
+	// recorded.
+	PrivacySignals PrivacySignalsConfig `json:"privacySignals,omitempty"`
+	OptOut         OptOutConfig         `json:"optOut,omitempty"`
+	Consent        ConsentConfig        `json:"consent,omitempty"`
+	HealthChecks   HealthCheckConfig    `json:"healthChecks,omitempty"`
+	// ExcludePrivateIPs skips requests from private (RFC 1918 and RFC 4193),
+	// loopback and link-local addresses, and from InternalCIDRs, e.g.
+	// service-to-service traffic.
+	ExcludePrivateIPs bool     `json:"excludePrivateIPs,omitempty"`
+	InternalCIDRs     []string `json:"internalCIDRs,omitempty"`
+	// IncludePaths and ExcludePaths are regular expressions matched against
+	// the request path. When IncludePaths is set, only matching requests
+	// are recorded; requests matching ExcludePaths are never recorded, e.g.
+	// "^/healthz$" or `\.(css|js|png)$`.
+	IncludePaths []string `json:"includePaths,omitempty"`
+	ExcludePaths []string `json:"excludePaths,omitempty"`
+	// IncludeStatus and ExcludeStatus select the recorded responses by
+	// status code. Entries are a code ("404"), a class ("5xx") or an
+	// inclusive range ("400-599"). When IncludeStatus is set, only matching
+	// responses are recorded; matches of ExcludeStatus are never recorded.
+	IncludeStatus []string `json:"includeStatus,omitempty"`
+	ExcludeStatus []string `json:"excludeStatus,omitempty"`
+	// ExcludeExtensions skips requests for paths with the given file
+	// extensions, e.g. "css", "js" or "png". ExcludeContentTypes skips
+	// responses whose Content-Type starts with one of the given values, e.g.
+	// "image/" or "text/css", so that only page views are recorded.
+	ExcludeExtensions   []string `json:"excludeExtensions,omitempty"`
+	ExcludeContentTypes []string `json:"excludeContentTypes,omitempty"`
+	// SamplingRate is the fraction of requests recorded, from 0 to 1.
+	// Counts computed from sampled records must be divided by it.
+	// SampleByClientIP samples by a hash of the client address instead of
+	// at random, so a visitor is always either recorded or not.
+	// Requests that are not sampled are still recorded if they fail with a
+	// 5xx status or take at least KeepSlowerThan, e.g. "1s".
+	SamplingRate     float64 `json:"samplingRate,omitempty"`
+	SampleByClientIP bool    `json:"sampleByClientIP,omitempty"`
+	KeepSlowerThan   string  `json:"keepSlowerThan,omitempty"`
+	// HostRules replace the filter and sampling options above for some
+	// hosts, e.g. to sample only the requests of a CDN host.
+	HostRules []HostRule      `json:"hostRules,omitempty"`
+	RateLimit RateLimitConfig `json:"rateLimit,omitempty"`
+	// IdleTimeout is the idle timeout of the Traefik entrypoint, used to
+	// tell reused keep-alive connections from new ones.
+	IdleTimeout string      `json:"idleTimeout,omitempty"`
+	Query       QueryConfig `json:"query,omitempty"`
+	// DisabledFields lists record fields that are never stored, by their
+	// column name, e.g. "user_agent" or "referer". Derived fields such as
+	// browser are still computed unless disabled too.
+	DisabledFields []string `json:"disabledFields,omitempty"`
+	// Redact masks sensitive parts of the path, query string and referer,
+	// such as e-mail addresses, before they are stored.
+	Redact            []RedactionRule         `json:"redact,omitempty"`
+	PathNormalization PathNormalizationConfig `json:"pathNormalization,omitempty"`
+	// UTMParams records the utm_* campaign parameters in their own columns,
+	// whatever the query mode.
+	UTMParams bool `json:"utmParams,omitempty"`
+	// RequestHeaders lists request headers recorded in the headers column,
+	// e.g. a tenant ID or API version header.
+	RequestHeaders []string `json:"requestHeaders,omitempty"`
+	// ResponseHeaders lists response headers recorded in the
+	// response_headers column, e.g. X-Cache or Content-Encoding.
+	ResponseHeaders []string     `json:"responseHeaders,omitempty"`
+	Cookies         CookieConfig `json:"cookies,omitempty"`
+	Extra           ExtraConfig  `json:"extra,omitempty"`
+	// UpstreamHeaders name response headers that identify the upstream
+	// server, e.g. an X-Served-By header set by the application or a load
+	// balancer behind Traefik. Traefik itself does not expose the selected
+	// server to middlewares. With StripUpstreamHeaders the headers are
+	// removed from the response sent to the client.
+	UpstreamHeaders      []string `json:"upstreamHeaders,omitempty"`
+	StripUpstreamHeaders bool     `json:"stripUpstreamHeaders,omitempty"`
+	// MeasureUncompressedSize decodes gzip, deflate and zstd encoded
+	// responses on the fly to record their uncompressed size. This costs CPU
+	// for every compressed response; Brotli bodies are not measured.
+	MeasureUncompressedSize bool `json:"measureUncompressedSize,omitempty"`
+	// TraceHeaders are checked in order for a trace or correlation ID.
+	TraceHeaders []string `json:"traceHeaders,omitempty"`
+	// RequestIDHeader carries the request ID. With GenerateRequestID, a
+	// UUIDv7 is generated for requests without one and set on both the
+	// request passed upstream and the response.
+	RequestIDHeader   string `json:"requestIDHeader,omitempty"`
+	GenerateRequestID bool   `json:"generateRequestID,omitempty"`
+	// TLSFingerprintHeaders are checked in order for a TLS client
+	// fingerprint (JA3, JA4) computed by a trusted proxy in front of
+	// Traefik, e.g. CloudFront-Viewer-JA3-Fingerprint.
+	TLSFingerprintHeaders []string `json:"tlsFingerprintHeaders,omitempty"`
+
+	GeoIP GeoIPConfig `json:"geoIP,omitempty"`
+	ASN   ASNConfig   `json:"asn,omitempty"`
+	// UserAgent parses the User-Agent header into browser, OS and device
+	// type columns.
+	UserAgent      UserAgentConfig      `json:"userAgent,omitempty"`
+	Bots           BotConfig            `json:"bots,omitempty"`
+	RDNS           RDNSConfig           `json:"rdns,omitempty"`
+	Referrer       ReferrerConfig       `json:"referrer,omitempty"`
+	IPLists        IPListsConfig        `json:"ipLists,omitempty"`
+	EnrichmentHook EnrichmentHookConfig `json:"enrichmentHook,omitempty"`
+	Visitor        VisitorConfig        `json:"visitor,omitempty"`
+
+	Kafka         KafkaConfig         `json:"kafka,omitempty"`
+	NATS          NATSConfig          `json:"nats,omitempty"`
+	Elasticsearch ElasticsearchConfig `json:"elasticsearch,omitempty"`
+	InfluxDB      InfluxDBConfig      `json:"influxdb,omitempty"`
+	TimescaleDB   TimescaleDBConfig   `json:"timescaleDB,omitempty"`
+	MongoDB       MongoDBConfig       `json:"mongodb,omitempty"`
+	Redis         RedisConfig         `json:"redis,omitempty"`
+	Loki          LokiConfig          `json:"loki,omitempty"`
+	S3            S3Config            `json:"s3,omitempty"`
+	File          FileConfig          `json:"file,omitempty"`
+	Webhook       WebhookConfig       `json:"webhook,omitempty"`
+	Syslog        SyslogConfig        `json:"syslog,omitempty"`
+	BigQuery      BigQueryConfig      `json:"bigquery,omitempty"`
+	OTLP          OTLPConfig          `json:"otlp,omitempty"`
+	Prometheus    PrometheusConfig    `json:"prometheus,omitempty"`
+	Cassandra     CassandraConfig     `json:"cassandra,omitempty"`
+	AMQP          AMQPConfig          `json:"amqp,omitempty"`
+	PubSub        PubSubConfig        `json:"pubsub,omitempty"`
+	Kinesis       KinesisConfig       `json:"kinesis,omitempty"`
+	Splunk        SplunkConfig        `json:"splunk,omitempty"`
+	Datadog       DatadogConfig       `json:"datadog,omitempty"`
+	Fluent        FluentConfig        `json:"fluent,omitempty"`
+	GELF          GELFConfig          `json:"gelf,omitempty"`
+	Segment       SegmentConfig       `json:"segment,omitempty"`
+	GA4           GA4Config           `json:"ga4,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
+		StorageType: "postgres",
 		DatabaseDSN: "",
+		TableName:   "request_logs",
+		Partitioning: PartitioningConfig{
+			Premake: 3,
+		},
+		Shards: ShardingConfig{
+			Key: shardByHost,
+		},
+		BatchSize:       100,
+		FlushInterval:   "1s",
+		WorkerCount:     1,
+		ShutdownTimeout: "5s",
+		QueueSize:       1000,
+		Spill: SpillConfig{
+			MaxSizeBytes: 100 << 20,
+		},
+		Backpressure: BackpressureConfig{
+			Policy:       "dropNewest",
+			BlockTimeout: "10ms",
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 5,
+			OpenDuration:     "30s",
+		},
+		RetryBackoff: RetryBackoffConfig{
+			InitialInterval: "1s",
+			MaxInterval:     "1m",
+		},
+		ClientIP: ClientIPConfig{
+			Headers:  []string{"X-Forwarded-For", "X-Real-IP"},
+			Strategy: "rightmost-untrusted",
+		},
+		AnonymizeIP: AnonymizeIPConfig{
+			Mode:       "none",
+			IPv4Prefix: 24,
+			IPv6Prefix: 48,
+		},
+		PrivacySignals: PrivacySignalsConfig{
+			DNT: "ignore",
+			GPC: "strip",
+		},
+		HealthChecks: HealthCheckConfig{
+			Paths: []string{"/healthz", "/livez", "/readyz", "/health", "/ping"},
+		},
+		SamplingRate: 1,
+		RateLimit: RateLimitConfig{
+			SummaryInterval: "1m",
+		},
+		UTMParams:         true,
+		IdleTimeout:       "180s",
+		TraceHeaders:      []string{"traceparent", "X-Request-ID"},
+		RequestIDHeader:   "X-Request-ID",
+		GenerateRequestID: true,
+		GeoIP: GeoIPConfig{
+			ReloadInterval: "1m",
+		},
+		ASN: ASNConfig{
+			ReloadInterval: "1m",
+		},
+		UserAgent: UserAgentConfig{
+			Parse:     true,
+			CacheSize: 10000,
+		},
+		Bots: BotConfig{
+			Detect:     true,
+			DNSTimeout: "500ms",
+		},
+		RDNS: RDNSConfig{
+			Timeout:   "500ms",
+			CacheSize: 10000,
+			CacheTTL:  "1h",
+		},
+		Referrer: ReferrerConfig{
+			Classify: true,
+		},
+		IPLists: IPListsConfig{
+			RefreshInterval: "1h",
+		},
+		EnrichmentHook: EnrichmentHookConfig{
+			Timeout: "2s",
+		},
+		Visitor: VisitorConfig{
+			SessionTimeout: "30m",
+		},
+		Query: QueryConfig{
+			Mode: "none",
+			Denylist: []string{
+				"token", "access_token", "id_token", "refresh_token", "auth", "key", "api_key", "apikey",
+				"password", "passwd", "pwd", "secret", "client_secret", "signature", "sig", "session",
+			},
+		},
+		Kafka: KafkaConfig{
+			Topic: "traefik-analytics",
+		},
+		NATS: NATSConfig{
+			URL:     "nats://127.0.0.1:4222",
+			Subject: "analytics.{host}.{method}",
+		},
+		Elasticsearch: ElasticsearchConfig{
+			URL:            "http://127.0.0.1:9200",
+			IndexPrefix:    "traefik-analytics",
+			ManageTemplate: true,
+		},
+		InfluxDB: InfluxDBConfig{
+			URL:         "http://127.0.0.1:8086",
+			Measurement: "traefik_requests",
+		},
+		TimescaleDB: TimescaleDBConfig{
+			ChunkTimeInterval: "1 day",
+			CompressSegmentBy: "host",
+		},
+		MongoDB: MongoDBConfig{
+			Collection: "request_logs",
+		},
+		Redis: RedisConfig{
+			Address: "127.0.0.1:6379",
+			Stream:  "traefik-analytics",
+		},
+		Loki: LokiConfig{
+			URL:          "http://127.0.0.1:3100",
+			Labels:       []string{"host", "method"},
+			StaticLabels: map[string]string{"job": "traefik-analytics"},
+		},
+		S3: S3Config{
+			KeyPrefix:     "traefik-analytics/dt={year}-{month}-{day}/hour={hour}",
+			FlushInterval: "5m",
+			MaxRecords:    100000,
+		},
+		File: FileConfig{
+			MaxSizeBytes: 100 << 20,
+			MaxBackups:   10,
+		},
+		Webhook: WebhookConfig{
+			Timeout:      "10s",
+			MaxRetries:   3,
+			RetryBackoff: "500ms",
+		},
+		Syslog: SyslogConfig{
+			Network:  "udp",
+			Address:  "127.0.0.1:514",
+			Facility: "local0",
+			AppName:  "traefik-analytics",
+			SDID:     "request@32473",
+		},
+		BigQuery: BigQueryConfig{
+			Table: "request_logs",
+		},
+		OTLP: OTLPConfig{
+			Endpoint:    "http://127.0.0.1:4318/v1/logs",
+			ServiceName: "traefik",
+		},
+		Prometheus: PrometheusConfig{
+			PushInterval: "15s",
+			Buckets:      []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		Cassandra: CassandraConfig{
+			Consistency: "local_quorum",
+		},
+		AMQP: AMQPConfig{
+			Exchange:   "traefik-analytics",
+			RoutingKey: "analytics.{host}.{method}",
+			Persistent: true,
+		},
+		PubSub: PubSubConfig{
+			Endpoint:    "https://pubsub.googleapis.com",
+			OrderingKey: "ip",
+			MaxMessages: 1000,
+			MaxBytes:    9 << 20,
+		},
+		Kinesis: KinesisConfig{
+			PartitionKey: "ip",
+			Aggregate:    true,
+		},
+		Splunk: SplunkConfig{
+			Source:     "traefik-analytics",
+			SourceType: "traefik:analytics",
+			Compress:   true,
+		},
+		Datadog: DatadogConfig{
+			Site:    "datadoghq.com",
+			Service: "traefik",
+			Source:  "traefik",
+		},
+		Fluent: FluentConfig{
+			Address: "127.0.0.1:24224",
+			Tag:     "traefik.analytics",
+		},
+		GELF: GELFConfig{
+			Network:     "udp",
+			Address:     "127.0.0.1:12201",
+			Compression: "gzip",
+			ChunkSize:   1420,
+		},
+		Segment: SegmentConfig{
+			Endpoint:     "https://api.segment.io",
+			Event:        "Page Requested",
+			MaxRetries:   3,
+			RetryBackoff: "500ms",
+		},
+		GA4: GA4Config{
+			Endpoint:     "https://www.google-analytics.com/mp/collect",
+			MaxRetries:   3,
+			RetryBackoff: "500ms",
+		},
 	}
 }
 
 // Analytics is the plugin structure.
 type Analytics struct {
-	next     http.Handler
-	name     string
-	config   *Config
-	dataChan chan RequestData
+	next      http.Handler
+	name      string
+	config    *Config
+	clientIP  *clientIPResolver
+	anonymize *ipAnonymizer
+	privacy   *privacySignals
+	hostRules *hostRules
+	rateLimit *rateLimiter
+	query     *queryFilter
+	redactor  *redactor
+	paths     *pathNormalizer
+	fields    *fieldFilter
+	cookies   *cookieCapture
+	extra     *extraCapture
+	// requestHeaders and responseHeaders capture the configured headers.
+	requestHeaders  headerCapture
+	responseHeaders headerCapture
+	conns           *connTracker
+	enrichers       []enricher
+	// forward passes enriched records on to the background enrichment
+	// stages, if any, and the outputs.
+	forward func(RequestData)
+	outputs []*output
+	// done is closed when the instance is shut down, e.g. on a reload.
+	done <-chan struct{}
 }
 
 // New creates a new plugin instance.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if config.DatabaseDSN == "" {
-		return nil, fmt.Errorf("DatabaseDSN is required")
+	storageTypes := config.StorageTypes
+	if len(storageTypes) == 0 {
+		storageTypes = []string{config.StorageType}
+	}
+
+	clientIP, err := newClientIPResolver(config.ClientIP)
+	if err != nil {
+		return nil, err
+	}
+	anonymize, err := newIPAnonymizer(config.AnonymizeIP)
+	if err != nil {
+		return nil, err
+	}
+	privacy, err := newPrivacySignals(config.PrivacySignals, config.Consent)
+	if err != nil {
+		return nil, err
+	}
+	hostRules, err := newHostRules(config)
+	if err != nil {
+		return nil, err
+	}
+	query, err := newQueryFilter(config.Query)
+	if err != nil {
+		return nil, err
+	}
+	redactor, err := newRedactor(config.Redact)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := newPathNormalizer(config.PathNormalization)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := newFieldFilter(config.DisabledFields)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := newCookieCapture(config.Cookies)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := newExtraCapture(config.Extra)
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout, err := time.ParseDuration(config.IdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idleTimeout: %v", err)
+	}
+	if config.RetentionDays < 0 {
+		return nil, fmt.Errorf("retentionDays must not be negative")
+	}
+	if config.BatchSize < 1 {
+		return nil, fmt.Errorf("batchSize must be at least 1")
+	}
+	if config.WorkerCount < 1 {
+		return nil, fmt.Errorf("workerCount must be at least 1")
+	}
+	if config.QueueSize < 1 || config.QueueMaxBytes < 0 {
+		return nil, fmt.Errorf("queueSize must be at least 1 and queueMaxBytes must not be negative")
+	}
+	if err := validateBackpressureConfig(config.Backpressure); err != nil {
+		return nil, err
+	}
+	if err := validateCircuitBreakerConfig(config.CircuitBreaker); err != nil {
+		return nil, err
+	}
+	if err := validateRetryBackoffConfig(config.RetryBackoff); err != nil {
+		return nil, err
+	}
+	if err := validateDeadLetterConfig(config.DeadLetter); err != nil {
+		return nil, err
+	}
+	if err := validateWALConfig(config.WAL, config.Spill); err != nil {
+		return nil, err
+	}
+	if err := validateShardingConfig(config.Shards); err != nil {
+		return nil, err
+	}
+	if err := validateHostRoutingConfig(config.HostRouting, config.Shards); err != nil {
+		return nil, err
+	}
+	if err := validateResponseTimeUnit(config.ResponseTimeUnit); err != nil {
+		return nil, err
+	}
+	if err := validatePartitioningConfig(config.Partitioning, config.TableName); err != nil {
+		return nil, err
+	}
+	shutdownTimeout, err := time.ParseDuration(config.ShutdownTimeout)
+	if err != nil || shutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid shutdownTimeout %q", config.ShutdownTimeout)
+	}
+	flushInterval, err := time.ParseDuration(config.FlushInterval)
+	if err != nil || flushInterval <= 0 {
+		return nil, fmt.Errorf("invalid flushInterval %q", config.FlushInterval)
+	}
+	enrichers, background, err := newEnrichers(ctx, config)
+	if err != nil {
+		return nil, err
 	}
 
 	analytics := &Analytics{
-		next:     next,
-		name:     name,
-		config:   config,
-		dataChan: make(chan RequestData, 1000), // Buffered channel
+		next:            next,
+		name:            name,
+		config:          config,
+		clientIP:        clientIP,
+		anonymize:       anonymize,
+		privacy:         privacy,
+		hostRules:       hostRules,
+		query:           query,
+		redactor:        redactor,
+		paths:           paths,
+		fields:          fields,
+		cookies:         cookies,
+		extra:           extra,
+		requestHeaders:  newHeaderCapture(config.RequestHeaders),
+		responseHeaders: newHeaderCapture(config.ResponseHeaders),
+		conns:           newConnTracker(idleTimeout),
+		enrichers:       enrichers,
+		done:            ctx.Done(),
+	}
+	analytics.rateLimit, err = newRateLimiter(ctx, config.RateLimit, analytics.enqueue)
+	if err != nil {
+		return nil, err
 	}
 
-	// Start the processing worker
-	go analytics.processingWorker()
+	var stages []<-chan struct{}
+	analytics.forward = analytics.dispatch
+	if config.EnrichmentHook.URL != "" {
+		hook, err := newEnrichmentHook(ctx, config.EnrichmentHook, analytics.forward)
+		if err != nil {
+			return nil, err
+		}
+		analytics.forward = hook.enqueue
+		stages = append(stages, hook.stopped)
+	}
+	if len(background) > 0 {
+		enrichment := newBackgroundEnrichment(ctx, background, analytics.forward)
+		analytics.forward = enrichment.enqueue
+		stages = append(stages, enrichment.stopped)
+	}
+
+	// The outputs are closed only once the enrichment stages have handed
+	// on their queued records.
+	outputCtx, cancelOutputs := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		<-ctx.Done()
+		timer := time.NewTimer(shutdownTimeout)
+		defer timer.Stop()
+		for _, stopped := range stages {
+			select {
+			case <-stopped:
+			case <-timer.C:
+				log.Printf("Enrichment did not stop within %s, closing outputs", shutdownTimeout)
+				cancelOutputs()
+				return
+			}
+		}
+		cancelOutputs()
+	}()
+
+	seen := map[string]bool{}
+	var sharedTypes []string
+	for _, storageType := range storageTypes {
+		if seen[storageType] {
+			return nil, fmt.Errorf("storage type %q is configured twice", storageType)
+		}
+		seen[storageType] = true
+
+		if err := validateSink(storageType, config); err != nil {
+			return nil, err
+		}
+		if config.SharedWriter && dsnSinks[storageType] {
+			sharedTypes = append(sharedTypes, storageType)
+			continue
+		}
+		out, err := newOutput(outputCtx, name, storageType, config)
+		if err != nil {
+			return nil, err
+		}
+		analytics.outputs = append(analytics.outputs, out)
+	}
 
+	// Start the processing workers
+	for _, out := range analytics.outputs {
+		out.start()
+	}
+	for _, storageType := range sharedTypes {
+		out, err := acquireSharedOutput(outputCtx, storageType, config)
+		if err != nil {
+			return nil, err
+		}
+		analytics.outputs = append(analytics.outputs, out)
+	}
 	return analytics, nil
 }
 
@@ -56,96 +669,256 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 func (a *Analytics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	start := time.Now()
 
+	recordID := newUUIDv7(start)
+	requestID := a.requestID(rw, req, recordID)
+	privacy := a.privacy.handling(req)
+	ip, port := a.clientIP.resolve(req)
+	filter, sampler := a.hostRules.lookup(req.Host)
+	if privacy == privacySkip || filter.skip(req, ip) {
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+	sampled := sampler.sample(ip)
+	reused := a.conns.seen(req.RemoteAddr, start)
+
 	// Call the next handler
-	a.next.ServeHTTP(rw, req)
+	recorder := acquireResponseRecorder(rw)
+	defer releaseResponseRecorder(recorder)
+	recorder.upstreamHeaders = a.config.UpstreamHeaders
+	recorder.stripUpstream = a.config.StripUpstreamHeaders
+	recorder.measureUncompressed = a.config.MeasureUncompressedSize
+	a.next.ServeHTTP(recorder, req)
+
+	end := time.Now()
+	status := recorder.statusCode()
+	if filter.skipResponse(status, rw.Header().Get("Content-Type")) || !(sampled || sampler.keep(status, end.Sub(start))) {
+		return
+	}
+	tlsVersion, tlsCipher := tlsParameters(req)
+	var utm utmParams
+	if a.config.UTMParams {
+		utm = extractUTM(req.URL.RawQuery)
+	}
 
 	// Collect request data
-	data := RequestData{
-		IP:             req.RemoteAddr,
-		UserAgent:      req.UserAgent(),
-		Path:           req.URL.Path,
-		Time:           start,
-		Method:         req.Method,
-		Protocol:       req.Proto,
-		Host:           req.Host,
-		AcceptLanguage: req.Header.Get("Accept-Language"),
-		Referer:        req.Referer(),
-		ContentType:    req.Header.Get("Content-Type"),
-		ContentLength:  req.ContentLength,
-		ResponseTime:   time.Since(start),
-	}
-
-	// Send data to processing goroutine
-	select {
-	case a.dataChan <- data:
-		// Data sent successfully
-	default:
-		log.Println("Analytics channel full, discarding data")
+	data := acquireRequestData()
+	*data = RequestData{
+		IP:                  ip,
+		ClientPort:          port,
+		ConnectionReused:    reused,
+		UserAgent:           req.UserAgent(),
+		Path:                req.URL.Path,
+		QueryString:         a.query.filter(req.URL.RawQuery),
+		UTMSource:           utm.source,
+		UTMMedium:           utm.medium,
+		UTMCampaign:         utm.campaign,
+		UTMTerm:             utm.term,
+		UTMContent:          utm.content,
+		Headers:             a.requestHeaders.capture(req.Header),
+		Cookies:             a.cookies.capture(req),
+		TraceID:             extractTraceID(req.Header, a.config.TraceHeaders),
+		RequestID:           requestID,
+		RecordID:            recordID,
+		stripIdentifying:    privacy == privacyStrip,
+		TLSVersion:          tlsVersion,
+		TLSCipher:           tlsCipher,
+		TLSFingerprint:      a.clientIP.tlsFingerprint(req, a.config.TLSFingerprintHeaders),
+		Time:                start,
+		Method:              intern(req.Method),
+		Protocol:            intern(req.Proto),
+		Host:                intern(req.Host),
+		AcceptLanguage:      req.Header.Get("Accept-Language"),
+		Language:            primaryLanguage(req.Header.Get("Accept-Language")),
+		Referer:             req.Referer(),
+		ContentType:         intern(req.Header.Get("Content-Type")),
+		ContentLength:       req.ContentLength,
+		ResponseTime:        end.Sub(start),
+		TTFB:                recorder.timeToFirstByte(start, end),
+		Upstream:            recorder.upstream,
+		ContentEncoding:     recorder.encoding,
+		UncompressedSize:    recorder.uncompressedSize(),
+		ResponseContentType: intern(rw.Header().Get("Content-Type")),
+		ResponseHeaders:     a.responseHeaders.capture(rw.Header()),
+		Status:              status,
+		ResponseSize:        recorder.size,
+		Router:              a.routeValue(req, a.config.RouterHeader, a.config.Router),
+		Service:             a.routeValue(req, a.config.ServiceHeader, a.config.Service),
+		EntryPoint:          a.routeValue(req, a.config.EntryPointHeader, a.config.EntryPoint),
 	}
-}
+	// Like headers, extra values may identify the client.
+	if privacy != privacyStrip {
+		data.Extra = a.extra.capture(req)
+	}
+	a.redactor.redact(data)
+	a.paths.normalize(data)
 
-// RequestData holds the collected request information.
-type RequestData struct {
-	IP             string
-	UserAgent      string
-	Path           string
-	Time           time.Time
-	Method         string
-	Protocol       string
-	Host           string
-	AcceptLanguage string
-	Referer        string
-	ContentType    string
-	ContentLength  int64
-	ResponseTime   time.Duration
-}
+	if isGRPC(req) {
+		data.GRPCService, data.GRPCMethod = grpcMethod(req.URL.Path)
+		data.GRPCStatus = grpcStatus(rw.Header())
+	}
+	if !a.rateLimit.allow(data) {
+		releaseRequestData(data)
+		return
+	}
 
-// processingWorker handles database insertions.
-func (a *Analytics) processingWorker() {
-	for {
-		err := a.runWorker()
-		if err != nil {
-			log.Printf("Worker encountered an error: %v", err)
-			time.Sleep(5 * time.Second) // Wait before retrying
-		}
+	if tunnel := recorder.tunnel; tunnel != nil {
+		data.Upgrade = strings.ToLower(req.Header.Get("Upgrade"))
+		// The handler may return before the tunnel is closed, so the record
+		// is completed and sent once it is, or with the bytes counted so
+		// far when the instance shuts down.
+		go func() {
+			select {
+			case <-tunnel.closed:
+			case <-a.done:
+			}
+			data.ResponseTime = time.Since(start)
+			data.TunnelBytesReceived = tunnel.received.Load()
+			data.TunnelBytesSent = tunnel.sent.Load()
+			a.enqueue(data)
+			releaseRequestData(data)
+		}()
+		return
 	}
+
+	a.enqueue(data)
+	releaseRequestData(data)
 }
 
-// runWorker performs the actual database operations.
-func (a *Analytics) runWorker() error {
-	db, err := sql.Open("postgres", a.config.DatabaseDSN)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+// enqueue enriches a record and sends a copy of it to the processing
+// goroutines, so that the caller may release it.
+func (a *Analytics) enqueue(data *RequestData) {
+	for _, e := range a.enrichers {
+		e.enrich(data)
+	}
+	// Fields are removed before the background stages, which may call
+	// external services.
+	if data.stripIdentifying {
+		stripIdentifying(data)
 	}
-	defer db.Close()
+	a.fields.apply(data)
+	a.forward(*data)
+}
 
-	err = db.Ping()
-	if err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+// dispatch anonymizes a fully enriched record and hands it to every output.
+// Disabled fields are cleared again, as background stages may have set them.
+func (a *Analytics) dispatch(data RequestData) {
+	record := acquireRequestData()
+	*record = data
+	a.fields.apply(record)
+	a.anonymize.anonymize(record)
+	for _, out := range a.outputs {
+		out.enqueue(*record)
 	}
+	releaseRequestData(record)
+}
 
-	stmt, err := db.Prepare(`
-        INSERT INTO request_logs (
-            ip, user_agent, path, request_time, method, protocol, host,
-            accept_language, referer, content_type, content_length, response_time
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-    `)
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %v", err)
+// requestID returns the ID of the request. If the request has none and
+// generation is enabled, the ID of its record is used and propagated.
+func (a *Analytics) requestID(rw http.ResponseWriter, req *http.Request, recordID string) string {
+	header := a.config.RequestIDHeader
+	if header == "" {
+		return ""
 	}
-	defer stmt.Close()
+	id := req.Header.Get(header)
+	if id == "" && a.config.GenerateRequestID {
+		id = recordID
+		req.Header.Set(header, id)
+		rw.Header().Set(header, id)
+	}
+	if len(id) > maxTraceIDLength {
+		id = id[:maxTraceIDLength]
+	}
+	return id
+}
 
-	for data := range a.dataChan {
-		_, err := stmt.Exec(
-			data.IP, data.UserAgent, data.Path, data.Time, data.Method,
-			data.Protocol, data.Host, data.AcceptLanguage, data.Referer,
-			data.ContentType, data.ContentLength, data.ResponseTime,
-		)
-		if err != nil {
-			log.Printf("Failed to insert data: %v", err)
-			// Continue processing other requests
+// routeValue returns the value of the named request header, or fallback if
+// no header is configured or the request does not carry it.
+func (a *Analytics) routeValue(req *http.Request, header, fallback string) string {
+	if header != "" {
+		if value := req.Header.Get(header); value != "" {
+			return value
 		}
 	}
+	return fallback
+}
+
+// RequestData holds the collected request information.
+type RequestData struct {
+	IP                  string            `json:"ip"`
+	UserAgent           string            `json:"user_agent"`
+	Path                string            `json:"path"`
+	Time                time.Time         `json:"request_time"`
+	Method              string            `json:"method"`
+	Protocol            string            `json:"protocol"`
+	Host                string            `json:"host"`
+	AcceptLanguage      string            `json:"accept_language"`
+	Referer             string            `json:"referer"`
+	ContentType         string            `json:"content_type"`
+	ContentLength       int64             `json:"content_length"`
+	ResponseTime        time.Duration     `json:"response_time"`
+	ResponseContentType string            `json:"response_content_type"`
+	Status              int               `json:"status"`
+	ResponseSize        int64             `json:"response_size"`
+	Router              string            `json:"router"`
+	Service             string            `json:"service"`
+	EntryPoint          string            `json:"entrypoint"`
+	QueryString         string            `json:"query_string"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	Cookies             map[string]string `json:"cookies,omitempty"`
+	TraceID             string            `json:"trace_id"`
+	RequestID           string            `json:"request_id"`
+	Upgrade             string            `json:"upgrade"`
+	TunnelBytesReceived int64             `json:"tunnel_bytes_received"`
+	TunnelBytesSent     int64             `json:"tunnel_bytes_sent"`
+	GRPCService         string            `json:"grpc_service,omitempty"`
+	GRPCMethod          string            `json:"grpc_method,omitempty"`
+	GRPCStatus          *int              `json:"grpc_status,omitempty"`
+	ClientPort          int               `json:"client_port"`
+	ConnectionReused    bool              `json:"connection_reused"`
+	ResponseHeaders     map[string]string `json:"response_headers,omitempty"`
+	TTFB                time.Duration     `json:"ttfb"`
+	Upstream            string            `json:"upstream"`
+	ContentEncoding     string            `json:"content_encoding"`
+	UncompressedSize    *int64            `json:"uncompressed_size,omitempty"`
+	Country             string            `json:"country"`
+	Region              string            `json:"region"`
+	City                string            `json:"city"`
+	ASN                 uint32            `json:"asn"`
+	ASOrg               string            `json:"as_org"`
+	Browser             string            `json:"browser"`
+	BrowserVersion      string            `json:"browser_version"`
+	OS                  string            `json:"os"`
+	OSVersion           string            `json:"os_version"`
+	DeviceType          string            `json:"device_type"`
+	IsBot               bool              `json:"is_bot"`
+	BotName             string            `json:"bot_name"`
+	RDNS                string            `json:"rdns"`
+	UTMSource           string            `json:"utm_source"`
+	UTMMedium           string            `json:"utm_medium"`
+	UTMCampaign         string            `json:"utm_campaign"`
+	UTMTerm             string            `json:"utm_term"`
+	UTMContent          string            `json:"utm_content"`
+	ReferrerDomain      string            `json:"referrer_domain"`
+	ReferrerType        string            `json:"referrer_type"`
+	Language            string            `json:"language"`
+	IsDatacenter        bool              `json:"is_datacenter"`
+	IsVPN               bool              `json:"is_vpn"`
+	IsTor               bool              `json:"is_tor"`
+	VisitorID           string            `json:"visitor_id"`
+	SessionID           string            `json:"session_id"`
+	TLSVersion          string            `json:"tls_version"`
+	TLSCipher           string            `json:"tls_cipher"`
+	TLSFingerprint      string            `json:"tls_fingerprint"`
+	RawPath             string            `json:"raw_path,omitempty"`
+	Extra               map[string]string `json:"extra,omitempty"`
+	// RecordID is a UUIDv7 identifying the record, so that backends can
+	// skip records they already stored when a batch is written again.
+	RecordID string `json:"record_id,omitempty"`
 
-	return nil
+	// stripIdentifying is set for requests with a privacy signal whose
+	// identifying fields are cleared before storage.
+	stripIdentifying bool
+	// botDomains are the reverse DNS domains of the crawler the record
+	// claims to be, set while its name awaits verification.
+	botDomains []string
 }
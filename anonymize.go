@@ -0,0 +1,79 @@
+package traefik_analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+)
+
+// AnonymizeIPConfig controls how client addresses are stored. Enrichment,
+// such as GeoIP, still uses the full address.
+type AnonymizeIPConfig struct {
+	// Mode is "none", "truncate" to keep only the network prefix, "hash" to
+	// store a keyed hash, or "drop" to store no address at all. The hash is
+	// not an address, so the postgres and timescaledb backends only accept
+	// it with a column mapping to a table whose ip column is TEXT.
+	// Anonymization also clears the client port.
+	Mode string `json:"mode,omitempty"`
+	// IPv4Prefix and IPv6Prefix are the prefix lengths kept in truncate mode.
+	IPv4Prefix int `json:"ipv4Prefix,omitempty"`
+	IPv6Prefix int `json:"ipv6Prefix,omitempty"`
+	// HashKey keys the hash so that addresses cannot be recovered by hashing
+	// all candidates. Keep it stable to keep hashes comparable over time.
+	HashKey string `json:"hashKey,omitempty"`
+}
+
+// ipAnonymizer applies the configured anonymization to records.
+type ipAnonymizer struct {
+	config AnonymizeIPConfig
+}
+
+// newIPAnonymizer validates the settings and creates the anonymizer.
+func newIPAnonymizer(c AnonymizeIPConfig) (*ipAnonymizer, error) {
+	switch c.Mode {
+	case "none", "drop":
+	case "truncate":
+		if c.IPv4Prefix < 0 || c.IPv4Prefix > 32 {
+			return nil, fmt.Errorf("anonymizeIP.ipv4Prefix must be between 0 and 32")
+		}
+		if c.IPv6Prefix < 0 || c.IPv6Prefix > 128 {
+			return nil, fmt.Errorf("anonymizeIP.ipv6Prefix must be between 0 and 128")
+		}
+	case "hash":
+		if c.HashKey == "" {
+			return nil, fmt.Errorf("anonymizeIP.hashKey is required in hash mode")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported anonymizeIP.mode %q", c.Mode)
+	}
+	return &ipAnonymizer{config: c}, nil
+}
+
+// anonymize replaces the client address of data.
+func (a *ipAnonymizer) anonymize(data *RequestData) {
+	switch a.config.Mode {
+	case "truncate":
+		addr, err := netip.ParseAddr(data.IP)
+		if err != nil {
+			data.IP = ""
+			break
+		}
+		bits := a.config.IPv6Prefix
+		if addr.Is4() {
+			bits = a.config.IPv4Prefix
+		}
+		prefix, _ := addr.Prefix(bits)
+		data.IP = prefix.Addr().String()
+	case "hash":
+		mac := hmac.New(sha256.New, []byte(a.config.HashKey))
+		mac.Write([]byte(data.IP))
+		data.IP = hex.EncodeToString(mac.Sum(nil)[:16])
+	case "drop":
+		data.IP = ""
+	default:
+		return
+	}
+	data.ClientPort = 0
+}
@@ -0,0 +1,182 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ASNConfig enables enrichment with the autonomous system of the client.
+type ASNConfig struct {
+	// DatabasePath is a MaxMind GeoLite2/GeoIP2 ASN .mmdb file or an ip2asn
+	// TSV file (ip2asn-combined.tsv, optionally gzipped). Enrichment is
+	// disabled when empty.
+	DatabasePath   string `json:"databasePath,omitempty"`
+	ReloadInterval string `json:"reloadInterval,omitempty"`
+}
+
+// asnLookup resolves an address to its AS number and organization.
+type asnLookup interface {
+	lookup(ip string) (asn uint32, org string, ok bool)
+}
+
+// asnEnricher adds the AS number and organization to records.
+type asnEnricher struct {
+	db asnLookup
+}
+
+// newASNEnricher opens the configured database; the format is chosen by
+// file extension.
+func newASNEnricher(ctx context.Context, c ASNConfig) (*asnEnricher, error) {
+	interval, err := time.ParseDuration(c.ReloadInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid asn.reloadInterval %q", c.ReloadInterval)
+	}
+
+	var db asnLookup
+	if strings.HasSuffix(c.DatabasePath, ".mmdb") {
+		f, err := openMMDB(ctx, c.DatabasePath, interval)
+		if err != nil {
+			return nil, err
+		}
+		db = mmdbASN{f}
+	} else {
+		f, err := openIP2ASN(ctx, c.DatabasePath, interval)
+		if err != nil {
+			return nil, err
+		}
+		db = f
+	}
+	return &asnEnricher{db: db}, nil
+}
+
+// enrich sets the AS fields of data.
+func (e *asnEnricher) enrich(data *RequestData) {
+	asn, org, ok := e.db.lookup(data.IP)
+	if !ok {
+		return
+	}
+	data.ASN = asn
+	data.ASOrg = org
+}
+
+// mmdbASN looks up addresses in a MaxMind ASN database.
+type mmdbASN struct {
+	db *mmdbFile
+}
+
+func (m mmdbASN) lookup(ip string) (uint32, string, bool) {
+	var record struct {
+		Number       uint32 `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if !m.db.lookup(ip, &record) || record.Number == 0 {
+		return 0, "", false
+	}
+	return record.Number, record.Organization, true
+}
+
+// ip2asnRange is an address range of an ip2asn file.
+type ip2asnRange struct {
+	start, end netip.Addr
+	asn        uint32
+	org        string
+}
+
+// ip2asnFile holds an ip2asn TSV file in memory as ranges sorted by start
+// address and reloads it when it changes on disk.
+type ip2asnFile struct {
+	path string
+
+	mu     sync.RWMutex
+	ranges []ip2asnRange
+}
+
+// openIP2ASN loads the file and watches it for changes until ctx is done.
+func openIP2ASN(ctx context.Context, path string, reloadInterval time.Duration) (*ip2asnFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ip2asn database: %v", err)
+	}
+	f := &ip2asnFile{path: path}
+	err = f.reload()
+	if err != nil {
+		return nil, err
+	}
+	go watchFile(ctx, path, info.ModTime(), reloadInterval, f.reload)
+	return f, nil
+}
+
+// reload parses the file and replaces the ranges in use.
+func (f *ip2asnFile) reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to open ip2asn database: %v", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(f.path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to open ip2asn database: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// Each line is: range_start range_end AS_number country_code AS_description
+	var ranges []ip2asnRange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || asn == 0 {
+			// AS 0 marks unrouted space.
+			continue
+		}
+		start, err1 := netip.ParseAddr(fields[0])
+		end, err2 := netip.ParseAddr(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ranges = append(ranges, ip2asnRange{start: start, end: end, asn: uint32(asn), org: fields[4]})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ip2asn database: %v", err)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+
+	f.mu.Lock()
+	f.ranges = ranges
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *ip2asnFile) lookup(ip string) (uint32, string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, "", false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// Find the last range that starts at or before addr.
+	i := sort.Search(len(f.ranges), func(i int) bool { return addr.Less(f.ranges[i].start) }) - 1
+	if i < 0 || f.ranges[i].end.Less(addr) {
+		return 0, "", false
+	}
+	return f.ranges[i].asn, f.ranges[i].org, true
+}
@@ -0,0 +1,186 @@
+package traefik_analytics
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure policies, applied when the queue of a backend is full.
+const (
+	// backpressureDropNewest discards the new record.
+	backpressureDropNewest = "dropNewest"
+	// backpressureDropOldest discards the oldest queued record instead.
+	backpressureDropOldest = "dropOldest"
+	// backpressureBlock waits up to BlockTimeout for space in the queue,
+	// delaying the response.
+	backpressureBlock = "block"
+	// backpressureSample records a decreasing fraction of requests once the
+	// queue is half full, and drops the new record when it is full.
+	backpressureSample = "sample"
+)
+
+// overflowLogInterval limits how often dropped records are logged.
+const overflowLogInterval = 10 * time.Second
+
+// recordBaseSize is the approximate size of a RequestData without the
+// contents of its strings and maps.
+const recordBaseSize = 1024
+
+// stats exposes the counters of all outputs, keyed by middleware name and
+// backend, e.g. "analytics@file/postgres/dropped". Traefik serves them at
+// /debug/vars when its API runs in debug mode.
+var stats = expvar.NewMap("traefik_analytics")
+
+// BackpressureConfig sets what happens to records while a backend falls
+// behind and its queue is full.
+type BackpressureConfig struct {
+	// Policy is "dropNewest", "dropOldest", "block" or "sample".
+	Policy       string `json:"policy,omitempty"`
+	BlockTimeout string `json:"blockTimeout,omitempty"`
+}
+
+// backpressure applies the overflow policy of an output, accounts for the
+// memory held by its queue and counts the affected records.
+type backpressure struct {
+	policy       string
+	blockTimeout time.Duration
+	// maxBytes caps the estimated memory of the queued records; zero
+	// means no cap.
+	maxBytes    int64
+	statsPrefix string
+	storageType string
+
+	dropped   atomic.Int64
+	lastLog   atomic.Int64
+	queued    atomic.Int64
+	highWater atomic.Int64
+}
+
+// validateBackpressureConfig checks the backpressure settings.
+func validateBackpressureConfig(c BackpressureConfig) error {
+	switch c.Policy {
+	case backpressureDropNewest, backpressureDropOldest, backpressureSample:
+	case backpressureBlock:
+		timeout, err := time.ParseDuration(c.BlockTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid backpressure.blockTimeout %q", c.BlockTimeout)
+		}
+	default:
+		return fmt.Errorf("unsupported backpressure.policy %q", c.Policy)
+	}
+	return nil
+}
+
+// newBackpressure creates the overflow handling of the given queue and
+// publishes its depth, high-water mark and estimated memory.
+func newBackpressure(c BackpressureConfig, maxBytes int64, queue chan RequestData, name, storageType string) *backpressure {
+	timeout, _ := time.ParseDuration(c.BlockTimeout)
+	b := &backpressure{
+		policy:       c.Policy,
+		blockTimeout: timeout,
+		maxBytes:     maxBytes,
+		statsPrefix:  name + "/" + storageType + "/",
+		storageType:  storageType,
+	}
+	stats.Set(b.statsPrefix+"queueDepth", expvar.Func(func() interface{} { return len(queue) }))
+	stats.Set(b.statsPrefix+"queueHighWater", expvar.Func(func() interface{} { return b.highWater.Load() }))
+	stats.Set(b.statsPrefix+"queueBytes", expvar.Func(func() interface{} { return b.queued.Load() }))
+	return b
+}
+
+// recordSize estimates the memory held by a queued record.
+func recordSize(data *RequestData) int64 {
+	size := recordBaseSize + len(data.UserAgent) + len(data.Path) + len(data.RawPath) + len(data.QueryString) +
+		len(data.Referer) + len(data.AcceptLanguage) + len(data.Host) + len(data.TLSFingerprint) + len(data.RDNS)
+	for _, m := range []map[string]string{data.Headers, data.Cookies, data.ResponseHeaders, data.Extra} {
+		for k, v := range m {
+			size += len(k) + len(v) + 16
+		}
+	}
+	return int64(size)
+}
+
+// enqueue adds data to queue according to the policy, without blocking
+// longer than the block timeout. Records beyond the memory cap are
+// discarded whatever the policy.
+func (b *backpressure) enqueue(queue chan RequestData, data RequestData) {
+	size := recordSize(&data)
+	if b.maxBytes > 0 && b.queued.Load()+size > b.maxBytes {
+		b.drop("queue full")
+		return
+	}
+	if b.policy == backpressureSample {
+		free, half := cap(queue)-len(queue), cap(queue)/2
+		if free < half && rand.Intn(half) >= free {
+			stats.Add(b.statsPrefix+"sampledOut", 1)
+			return
+		}
+	}
+
+	select {
+	case queue <- data:
+		b.enqueued(queue, size)
+		return
+	default:
+	}
+
+	switch b.policy {
+	case backpressureDropOldest:
+		select {
+		case oldest := <-queue:
+			b.dequeued(&oldest)
+			b.drop("queue full")
+		default:
+		}
+		select {
+		case queue <- data:
+			b.enqueued(queue, size)
+			return
+		default:
+		}
+	case backpressureBlock:
+		timer := time.NewTimer(b.blockTimeout)
+		defer timer.Stop()
+		select {
+		case queue <- data:
+			b.enqueued(queue, size)
+			return
+		case <-timer.C:
+		}
+	}
+	b.drop("queue full")
+}
+
+// enqueued accounts for a record of the given size added to queue.
+func (b *backpressure) enqueued(queue chan RequestData, size int64) {
+	b.queued.Add(size)
+	depth := int64(len(queue))
+	for {
+		high := b.highWater.Load()
+		if depth <= high || b.highWater.CompareAndSwap(high, depth) {
+			return
+		}
+	}
+}
+
+// dequeued accounts for a record taken from the queue.
+func (b *backpressure) dequeued(data *RequestData) {
+	b.queued.Add(-recordSize(data))
+}
+
+// drop counts a record discarded for reason and logs the count
+// periodically.
+func (b *backpressure) drop(reason string) {
+	dropped := b.dropped.Add(1)
+	stats.Add(b.statsPrefix+"dropped", 1)
+
+	now := time.Now().UnixNano()
+	last := b.lastLog.Load()
+	if now-last >= int64(overflowLogInterval) && b.lastLog.CompareAndSwap(last, now) {
+		log.Printf("Analytics records for %s discarded (%s), %d so far", b.storageType, reason, dropped)
+	}
+}
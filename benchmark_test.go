@@ -0,0 +1,133 @@
+package traefik_analytics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// benchmarkSink discards records, counting them.
+type benchmarkSink struct{}
+
+var benchmarkStored atomic.Int64
+
+func (benchmarkSink) Write(ctx context.Context, batch []RequestData) error {
+	benchmarkStored.Add(int64(len(batch)))
+	return nil
+}
+
+func (benchmarkSink) Close() error { return nil }
+
+func init() {
+	registerSink("benchmark", func(config *Config) (Sink, error) { return benchmarkSink{}, nil }, nil)
+}
+
+// newBenchmarkHandler creates the middleware with the benchmark sink in
+// front of a handler writing a small response.
+func newBenchmarkHandler(b *testing.B, configure func(config *Config)) http.Handler {
+	config := CreateConfig()
+	config.StorageType = "benchmark"
+	config.QueueSize = 100000
+	config.Visitor.Enabled = true
+	config.Visitor.Secret = "benchmark"
+	if configure != nil {
+		configure(config)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte("<html></html>"))
+	})
+	handler, err := New(ctx, next, config, "benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return handler
+}
+
+// newBenchmarkRequest returns a typical browser request.
+func newBenchmarkRequest(i int) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/products/"+strconv.Itoa(i%100)+"?utm_source=newsletter", nil)
+	req.RemoteAddr = "203.0.113." + strconv.Itoa(i%250) + ":51234"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Referer", "https://www.google.com/")
+	return req
+}
+
+func benchmarkServeHTTP(b *testing.B, configure func(config *Config)) {
+	handler := newBenchmarkHandler(b, configure)
+	requests := make([]*http.Request, 256)
+	for i := range requests {
+		requests[i] = newBenchmarkRequest(i)
+	}
+	rw := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(rw, requests[i%len(requests)])
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	benchmarkServeHTTP(b, nil)
+}
+
+func BenchmarkServeHTTPWithoutEnrichment(b *testing.B) {
+	benchmarkServeHTTP(b, func(config *Config) {
+		config.UserAgent.Parse = false
+		config.Bots.Detect = false
+		config.Referrer.Classify = false
+		config.Visitor.Enabled = false
+	})
+}
+
+func BenchmarkServeHTTPCapturingHeaders(b *testing.B) {
+	benchmarkServeHTTP(b, func(config *Config) {
+		config.RequestHeaders = []string{"Accept-Language", "Referer", "X-Missing"}
+		config.ResponseHeaders = []string{"Content-Type"}
+	})
+}
+
+func BenchmarkServeHTTPParallel(b *testing.B) {
+	handler := newBenchmarkHandler(b, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rw := httptest.NewRecorder()
+		i := 0
+		for pb.Next() {
+			handler.ServeHTTP(rw, newBenchmarkRequest(i))
+			i++
+		}
+	})
+}
+
+func BenchmarkHeaderCapture(b *testing.B) {
+	capture := newHeaderCapture([]string{"accept-language", "referer", "x-missing"})
+	header := newBenchmarkRequest(0).Header
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		capture.capture(header)
+	}
+}
+
+func BenchmarkRecordSize(b *testing.B) {
+	data := RequestData{
+		IP:        "203.0.113.7",
+		UserAgent: "Mozilla/5.0",
+		Path:      "/products/1",
+		Headers:   map[string]string{"referer": "https://www.google.com/"},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recordSize(&data)
+	}
+}
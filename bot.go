@@ -0,0 +1,240 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// BotConfig controls the detection of bots and crawlers.
+type BotConfig struct {
+	Detect bool `json:"detect,omitempty"`
+	// UserAgentPatterns extend the built-in list of crawler User-Agents.
+	UserAgentPatterns []BotPattern `json:"userAgentPatterns,omitempty"`
+	// IPRanges lists address ranges of known crawlers. Requests from these
+	// ranges are reported as bots whatever their User-Agent.
+	IPRanges []BotIPRange `json:"ipRanges,omitempty"`
+	// VerifyDNS checks clients claiming to be a well-known search engine
+	// crawler with a forward-confirmed reverse DNS lookup. Clients failing
+	// the check are still bots, but their name is marked as unverified.
+	// Records whose address is stripped for a privacy signal cannot be
+	// checked and keep the claimed name.
+	VerifyDNS  bool   `json:"verifyDNS,omitempty"`
+	DNSTimeout string `json:"dnsTimeout,omitempty"`
+}
+
+// BotPattern names the bot whose User-Agent contains Pattern, matched
+// case-insensitively.
+type BotPattern struct {
+	Name    string `json:"name,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// BotIPRange names the bot using the given addresses or CIDRs.
+type BotIPRange struct {
+	Name  string   `json:"name,omitempty"`
+	CIDRs []string `json:"cidrs,omitempty"`
+}
+
+// botPattern is a User-Agent pattern with the domains its reverse DNS
+// names must belong to, if the operator publishes them.
+type botPattern struct {
+	name    string
+	pattern string
+	domains []string
+}
+
+// knownBots are matched in order against the lowercased User-Agent.
+var knownBots = []botPattern{
+	{"Googlebot", "googlebot", []string{".googlebot.com", ".google.com", ".googleusercontent.com"}},
+	{"Google-InspectionTool", "google-inspectiontool", []string{".googlebot.com", ".google.com"}},
+	{"AdsBot-Google", "adsbot-google", []string{".googlebot.com", ".google.com"}},
+	{"Mediapartners-Google", "mediapartners-google", []string{".googlebot.com", ".google.com"}},
+	{"Bingbot", "bingbot", []string{".search.msn.com"}},
+	{"YandexBot", "yandex", []string{".yandex.ru", ".yandex.net", ".yandex.com"}},
+	{"Baiduspider", "baiduspider", []string{".baidu.com", ".baidu.jp"}},
+	{"Applebot", "applebot", []string{".applebot.apple.com"}},
+	{"PetalBot", "petalbot", []string{".petalsearch.com"}},
+	{"DuckDuckBot", "duckduckbot", nil},
+	{"GPTBot", "gptbot", nil},
+	{"ClaudeBot", "claudebot", nil},
+	{"CCBot", "ccbot", nil},
+	{"Bytespider", "bytespider", nil},
+	{"AhrefsBot", "ahrefsbot", nil},
+	{"SemrushBot", "semrushbot", nil},
+	{"MJ12bot", "mj12bot", nil},
+	{"DotBot", "dotbot", nil},
+	{"facebookexternalhit", "facebookexternalhit", nil},
+	{"Twitterbot", "twitterbot", nil},
+	{"LinkedInBot", "linkedinbot", nil},
+	{"Slackbot", "slackbot", nil},
+	{"Discordbot", "discordbot", nil},
+	{"UptimeRobot", "uptimerobot", nil},
+	{"Pingdom", "pingdom", nil},
+	{"HeadlessChrome", "headlesschrome", nil},
+	{"curl", "curl/", nil},
+	{"Wget", "wget/", nil},
+	{"python-requests", "python-requests/", nil},
+	{"Go-http-client", "go-http-client/", nil},
+}
+
+// genericBotTokens flag unnamed automated clients.
+var genericBotTokens = []string{"bot", "crawler", "spider", "slurp"}
+
+// botRange is a parsed BotIPRange entry.
+type botRange struct {
+	name   string
+	prefix netip.Prefix
+}
+
+// botEnricher sets the bot fields of records. The DNS verification of
+// crawler names is left to a botVerifier, as it blocks on lookups.
+type botEnricher struct {
+	patterns []botPattern
+	ranges   []botRange
+
+	verify  bool
+	timeout time.Duration
+}
+
+func newBotEnricher(c BotConfig) (*botEnricher, error) {
+	e := &botEnricher{verify: c.VerifyDNS}
+	for _, p := range c.UserAgentPatterns {
+		if p.Pattern == "" {
+			return nil, fmt.Errorf("bots.userAgentPatterns entry %q has no pattern", p.Name)
+		}
+		e.patterns = append(e.patterns, botPattern{name: p.Name, pattern: strings.ToLower(p.Pattern)})
+	}
+	e.patterns = append(e.patterns, knownBots...)
+
+	for _, r := range c.IPRanges {
+		for _, raw := range r.CIDRs {
+			prefix, err := netip.ParsePrefix(raw)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(raw)
+				if addrErr != nil {
+					return nil, fmt.Errorf("invalid bots.ipRanges entry %q", raw)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			e.ranges = append(e.ranges, botRange{name: r.Name, prefix: prefix.Masked()})
+		}
+	}
+
+	if c.VerifyDNS {
+		timeout, err := time.ParseDuration(c.DNSTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid bots.dnsTimeout %q", c.DNSTimeout)
+		}
+		e.timeout = timeout
+	}
+	return e, nil
+}
+
+func (e *botEnricher) enrich(data *RequestData) {
+	if addr, err := netip.ParseAddr(data.IP); err == nil {
+		for _, r := range e.ranges {
+			if r.prefix.Contains(addr) {
+				data.IsBot = true
+				data.BotName = r.name
+				return
+			}
+		}
+	}
+
+	ua := strings.ToLower(data.UserAgent)
+	for _, p := range e.patterns {
+		if !strings.Contains(ua, p.pattern) {
+			continue
+		}
+		data.IsBot = true
+		data.BotName = p.name
+		if e.verify {
+			data.botDomains = p.domains
+		}
+		return
+	}
+	for _, token := range genericBotTokens {
+		if strings.Contains(ua, token) {
+			data.IsBot = true
+			return
+		}
+	}
+}
+
+// botVerifier marks the names of crawlers found by a botEnricher as
+// unverified when their address fails the reverse DNS check.
+type botVerifier struct {
+	timeout  time.Duration
+	verified *lruCache
+}
+
+func newBotVerifier(bots *botEnricher) *botVerifier {
+	return &botVerifier{timeout: bots.timeout, verified: newLRUCache(10000)}
+}
+
+func (e *botVerifier) enrich(data *RequestData) {
+	domains := data.botDomains
+	data.botDomains = nil
+	if len(domains) == 0 || data.IP == "" {
+		return
+	}
+	if !e.verifyDNS(data.IP, domains) {
+		data.BotName += " (unverified)"
+	}
+}
+
+// verifyDNS reports whether ip has a reverse DNS name under one of domains
+// that resolves back to ip. Results are cached per address and domain list,
+// including failures, so each crawler address costs at most one lookup.
+func (e *botVerifier) verifyDNS(ip string, domains []string) bool {
+	key := ip + " " + domains[0]
+	if ok, found := e.verified.get(key); found {
+		return ok.(bool)
+	}
+	ok := forwardConfirmed(ip, domains, e.timeout)
+	e.verified.add(key, ok)
+	return ok
+}
+
+// forwardConfirmed performs a forward-confirmed reverse DNS check of ip
+// within timeout.
+func forwardConfirmed(ip string, domains []string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if !hasDomainSuffix(name, domains) {
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == ip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasDomainSuffix reports whether name ends with one of domains, each
+// given with a leading dot.
+func hasDomainSuffix(name string, domains []string) bool {
+	for _, domain := range domains {
+		if strings.HasSuffix(name, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig stops writing to a failing backend for a while, so
+// that batches go straight to the spill file, or are discarded, instead of
+// waiting for timeouts.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed writes that
+	// opens the circuit. Zero disables the breaker.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// OpenDuration is the time after which a single probe write is let
+	// through; the circuit closes again if it succeeds.
+	OpenDuration string `json:"openDuration,omitempty"`
+}
+
+// RetryBackoffConfig bounds the exponential backoff between attempts to
+// connect to a backend.
+type RetryBackoffConfig struct {
+	InitialInterval string `json:"initialInterval,omitempty"`
+	MaxInterval     string `json:"maxInterval,omitempty"`
+}
+
+// validateRetryBackoffConfig checks the backoff settings.
+func validateRetryBackoffConfig(c RetryBackoffConfig) error {
+	initial, err := time.ParseDuration(c.InitialInterval)
+	if err != nil || initial <= 0 {
+		return fmt.Errorf("invalid retryBackoff.initialInterval %q", c.InitialInterval)
+	}
+	maxInterval, err := time.ParseDuration(c.MaxInterval)
+	if err != nil || maxInterval < initial {
+		return fmt.Errorf("invalid retryBackoff.maxInterval %q, must not be below the initial interval", c.MaxInterval)
+	}
+	return nil
+}
+
+// Circuit states.
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker tracks the failures of the writes to a backend.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+	storageType  string
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// validateCircuitBreakerConfig checks the circuit breaker settings.
+func validateCircuitBreakerConfig(c CircuitBreakerConfig) error {
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("circuitBreaker.failureThreshold must not be negative")
+	}
+	if c.FailureThreshold == 0 {
+		return nil
+	}
+	d, err := time.ParseDuration(c.OpenDuration)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid circuitBreaker.openDuration %q", c.OpenDuration)
+	}
+	return nil
+}
+
+func newCircuitBreaker(c CircuitBreakerConfig, storageType string) *circuitBreaker {
+	openDuration, _ := time.ParseDuration(c.OpenDuration)
+	return &circuitBreaker{threshold: c.FailureThreshold, openDuration: openDuration, storageType: storageType}
+}
+
+// allow reports whether a write may be attempted. Once the open duration
+// has passed, one probe is allowed at a time.
+func (b *circuitBreaker) allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// record reports the outcome of an allowed write.
+func (b *circuitBreaker) record(ok bool) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		if b.state != circuitClosed {
+			log.Printf("Circuit for %s closed, resuming writes", b.storageType)
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		log.Printf("Circuit for %s opened after %d failed writes, pausing writes for %s", b.storageType, b.failures, b.openDuration)
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,159 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ClientIPConfig controls how the client address of a request is resolved
+// when Traefik sits behind other proxies such as a CDN or load balancer.
+type ClientIPConfig struct {
+	// TrustedProxies lists the addresses or CIDRs of proxies whose forwarding
+	// headers are trusted. Headers are ignored for requests from any other
+	// peer, so the default empty list always records the peer address.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// Headers are checked in order and the first one present is used.
+	// "Forwarded" is parsed as RFC 7239; any other header is read as a
+	// comma-separated list of addresses like X-Forwarded-For.
+	Headers []string `json:"headers,omitempty"`
+	// Strategy picks the address from the header list:
+	//   - "rightmost-untrusted" skips trusted proxies from the right, which
+	//     cannot be spoofed by the client;
+	//   - "leftmost" takes the first entry, which the client controls;
+	//   - "depth" takes the Depth-th entry from the right.
+	Strategy string `json:"strategy,omitempty"`
+	Depth    int    `json:"depth,omitempty"`
+}
+
+// clientIPResolver resolves the client address of a request.
+type clientIPResolver struct {
+	trusted  []netip.Prefix
+	headers  []string
+	strategy string
+	depth    int
+}
+
+// newClientIPResolver validates the settings and creates the resolver.
+func newClientIPResolver(c ClientIPConfig) (*clientIPResolver, error) {
+	trusted, err := parsePrefixes("clientIP.trustedProxies", c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	r := &clientIPResolver{trusted: trusted, strategy: c.Strategy, depth: c.Depth}
+	for _, header := range c.Headers {
+		r.headers = append(r.headers, http.CanonicalHeaderKey(header))
+	}
+
+	switch c.Strategy {
+	case "rightmost-untrusted", "leftmost":
+	case "depth":
+		if c.Depth < 1 {
+			return nil, fmt.Errorf("clientIP.depth must be at least 1")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported clientIP.strategy %q", c.Strategy)
+	}
+	return r, nil
+}
+
+// isTrusted reports whether addr belongs to a trusted proxy.
+func (r *clientIPResolver) isTrusted(addr netip.Addr) bool {
+	return prefixesContain(r.trusted, addr)
+}
+
+// resolve returns the client address of req without port, and the client
+// port if known. The forwarding headers are only consulted when the direct
+// peer is a trusted proxy; if they hold no usable address the peer address
+// is returned. Forwarding headers rarely include the client port, in which
+// case it is reported as 0.
+func (r *clientIPResolver) resolve(req *http.Request) (string, int) {
+	peer, peerPort, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr, 0
+	}
+	if !r.isTrusted(peer) {
+		return peer.String(), peerPort
+	}
+
+	var chain []string
+	for _, header := range r.headers {
+		values := req.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+		if header == "Forwarded" {
+			chain = parseForwardedFor(values)
+		} else {
+			for _, value := range values {
+				for _, entry := range strings.Split(value, ",") {
+					chain = append(chain, strings.TrimSpace(entry))
+				}
+			}
+		}
+		break
+	}
+
+	var addr netip.Addr
+	var port int
+	switch r.strategy {
+	case "leftmost":
+		if len(chain) > 0 {
+			addr, port, ok = parseAddr(chain[0])
+		}
+	case "depth":
+		if len(chain) >= r.depth {
+			addr, port, ok = parseAddr(chain[len(chain)-r.depth])
+		}
+	default:
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, port, ok = parseAddr(chain[i])
+			if !ok || !r.isTrusted(addr) {
+				break
+			}
+		}
+	}
+	if !ok || !addr.IsValid() {
+		return peer.String(), peerPort
+	}
+	return addr.String(), port
+}
+
+// parseAddr parses an address as found in RemoteAddr or a forwarding header:
+// with or without port, IPv6 optionally in brackets. IPv4-mapped IPv6
+// addresses are reported as IPv4 and zones are dropped. The port is 0 if
+// absent.
+func parseAddr(raw string) (netip.Addr, int, bool) {
+	raw = strings.TrimSpace(raw)
+	port := 0
+	if host, rawPort, err := net.SplitHostPort(raw); err == nil {
+		raw = host
+		port, _ = strconv.Atoi(rawPort)
+	}
+	raw = strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Addr{}, 0, false
+	}
+	return addr.Unmap().WithZone(""), port, true
+}
+
+// parseForwardedFor returns the for= values of RFC 7239 Forwarded headers,
+// one per forwarded element, in order.
+func parseForwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					chain = append(chain, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return chain
+}
@@ -0,0 +1,250 @@
+// Command loadgen sends synthetic or recorded requests through the
+// middleware and reports its throughput, allocations and the records that
+// were dropped before reaching the backend.
+//
+// Records go to a fake backend that stores nothing, whose latency and
+// error rate can be set to see how the middleware behaves with a slow or
+// failing backend. Recorded traffic is read from JSON lines as written by
+// the file backend.
+//
+//	go run ./cmd/loadgen -requests 200000 -concurrency 16 -sink-latency 5ms
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	traefik_analytics "github.com/lastvolt/traefik-analytics"
+)
+
+// fakeSink discards records after a delay, failing a fraction of the
+// batches.
+type fakeSink struct {
+	latency  time.Duration
+	failRate float64
+	stored   *atomic.Int64
+}
+
+func (s *fakeSink) Write(ctx context.Context, batch []traefik_analytics.RequestData) error {
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+	}
+	if s.failRate > 0 && rand.Float64() < s.failRate {
+		return errors.New("simulated backend failure")
+	}
+	s.stored.Add(int64(len(batch)))
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func main() {
+	requests := flag.Int("requests", 100000, "number of requests to send")
+	concurrency := flag.Int("concurrency", runtime.GOMAXPROCS(0), "number of concurrent clients")
+	replay := flag.String("replay", "", "JSON lines file of recorded requests, as written by the file backend")
+	configPath := flag.String("config", "", "JSON file with plugin settings applied over the defaults")
+	latency := flag.Duration("sink-latency", 0, "time the fake backend takes per batch")
+	failRate := flag.Float64("sink-fail-rate", 0, "fraction of batches the fake backend fails")
+	drain := flag.Duration("drain-timeout", 30*time.Second, "time to wait for queued records after the last request")
+	flag.Parse()
+
+	config := traefik_analytics.CreateConfig()
+	if *configPath != "" {
+		raw, err := os.ReadFile(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to read config: %v", err)
+		}
+		if err := json.Unmarshal(raw, config); err != nil {
+			log.Fatalf("Failed to parse config: %v", err)
+		}
+	}
+	config.StorageType = "loadgen"
+	config.StorageTypes = nil
+
+	var stored atomic.Int64
+	traefik_analytics.RegisterSink("loadgen", func(*traefik_analytics.Config) (traefik_analytics.Sink, error) {
+		return &fakeSink{latency: *latency, failRate: *failRate, stored: &stored}, nil
+	})
+
+	templates, err := loadTemplates(*replay)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte("<html></html>"))
+	})
+	handler, err := traefik_analytics.New(ctx, next, config, "loadgen")
+	if err != nil {
+		log.Fatalf("Failed to create middleware: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for c := 0; c < *concurrency; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each client has its own requests, as the middleware may set
+			// headers on them.
+			reqs := make([]*http.Request, len(templates))
+			for i, tmpl := range templates {
+				reqs[i] = tmpl.newRequest()
+			}
+			rw := httptest.NewRecorder()
+			for i := 0; sent.Add(1) <= int64(*requests); i++ {
+				handler.ServeHTTP(rw, reqs[i%len(reqs)])
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	deadline := time.Now().Add(*drain)
+	for time.Now().Before(deadline) && stored.Load()+counter("dropped")+counter("circuitOpen") < int64(*requests) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	n := float64(*requests)
+	fmt.Printf("requests:        %d in %s (%.0f req/s)\n", *requests, elapsed.Round(time.Millisecond), n/elapsed.Seconds())
+	fmt.Printf("per request:     %.0f ns, %.1f allocs, %.0f bytes\n",
+		float64(elapsed.Nanoseconds())/n, float64(after.Mallocs-before.Mallocs)/n, float64(after.TotalAlloc-before.TotalAlloc)/n)
+	fmt.Printf("stored:          %d (%.2f%%)\n", stored.Load(), 100*float64(stored.Load())/n)
+	fmt.Printf("dropped:         %d (%.2f%%)\n", counter("dropped"), 100*float64(counter("dropped"))/n)
+	fmt.Printf("sampled out:     %d\n", counter("sampledOut"))
+	fmt.Printf("circuit open:    %d\n", counter("circuitOpen"))
+	fmt.Printf("queue high mark: %d\n", counter("queueHighWater"))
+}
+
+// counter returns the statistic of the fake backend with the given name.
+func counter(name string) int64 {
+	stats, ok := expvar.Get("traefik_analytics").(*expvar.Map)
+	if !ok {
+		return 0
+	}
+	v := stats.Get("loadgen/loadgen/" + name)
+	if v == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v.String(), 10, 64)
+	return n
+}
+
+// requestTemplate describes a request to send.
+type requestTemplate struct {
+	method, url, ip, userAgent, referer, acceptLanguage string
+}
+
+func (t requestTemplate) newRequest() *http.Request {
+	req := httptest.NewRequest(t.method, t.url, nil)
+	req.RemoteAddr = t.ip + ":51234"
+	req.Header.Set("User-Agent", t.userAgent)
+	if t.referer != "" {
+		req.Header.Set("Referer", t.referer)
+	}
+	if t.acceptLanguage != "" {
+		req.Header.Set("Accept-Language", t.acceptLanguage)
+	}
+	return req
+}
+
+// loadTemplates reads the requests recorded in path, or generates
+// synthetic ones if path is empty.
+func loadTemplates(path string) ([]requestTemplate, error) {
+	if path == "" {
+		return syntheticTemplates(), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recorded requests: %v", err)
+	}
+	defer file.Close()
+
+	var templates []requestTemplate
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var data traefik_analytics.RequestData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+		if data.Method == "" || data.Host == "" {
+			continue
+		}
+		url := "http://" + data.Host + data.Path
+		if data.QueryString != "" {
+			url += "?" + data.QueryString
+		}
+		ip := data.IP
+		if ip == "" || strings.Contains(ip, ":") {
+			ip = "192.0.2.1"
+		}
+		templates = append(templates, requestTemplate{
+			method:         data.Method,
+			url:            url,
+			ip:             ip,
+			userAgent:      data.UserAgent,
+			referer:        data.Referer,
+			acceptLanguage: data.AcceptLanguage,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recorded requests: %v", err)
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no requests found in %s", path)
+	}
+	return templates, nil
+}
+
+// syntheticTemplates returns a mix of browser, bot and API requests from
+// a few thousand clients.
+func syntheticTemplates() []requestTemplate {
+	userAgents := []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"curl/8.7.1",
+	}
+	referers := []string{"", "https://www.google.com/", "https://news.ycombinator.com/"}
+	paths := []string{"/", "/products/42", "/blog/launch?utm_source=newsletter", "/api/v1/items", "/static/app.js"}
+
+	rng := rand.New(rand.NewSource(1))
+	templates := make([]requestTemplate, 4096)
+	for i := range templates {
+		templates[i] = requestTemplate{
+			method:         http.MethodGet,
+			url:            "http://example.com" + paths[rng.Intn(len(paths))],
+			ip:             fmt.Sprintf("198.51.%d.%d", rng.Intn(16), rng.Intn(256)),
+			userAgent:      userAgents[rng.Intn(len(userAgents))],
+			referer:        referers[rng.Intn(len(referers))],
+			acceptLanguage: "en-US,en;q=0.9",
+		}
+	}
+	return templates
+}
@@ -0,0 +1,73 @@
+package traefik_analytics
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// sizeDecoder decompresses a response body as it is written, only to count
+// its uncompressed size. Decoding runs in its own goroutine fed through a
+// pipe, so the stream is processed incrementally without buffering it.
+type sizeDecoder struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	size int64
+	err  error
+}
+
+// newSizeDecoder starts a decoder for the given Content-Encoding, or returns
+// nil if the encoding is not supported.
+func newSizeDecoder(encoding string) *sizeDecoder {
+	var open func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		open = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		// HTTP "deflate" is the zlib format.
+		open = func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }
+	case "zstd":
+		open = func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		}
+	default:
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	d := &sizeDecoder{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		r, err := open(pr)
+		if err == nil {
+			d.size, err = io.Copy(io.Discard, r)
+			if dec, ok := r.(*zstd.Decoder); ok {
+				dec.Close()
+			}
+		}
+		d.err = err
+		// Unblock the writer if decoding stopped early.
+		pr.CloseWithError(io.ErrClosedPipe)
+	}()
+	return d
+}
+
+// Write feeds compressed bytes to the decoder. Errors are not returned;
+// they only make the size unknown.
+func (d *sizeDecoder) Write(b []byte) {
+	d.pw.Write(b)
+}
+
+// finish ends the stream and returns the uncompressed size, or false if the
+// body could not be decoded.
+func (d *sizeDecoder) finish() (int64, bool) {
+	d.pw.Close()
+	<-d.done
+	if d.err != nil {
+		return 0, false
+	}
+	return d.size, true
+}
@@ -0,0 +1,59 @@
+package traefik_analytics
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedConns bounds the memory used by connTracker.
+const maxTrackedConns = 100000
+
+// connTracker guesses whether a request arrived on a reused keep-alive
+// connection. Middlewares cannot see the connection itself, but all
+// requests of a connection share the peer address and port, and a new
+// connection almost always gets a new source port. A peer address seen
+// within the idle timeout is therefore taken as a reused connection.
+type connTracker struct {
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+// newConnTracker creates a tracker for the given server idle timeout.
+func newConnTracker(idleTimeout time.Duration) *connTracker {
+	return &connTracker{
+		idleTimeout: idleTimeout,
+		lastSeen:    map[string]time.Time{},
+	}
+}
+
+// seen records a request from remoteAddr at now and reports whether the
+// connection was already in use.
+func (t *connTracker) seen(remoteAddr string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.lastSeen[remoteAddr]
+	reused := ok && now.Sub(last) <= t.idleTimeout
+
+	if !ok && len(t.lastSeen) >= maxTrackedConns {
+		t.prune(now)
+	}
+	t.lastSeen[remoteAddr] = now
+	return reused
+}
+
+// prune drops the connections idle for longer than the timeout. If that
+// frees too little, the table is reset; affected requests are then reported
+// as new connections.
+func (t *connTracker) prune(now time.Time) {
+	for addr, last := range t.lastSeen {
+		if now.Sub(last) > t.idleTimeout {
+			delete(t.lastSeen, addr)
+		}
+	}
+	if len(t.lastSeen) >= maxTrackedConns*9/10 {
+		t.lastSeen = map[string]time.Time{}
+	}
+}
@@ -0,0 +1,74 @@
+package traefik_analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// CookieConfig selects the cookies recorded in the cookies column. The
+// Cookie header itself is never stored.
+type CookieConfig struct {
+	Names []string `json:"names,omitempty"`
+	// Hash lists cookies of Names whose values are stored as a keyed hash,
+	// e.g. session IDs that should be countable but not replayable.
+	Hash []string `json:"hash,omitempty"`
+	// HashKey keys the hash so that values cannot be confirmed by hashing
+	// guesses. Keep it stable to keep hashes comparable over time.
+	HashKey string `json:"hashKey,omitempty"`
+}
+
+// cookieCapture extracts the configured cookies from a request.
+type cookieCapture struct {
+	names  []string
+	hashed map[string]bool
+	key    []byte
+}
+
+// newCookieCapture validates the settings and creates the capture.
+func newCookieCapture(c CookieConfig) (*cookieCapture, error) {
+	cc := &cookieCapture{
+		names:  c.Names,
+		hashed: map[string]bool{},
+		key:    []byte(c.HashKey),
+	}
+	listed := map[string]bool{}
+	for _, name := range c.Names {
+		listed[name] = true
+	}
+	for _, name := range c.Hash {
+		if !listed[name] {
+			return nil, fmt.Errorf("cookies.hash entry %q is not listed in cookies.names", name)
+		}
+		cc.hashed[name] = true
+	}
+	return cc, nil
+}
+
+// capture returns the configured cookies of req keyed by name, or nil if
+// the request carries none of them.
+func (cc *cookieCapture) capture(req *http.Request) map[string]string {
+	if len(cc.names) == 0 {
+		return nil
+	}
+	var captured map[string]string
+	for _, name := range cc.names {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			continue
+		}
+		value := cookie.Value
+		if cc.hashed[name] {
+			mac := hmac.New(sha256.New, cc.key)
+			mac.Write([]byte(value))
+			value = hex.EncodeToString(mac.Sum(nil)[:16])
+		}
+		if captured == nil {
+			captured = make(map[string]string, len(cc.names))
+		}
+		captured[name] = value
+	}
+	return captured
+}
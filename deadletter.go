@@ -0,0 +1,102 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadLetterConfig keeps records that a backend rejected, e.g. for a
+// constraint violation, together with the error, so that they can be
+// inspected and replayed. Without a destination they are only logged.
+type DeadLetterConfig struct {
+	// Path appends rejected records as JSON lines to a local file.
+	Path string `json:"path,omitempty"`
+	// Table stores records rejected by the postgres, mysql, sqlite and
+	// timescaledb backends in a table of the same database and schema,
+	// which is created if missing. It takes precedence over Path for them.
+	Table string `json:"table,omitempty"`
+}
+
+// validateDeadLetterConfig checks the dead-letter settings.
+func validateDeadLetterConfig(c DeadLetterConfig) error {
+	if strings.ContainsRune(c.Table, 0) {
+		return fmt.Errorf("invalid deadLetter.table %q", c.Table)
+	}
+	return nil
+}
+
+// deadLetter is a rejected record as stored in the dead-letter file.
+type deadLetter struct {
+	Time    time.Time   `json:"time"`
+	Backend string      `json:"backend"`
+	Error   string      `json:"error"`
+	Record  RequestData `json:"record"`
+}
+
+// deadLetterWriter is implemented by sinks that store rejected records
+// themselves, such as in a table.
+type deadLetterWriter interface {
+	writeDeadLetters(ctx context.Context, letters []deadLetter) error
+}
+
+// deadLetterFile appends dead letters to a local file.
+type deadLetterFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (f *deadLetterFile) write(letters []deadLetter) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range letters {
+		err := enc.Encode(&letters[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write dead-letter file: %v", err)
+	}
+	return nil
+}
+
+// storeRejected hands records rejected by sink to the dead-letter
+// destination.
+func (o *output) storeRejected(ctx context.Context, sink Sink, rejected []rejectedRecord) {
+	if len(rejected) == 0 {
+		return
+	}
+	letters := make([]deadLetter, len(rejected))
+	now := time.Now()
+	for i, r := range rejected {
+		letters[i] = deadLetter{Time: now, Backend: o.storageType, Error: r.err.Error(), Record: r.data}
+	}
+
+	var err error
+	if w, ok := sink.(deadLetterWriter); ok && o.config.DeadLetter.Table != "" {
+		err = w.writeDeadLetters(ctx, letters)
+	} else if o.deadLetters != nil {
+		err = o.deadLetters.write(letters)
+	} else {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store %d rejected records of %s: %v", len(letters), o.storageType, err)
+	}
+}
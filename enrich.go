@@ -0,0 +1,156 @@
+package traefik_analytics
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// backgroundEnrichWorkers is the number of workers running enrichers that
+// may block, such as DNS lookups.
+const backgroundEnrichWorkers = 8
+
+// enricher adds derived fields, such as the location of the client, to a
+// record before it is handed to the outputs.
+type enricher interface {
+	enrich(data *RequestData)
+}
+
+// newEnrichers creates the enrichers enabled in the configuration, in the
+// order they are applied. Enrichers that perform network lookups are
+// returned separately, to be run in the background.
+func newEnrichers(ctx context.Context, config *Config) ([]enricher, []enricher, error) {
+	var enrichers, background []enricher
+	if config.Visitor.Enabled {
+		visitor, err := newVisitorEnricher(config.Visitor)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, visitor)
+	}
+	if config.UserAgent.Parse {
+		userAgent, err := newUserAgentEnricher(config.UserAgent)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, userAgent)
+	}
+	if config.Bots.Detect {
+		bots, err := newBotEnricher(config.Bots)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, bots)
+		if config.Bots.VerifyDNS {
+			background = append(background, newBotVerifier(bots))
+		}
+	}
+	if config.Referrer.Classify {
+		enrichers = append(enrichers, newReferrerEnricher(config.Referrer))
+	}
+	if config.GeoIP.DatabasePath != "" {
+		geoIP, err := newGeoIPEnricher(ctx, config.GeoIP)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, geoIP)
+	}
+	if config.ASN.DatabasePath != "" {
+		asn, err := newASNEnricher(ctx, config.ASN)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, asn)
+	}
+	if lists := config.IPLists; len(lists.Datacenter)+len(lists.VPN)+len(lists.Tor) > 0 {
+		ipLists, err := newIPListEnricher(ctx, lists)
+		if err != nil {
+			return nil, nil, err
+		}
+		enrichers = append(enrichers, ipLists)
+	}
+	if config.RDNS.Enabled {
+		rdns, err := newRDNSEnricher(config.RDNS)
+		if err != nil {
+			return nil, nil, err
+		}
+		background = append(background, rdns)
+	}
+	return enrichers, background, nil
+}
+
+// backgroundEnrichment applies enrichers that may block on a pool of
+// workers, then hands records to dispatch.
+type backgroundEnrichment struct {
+	enrichers []enricher
+	queue     chan RequestData
+	dispatch  func(RequestData)
+	// stopped is closed once the workers have handed on the records
+	// queued when ctx was done.
+	stopped chan struct{}
+}
+
+// newBackgroundEnrichment starts the workers, which run until ctx is done.
+func newBackgroundEnrichment(ctx context.Context, enrichers []enricher, dispatch func(RequestData)) *backgroundEnrichment {
+	b := &backgroundEnrichment{
+		enrichers: enrichers,
+		queue:     make(chan RequestData, 1000),
+		dispatch:  dispatch,
+		stopped:   make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	wg.Add(backgroundEnrichWorkers)
+	for i := 0; i < backgroundEnrichWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			b.worker(ctx)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(b.stopped)
+	}()
+	return b
+}
+
+// enqueue hands a record to the workers without blocking. If they are
+// falling behind or stopped, the record is dispatched without background
+// enrichment rather than dropped.
+func (b *backgroundEnrichment) enqueue(data RequestData) {
+	select {
+	case <-b.stopped:
+		b.dispatch(data)
+		return
+	default:
+	}
+	select {
+	case b.queue <- data:
+	default:
+		log.Printf("Enrichment queue full, skipping background enrichment")
+		b.dispatch(data)
+	}
+}
+
+// worker enriches queued records until ctx is done, then dispatches the
+// remaining ones without enrichment, so that they reach the outputs before
+// these are closed.
+func (b *backgroundEnrichment) worker(ctx context.Context) {
+	for {
+		select {
+		case data := <-b.queue:
+			for _, e := range b.enrichers {
+				e.enrich(&data)
+			}
+			b.dispatch(data)
+		case <-ctx.Done():
+			for {
+				select {
+				case data := <-b.queue:
+					b.dispatch(data)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package traefik_analytics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingEnricher holds records until release is closed.
+type blockingEnricher struct {
+	release chan struct{}
+}
+
+func (e blockingEnricher) enrich(data *RequestData) {
+	<-e.release
+}
+
+func TestBackgroundEnrichmentDrainsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	var mu sync.Mutex
+	dispatched := 0
+	b := newBackgroundEnrichment(ctx, []enricher{blockingEnricher{release}}, func(RequestData) {
+		mu.Lock()
+		dispatched++
+		mu.Unlock()
+	})
+
+	const records = 100
+	for i := 0; i < records; i++ {
+		b.enqueue(RequestData{})
+	}
+	cancel()
+	close(release)
+	select {
+	case <-b.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop")
+	}
+	// Records enqueued after the shutdown are dispatched directly.
+	b.enqueue(RequestData{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatched != records+1 {
+		t.Errorf("dispatched %d records, want %d", dispatched, records+1)
+	}
+}
+
+func TestEnrichmentHookDrainsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	dispatched := 0
+	h, err := newEnrichmentHook(ctx, EnrichmentHookConfig{URL: "http://127.0.0.1:1", Timeout: "1s"}, func(RequestData) {
+		mu.Lock()
+		dispatched++
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for i := 0; i < 10; i++ {
+		h.enqueue(RequestData{})
+	}
+	select {
+	case <-h.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not stop")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatched != 10 {
+		t.Errorf("dispatched %d records, want 10", dispatched)
+	}
+}
+
+func TestBotsDetectedBeforeStripping(t *testing.T) {
+	config := CreateConfig()
+	config.Bots.Detect = true
+	config.Bots.VerifyDNS = true
+	config.Bots.DNSTimeout = "100ms"
+	enrichers, background, err := newEnrichers(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of Analytics.enqueue and the background stage for a
+	// request sending Sec-GPC.
+	data := RequestData{
+		IP:               "192.0.2.1",
+		UserAgent:        "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		stripIdentifying: true,
+	}
+	for _, e := range enrichers {
+		e.enrich(&data)
+	}
+	stripIdentifying(&data)
+	for _, e := range background {
+		e.enrich(&data)
+	}
+
+	if !data.IsBot || data.BotName != "Googlebot" {
+		t.Errorf("got IsBot %v, BotName %q, want Googlebot", data.IsBot, data.BotName)
+	}
+}
@@ -0,0 +1,95 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ExtraConfig records request headers and query parameters in the extra
+// column, keyed by the given names, so that new data points need no schema
+// change. Results of the enrichment hook are added to the same column.
+type ExtraConfig struct {
+	// Headers maps request header names to keys, e.g. {"X-Tenant-ID":
+	// "tenant"}. An empty key uses the lowercased header name.
+	Headers map[string]string `json:"headers,omitempty"`
+	// QueryParams maps query parameter names to keys, e.g. {"ref":
+	// "referral"}. An empty key uses the parameter name.
+	QueryParams map[string]string `json:"queryParams,omitempty"`
+}
+
+// extraField is a value copied to the extra column.
+type extraField struct {
+	name, key string
+}
+
+// extraCapture extracts the configured headers and query parameters of a
+// request.
+type extraCapture struct {
+	headers []extraField
+	params  []extraField
+}
+
+// newExtraCapture validates the settings and creates the capture.
+func newExtraCapture(c ExtraConfig) (*extraCapture, error) {
+	e := &extraCapture{}
+	keys := map[string]string{}
+	add := func(kind, name, key string) (extraField, error) {
+		if name == "" {
+			return extraField{}, fmt.Errorf("invalid extra.%s: name is empty", kind)
+		}
+		if other, ok := keys[key]; ok {
+			return extraField{}, fmt.Errorf("invalid extra.%s[%q]: key %q is also used by %s", kind, name, key, other)
+		}
+		keys[key] = fmt.Sprintf("extra.%s[%q]", kind, name)
+		return extraField{name: name, key: key}, nil
+	}
+	for name, key := range c.Headers {
+		if key == "" {
+			key = strings.ToLower(name)
+		}
+		field, err := add("headers", http.CanonicalHeaderKey(name), key)
+		if err != nil {
+			return nil, err
+		}
+		e.headers = append(e.headers, field)
+	}
+	for name, key := range c.QueryParams {
+		if key == "" {
+			key = name
+		}
+		field, err := add("queryParams", name, key)
+		if err != nil {
+			return nil, err
+		}
+		e.params = append(e.params, field)
+	}
+	return e, nil
+}
+
+// capture returns the configured values present in req keyed by their
+// keys, or nil if there are none.
+func (e *extraCapture) capture(req *http.Request) map[string]string {
+	var captured map[string]string
+	set := func(key, value string) {
+		if captured == nil {
+			captured = make(map[string]string, len(e.headers)+len(e.params))
+		}
+		captured[key] = value
+	}
+	for _, field := range e.headers {
+		if values := req.Header[field.name]; len(values) > 0 {
+			set(field.key, values[0])
+		}
+	}
+	if len(e.params) > 0 && req.URL.RawQuery != "" {
+		query, _ := url.ParseQuery(req.URL.RawQuery)
+		for _, field := range e.params {
+			if values := query[field.name]; len(values) > 0 {
+				set(field.key, values[0])
+			}
+		}
+	}
+	return captured
+}
@@ -0,0 +1,68 @@
+package traefik_analytics
+
+import "fmt"
+
+// optionalFields maps the record fields that can be disabled to a function
+// clearing them. Fields needed to make sense of a record, such as the time,
+// host, path, method and status, cannot be disabled.
+var optionalFields = map[string]func(d *RequestData){
+	"ip":                    func(d *RequestData) { d.IP = "" },
+	"client_port":           func(d *RequestData) { d.ClientPort = 0 },
+	"user_agent":            func(d *RequestData) { d.UserAgent = "" },
+	"referer":               func(d *RequestData) { d.Referer = "" },
+	"accept_language":       func(d *RequestData) { d.AcceptLanguage = "" },
+	"language":              func(d *RequestData) { d.Language = "" },
+	"content_type":          func(d *RequestData) { d.ContentType = "" },
+	"response_content_type": func(d *RequestData) { d.ResponseContentType = "" },
+	"query_string":          func(d *RequestData) { d.QueryString = "" },
+	"raw_path":              func(d *RequestData) { d.RawPath = "" },
+	"headers":               func(d *RequestData) { d.Headers = nil },
+	"cookies":               func(d *RequestData) { d.Cookies = nil },
+	"response_headers":      func(d *RequestData) { d.ResponseHeaders = nil },
+	"trace_id":              func(d *RequestData) { d.TraceID = "" },
+	"request_id":            func(d *RequestData) { d.RequestID = "" },
+	"upstream":              func(d *RequestData) { d.Upstream = "" },
+	"country":               func(d *RequestData) { d.Country = "" },
+	"region":                func(d *RequestData) { d.Region = "" },
+	"city":                  func(d *RequestData) { d.City = "" },
+	"asn":                   func(d *RequestData) { d.ASN = 0 },
+	"as_org":                func(d *RequestData) { d.ASOrg = "" },
+	"browser":               func(d *RequestData) { d.Browser = "" },
+	"browser_version":       func(d *RequestData) { d.BrowserVersion = "" },
+	"os":                    func(d *RequestData) { d.OS = "" },
+	"os_version":            func(d *RequestData) { d.OSVersion = "" },
+	"device_type":           func(d *RequestData) { d.DeviceType = "" },
+	"rdns":                  func(d *RequestData) { d.RDNS = "" },
+	"referrer_domain":       func(d *RequestData) { d.ReferrerDomain = "" },
+	"visitor_id":            func(d *RequestData) { d.VisitorID = "" },
+	"session_id":            func(d *RequestData) { d.SessionID = "" },
+	"tls_version":           func(d *RequestData) { d.TLSVersion = "" },
+	"tls_cipher":            func(d *RequestData) { d.TLSCipher = "" },
+	"tls_fingerprint":       func(d *RequestData) { d.TLSFingerprint = "" },
+	"extra":                 func(d *RequestData) { d.Extra = nil },
+}
+
+// fieldFilter clears the disabled fields of records.
+type fieldFilter struct {
+	clear []func(d *RequestData)
+}
+
+// newFieldFilter validates the disabled field names.
+func newFieldFilter(disabled []string) (*fieldFilter, error) {
+	f := &fieldFilter{}
+	for _, name := range disabled {
+		clear, ok := optionalFields[name]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be disabled", name)
+		}
+		f.clear = append(f.clear, clear)
+	}
+	return f, nil
+}
+
+// apply clears the disabled fields of data.
+func (f *fieldFilter) apply(data *RequestData) {
+	for _, clear := range f.clear {
+		clear(data)
+	}
+}
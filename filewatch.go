@@ -0,0 +1,37 @@
+package traefik_analytics
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// watchFile polls path every interval and calls reload whenever its
+// modification time differs from the last one seen, starting at modTime.
+// Polling works the same for local files and volumes where change
+// notifications are unreliable, such as Kubernetes ConfigMaps.
+func watchFile(ctx context.Context, path string, modTime time.Time, interval time.Duration, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Failed to check %s: %v", path, err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		err = reload()
+		if err != nil {
+			log.Printf("Failed to reload %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OptOutConfig excludes traffic that must never be recorded, such as
+// internal staff or synthetic monitors.
+type OptOutConfig struct {
+	// Header names a request header; requests carrying it with a non-empty
+	// value are not recorded.
+	Header string `json:"header,omitempty"`
+	// IPs lists client addresses or CIDRs whose requests are not recorded.
+	IPs []string `json:"ips,omitempty"`
+}
+
+// HealthCheckConfig excludes health-check traffic. Requests are health
+// checks if their User-Agent contains one of knownHealthCheckers or
+// UserAgents, or their path is one of Paths.
+type HealthCheckConfig struct {
+	Exclude    bool     `json:"exclude,omitempty"`
+	UserAgents []string `json:"userAgents,omitempty"`
+	Paths      []string `json:"paths,omitempty"`
+}
+
+// knownHealthCheckers are matched against the lowercased User-Agent.
+var knownHealthCheckers = []string{
+	"kube-probe/",
+	"elb-healthchecker/",
+	"googlehc/",
+	"amazon-route53-health-check-service",
+	"consul health check",
+	"nomad health check",
+	"docker-healthcheck",
+}
+
+// HostRule replaces the filter and sampling options for some hosts. Options
+// that are not set in the rule keep their global value; an empty list
+// clears it.
+type HostRule struct {
+	// Hosts lists host names, or "*.example.com" for all its subdomains.
+	Hosts               []string `json:"hosts,omitempty"`
+	IncludePaths        []string `json:"includePaths,omitempty"`
+	ExcludePaths        []string `json:"excludePaths,omitempty"`
+	IncludeStatus       []string `json:"includeStatus,omitempty"`
+	ExcludeStatus       []string `json:"excludeStatus,omitempty"`
+	ExcludeExtensions   []string `json:"excludeExtensions,omitempty"`
+	ExcludeContentTypes []string `json:"excludeContentTypes,omitempty"`
+	SamplingRate        *float64 `json:"samplingRate,omitempty"`
+}
+
+// hostRules holds the filter and sampler of every host rule, and the
+// global ones used for other hosts.
+type hostRules struct {
+	rules   []hostRuleSet
+	filter  *requestFilter
+	sampler *sampler
+}
+
+type hostRuleSet struct {
+	hosts   []string
+	filter  *requestFilter
+	sampler *sampler
+}
+
+// newHostRules creates the global filter and sampler and those of the host
+// rules.
+func newHostRules(config *Config) (*hostRules, error) {
+	filter, err := newRequestFilter(config)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := newSampler(config)
+	if err != nil {
+		return nil, err
+	}
+	h := &hostRules{filter: filter, sampler: sampler}
+
+	for i, rule := range config.HostRules {
+		if len(rule.Hosts) == 0 {
+			return nil, fmt.Errorf("hostRules[%d].hosts must not be empty", i)
+		}
+		merged := *config
+		if rule.IncludePaths != nil {
+			merged.IncludePaths = rule.IncludePaths
+		}
+		if rule.ExcludePaths != nil {
+			merged.ExcludePaths = rule.ExcludePaths
+		}
+		if rule.IncludeStatus != nil {
+			merged.IncludeStatus = rule.IncludeStatus
+		}
+		if rule.ExcludeStatus != nil {
+			merged.ExcludeStatus = rule.ExcludeStatus
+		}
+		if rule.ExcludeExtensions != nil {
+			merged.ExcludeExtensions = rule.ExcludeExtensions
+		}
+		if rule.ExcludeContentTypes != nil {
+			merged.ExcludeContentTypes = rule.ExcludeContentTypes
+		}
+		if rule.SamplingRate != nil {
+			merged.SamplingRate = *rule.SamplingRate
+		}
+
+		set := hostRuleSet{}
+		if set.filter, err = newRequestFilter(&merged); err != nil {
+			return nil, fmt.Errorf("invalid hostRules[%d]: %v", i, err)
+		}
+		if set.sampler, err = newSampler(&merged); err != nil {
+			return nil, fmt.Errorf("invalid hostRules[%d]: %v", i, err)
+		}
+		for _, host := range rule.Hosts {
+			set.hosts = append(set.hosts, strings.ToLower(host))
+		}
+		h.rules = append(h.rules, set)
+	}
+	return h, nil
+}
+
+// lookup returns the filter and sampler of the first rule matching host.
+func (h *hostRules) lookup(host string) (*requestFilter, *sampler) {
+	if len(h.rules) == 0 {
+		return h.filter, h.sampler
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(host)
+	for _, rule := range h.rules {
+		for _, pattern := range rule.hosts {
+			if host == pattern || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+				return rule.filter, rule.sampler
+			}
+		}
+	}
+	return h.filter, h.sampler
+}
+
+// requestFilter decides which requests are recorded.
+type requestFilter struct {
+	optOutHeader string
+	optOutIPs    []netip.Prefix
+	// excludePrivate skips private, loopback and link-local clients and
+	// those in internalIPs.
+	excludePrivate bool
+	internalIPs    []netip.Prefix
+	includePaths   []*regexp.Regexp
+	excludePaths   []*regexp.Regexp
+	// healthCheckAgents and healthCheckPaths are only set when health
+	// checks are excluded.
+	healthCheckAgents   []string
+	healthCheckPaths    map[string]bool
+	excludeExtensions   map[string]bool
+	excludeContentTypes []string
+	// includeStatus and excludeStatus are inclusive ranges of status codes.
+	includeStatus [][2]int
+	excludeStatus [][2]int
+}
+
+// newRequestFilter validates the filter settings.
+func newRequestFilter(config *Config) (*requestFilter, error) {
+	optOutIPs, err := parsePrefixes("optOut.ips", config.OptOut.IPs)
+	if err != nil {
+		return nil, err
+	}
+	internalIPs, err := parsePrefixes("internalCIDRs", config.InternalCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	includePaths, err := compilePatterns("includePaths", config.IncludePaths)
+	if err != nil {
+		return nil, err
+	}
+	excludePaths, err := compilePatterns("excludePaths", config.ExcludePaths)
+	if err != nil {
+		return nil, err
+	}
+	includeStatus, err := parseStatusRanges("includeStatus", config.IncludeStatus)
+	if err != nil {
+		return nil, err
+	}
+	excludeStatus, err := parseStatusRanges("excludeStatus", config.ExcludeStatus)
+	if err != nil {
+		return nil, err
+	}
+	f := &requestFilter{
+		optOutHeader:   http.CanonicalHeaderKey(config.OptOut.Header),
+		optOutIPs:      optOutIPs,
+		excludePrivate: config.ExcludePrivateIPs,
+		internalIPs:    internalIPs,
+		includePaths:   includePaths,
+		excludePaths:   excludePaths,
+		includeStatus:  includeStatus,
+		excludeStatus:  excludeStatus,
+	}
+	if len(config.ExcludeExtensions) > 0 {
+		f.excludeExtensions = map[string]bool{}
+		for _, ext := range config.ExcludeExtensions {
+			f.excludeExtensions["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+		}
+	}
+	for _, contentType := range config.ExcludeContentTypes {
+		f.excludeContentTypes = append(f.excludeContentTypes, strings.ToLower(contentType))
+	}
+	if config.HealthChecks.Exclude {
+		f.healthCheckAgents = append(f.healthCheckAgents, knownHealthCheckers...)
+		for _, agent := range config.HealthChecks.UserAgents {
+			f.healthCheckAgents = append(f.healthCheckAgents, strings.ToLower(agent))
+		}
+		f.healthCheckPaths = map[string]bool{}
+		for _, path := range config.HealthChecks.Paths {
+			f.healthCheckPaths[path] = true
+		}
+	}
+	return f, nil
+}
+
+// skip reports whether req, from the client address ip, is not recorded.
+func (f *requestFilter) skip(req *http.Request, ip string) bool {
+	if f.optOutHeader != "" && req.Header.Get(f.optOutHeader) != "" {
+		return true
+	}
+	if len(f.optOutIPs) > 0 || f.excludePrivate {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			if prefixesContain(f.optOutIPs, addr) {
+				return true
+			}
+			if f.excludePrivate && (addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || prefixesContain(f.internalIPs, addr)) {
+				return true
+			}
+		}
+	}
+	if f.isHealthCheck(req) {
+		return true
+	}
+	if f.excludeExtensions != nil && f.excludeExtensions[strings.ToLower(path.Ext(req.URL.Path))] {
+		return true
+	}
+	if len(f.includePaths) > 0 && !matchesAny(f.includePaths, req.URL.Path) {
+		return true
+	}
+	return matchesAny(f.excludePaths, req.URL.Path)
+}
+
+func (f *requestFilter) isHealthCheck(req *http.Request) bool {
+	if f.healthCheckPaths[req.URL.Path] {
+		return true
+	}
+	if len(f.healthCheckAgents) == 0 {
+		return false
+	}
+	userAgent := strings.ToLower(req.UserAgent())
+	for _, agent := range f.healthCheckAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipResponse reports whether a response with the given status code and
+// Content-Type is not recorded.
+func (f *requestFilter) skipResponse(status int, contentType string) bool {
+	if len(f.includeStatus) > 0 && !statusInRanges(f.includeStatus, status) {
+		return true
+	}
+	if statusInRanges(f.excludeStatus, status) {
+		return true
+	}
+	if len(f.excludeContentTypes) > 0 {
+		contentType = strings.ToLower(contentType)
+		for _, excluded := range f.excludeContentTypes {
+			if strings.HasPrefix(contentType, excluded) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseStatusRanges parses status codes ("404"), classes ("5xx") and
+// ranges ("400-499"). option names the setting in errors.
+func parseStatusRanges(option string, entries []string) ([][2]int, error) {
+	var ranges [][2]int
+	for i, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		var low, high int
+		var err error
+		if class, ok := strings.CutSuffix(entry, "xx"); ok && len(class) == 1 {
+			low, err = strconv.Atoi(class)
+			low, high = low*100, low*100+99
+		} else if from, to, ok := strings.Cut(entry, "-"); ok {
+			low, err = strconv.Atoi(from)
+			if err == nil {
+				high, err = strconv.Atoi(to)
+			}
+		} else {
+			low, err = strconv.Atoi(entry)
+			high = low
+		}
+		if err != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("invalid %s[%d] %q", option, i, entries[i])
+		}
+		ranges = append(ranges, [2]int{low, high})
+	}
+	return ranges, nil
+}
+
+func statusInRanges(ranges [][2]int, status int) bool {
+	for _, r := range ranges {
+		if status >= r[0] && status <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles a list of regular expressions. option names the
+// setting in errors.
+func compilePatterns(option string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %v", option, i, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses a list of addresses and CIDRs; a plain address is
+// taken as a single-address prefix. option names the setting in errors.
+func parsePrefixes(option string, entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, raw := range entries {
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(raw)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid %s entry %q", option, raw)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain reports whether addr belongs to one of prefixes.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
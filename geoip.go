@@ -0,0 +1,140 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPConfig enables enrichment from a MaxMind GeoIP2 or GeoLite2 City (or
+// Country) database.
+type GeoIPConfig struct {
+	// DatabasePath is the .mmdb file. Enrichment is disabled when empty.
+	DatabasePath string `json:"databasePath,omitempty"`
+	// ReloadInterval is how often the file is checked for changes, e.g.
+	// after an update by geoipupdate.
+	ReloadInterval string `json:"reloadInterval,omitempty"`
+}
+
+// mmdbFile is a MaxMind database file that is reopened when it changes on
+// disk. Lookups and reloads are serialized with a read-write lock, as
+// closing a reader unmaps the file.
+type mmdbFile struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+}
+
+// openMMDB opens the database and watches it for changes until ctx is
+// done, when it is closed.
+func openMMDB(ctx context.Context, path string, reloadInterval time.Duration) (*mmdbFile, error) {
+	f := &mmdbFile{path: path}
+	err := f.reload()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		watchFile(ctx, path, f.modTime, reloadInterval, f.reload)
+		f.close()
+	}()
+	return f, nil
+}
+
+// close closes the reader; later lookups find nothing.
+func (f *mmdbFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reader.Close()
+	f.reader = nil
+}
+
+// reload opens the current file and replaces the previous reader.
+func (f *mmdbFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to open MaxMind database: %v", err)
+	}
+	reader, err := maxminddb.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to open MaxMind database: %v", err)
+	}
+
+	f.mu.Lock()
+	old := f.reader
+	f.reader = reader
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// lookup decodes the record of ip into result. It reports false if the
+// address is invalid or not in the database.
+func (f *mmdbFile) lookup(ip string, result interface{}) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.reader == nil {
+		return false
+	}
+	_, ok, err := f.reader.LookupNetwork(addr, result)
+	return err == nil && ok
+}
+
+// geoIPRecord holds the fields read from a City or Country database.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// geoIPEnricher adds country, region and city to records.
+type geoIPEnricher struct {
+	db *mmdbFile
+}
+
+// newGeoIPEnricher opens the configured database.
+func newGeoIPEnricher(ctx context.Context, c GeoIPConfig) (*geoIPEnricher, error) {
+	interval, err := time.ParseDuration(c.ReloadInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid geoIP.reloadInterval %q", c.ReloadInterval)
+	}
+	db, err := openMMDB(ctx, c.DatabasePath, interval)
+	if err != nil {
+		return nil, err
+	}
+	return &geoIPEnricher{db: db}, nil
+}
+
+// enrich sets the location fields of data. English names are used for
+// region and city.
+func (e *geoIPEnricher) enrich(data *RequestData) {
+	var record geoIPRecord
+	if !e.db.lookup(data.IP, &record) {
+		return
+	}
+	data.Country = record.Country.ISOCode
+	if len(record.Subdivisions) > 0 {
+		data.Region = record.Subdivisions[0].Names["en"]
+	}
+	data.City = record.City.Names["en"]
+}
@@ -0,0 +1,214 @@
+package traefik_analytics
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleMetadataTokenURL is the token endpoint of the GCE/GKE metadata server.
+const googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// googleCredentialsFile is the subset of a service account or authorized
+// user JSON key file used for authentication.
+type googleCredentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleTokenSource provides OAuth2 access tokens using Application Default
+// Credentials: an explicit key file, GOOGLE_APPLICATION_CREDENTIALS, the
+// gcloud well-known file, and finally the metadata server (which also
+// covers GKE Workload Identity). Tokens are cached until shortly before
+// they expire.
+type googleTokenSource struct {
+	client *http.Client
+	scopes []string
+	creds  *googleCredentialsFile
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// newGoogleTokenSource locates credentials for the given scopes. An empty
+// credentialsFile falls back to the environment.
+func newGoogleTokenSource(credentialsFile string, scopes []string) (*googleTokenSource, error) {
+	ts := &googleTokenSource{
+		client: &http.Client{Timeout: 10 * time.Second},
+		scopes: scopes,
+	}
+
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if credentialsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			wellKnown := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				credentialsFile = wellKnown
+			}
+		}
+	}
+	if credentialsFile == "" {
+		// Use the metadata server.
+		return ts, nil
+	}
+
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Google credentials: %v", err)
+	}
+	var creds googleCredentialsFile
+	err = json.Unmarshal(raw, &creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials: %v", err)
+	}
+
+	switch creds.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(creds.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse Google credentials: invalid private key")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Google credentials: %v", err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse Google credentials: private key is not RSA")
+		}
+		ts.key = key
+		if creds.TokenURI == "" {
+			creds.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+	case "authorized_user":
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	default:
+		return nil, fmt.Errorf("unsupported Google credentials type %q", creds.Type)
+	}
+	ts.creds = &creds
+
+	return ts, nil
+}
+
+// Token returns a valid access token, fetching a new one if needed.
+func (ts *googleTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Add(time.Minute).Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	switch {
+	case ts.creds == nil:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, googleMetadataTokenURL+"?scopes="+url.QueryEscape(strings.Join(ts.scopes, ",")), nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	case ts.key != nil:
+		var assertion string
+		assertion, err = ts.signJWT()
+		if err == nil {
+			req, err = newFormRequest(ctx, ts.creds.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}
+	default:
+		req, err = newFormRequest(ctx, ts.creds.TokenURI, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {ts.creds.ClientID},
+			"client_secret": {ts.creds.ClientSecret},
+			"refresh_token": {ts.creds.RefreshToken},
+		})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+
+	body, err := sendRequest(ts.client, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch Google access token: %v", err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil || resp.AccessToken == "" {
+		return "", fmt.Errorf("failed to fetch Google access token: invalid response")
+	}
+
+	ts.token = resp.AccessToken
+	ts.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// signJWT creates the self-signed assertion of the service account flow.
+func (ts *googleTokenSource) signJWT() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.creds.ClientEmail,
+		"scope": strings.Join(ts.scopes, " "),
+		"aud":   ts.creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// authorize sets the bearer token on req.
+func (ts *googleTokenSource) authorize(req *http.Request) error {
+	token, err := ts.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// newFormRequest builds a form-encoded POST request.
+func newFormRequest(ctx context.Context, target string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,42 @@
+package traefik_analytics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// isGRPC reports whether req is a gRPC call, including gRPC-Web.
+func isGRPC(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "application/grpc")
+}
+
+// grpcMethod splits a gRPC request path of the form
+// "/package.Service/Method" into service and method.
+func grpcMethod(path string) (service, method string) {
+	service, method, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", ""
+	}
+	return service, method
+}
+
+// grpcStatus returns the grpc-status sent with the response, or nil if there
+// was none. The status normally arrives as a trailer, which handlers either
+// announce up front or set with the http.TrailerPrefix; responses without a
+// body carry it as a regular header.
+func grpcStatus(header http.Header) *int {
+	value := header.Get("Grpc-Status")
+	if value == "" {
+		value = header.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	if value == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &code
+}
@@ -0,0 +1,45 @@
+package traefik_analytics
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerCapture captures the values of configured headers. The canonical
+// and lowercased names are computed once, so capturing does not allocate
+// unless one of the headers is present.
+type headerCapture struct {
+	keys  []string
+	names []string
+}
+
+func newHeaderCapture(names []string) headerCapture {
+	c := headerCapture{keys: make([]string, len(names)), names: make([]string, len(names))}
+	for i, name := range names {
+		c.keys[i] = http.CanonicalHeaderKey(name)
+		c.names[i] = strings.ToLower(name)
+	}
+	return c
+}
+
+// capture returns the values of the headers keyed by their lowercased name.
+// Repeated headers are joined with ", " and absent ones are left out; nil is
+// returned if none of them is present.
+func (c headerCapture) capture(header http.Header) map[string]string {
+	var captured map[string]string
+	for i, key := range c.keys {
+		values := header[key]
+		if len(values) == 0 {
+			continue
+		}
+		if captured == nil {
+			captured = make(map[string]string, len(c.keys))
+		}
+		if len(values) == 1 {
+			captured[c.names[i]] = values[0]
+		} else {
+			captured[c.names[i]] = strings.Join(values, ", ")
+		}
+	}
+	return captured
+}
@@ -0,0 +1,163 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// EnrichmentHookConfig configures an external HTTP service that adds custom
+// fields to records, e.g. a customer lookup or risk score.
+//
+// Records are POSTed in batches as a JSON array. The service answers with a
+// JSON array of the same length holding an object of fields for each record
+// (or null), which are added to the extra field of the record. Values that
+// are not strings are stored as their JSON encoding. Records are stored
+// without the fields if the service fails or does not answer in time.
+type EnrichmentHookConfig struct {
+	URL string `json:"url,omitempty"`
+	// Headers are added to every request, e.g. an Authorization token.
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+}
+
+// maxHookBatchSize is the maximum number of records sent to the hook at
+// once.
+const maxHookBatchSize = 100
+
+// enrichmentHook batches records, sends them to the hook and hands them on
+// to dispatch.
+type enrichmentHook struct {
+	config   EnrichmentHookConfig
+	client   *http.Client
+	queue    chan RequestData
+	dispatch func(RequestData)
+	// stopped is closed once the worker has handed on the records queued
+	// when ctx was done.
+	stopped chan struct{}
+}
+
+// newEnrichmentHook validates the settings and starts the worker, which
+// runs until ctx is done.
+func newEnrichmentHook(ctx context.Context, c EnrichmentHookConfig, dispatch func(RequestData)) (*enrichmentHook, error) {
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid enrichmentHook.timeout %q", c.Timeout)
+	}
+	h := &enrichmentHook{
+		config:   c,
+		client:   &http.Client{Timeout: timeout},
+		queue:    make(chan RequestData, 1000),
+		dispatch: dispatch,
+		stopped:  make(chan struct{}),
+	}
+	go h.worker(ctx)
+	return h, nil
+}
+
+// enqueue hands a record to the worker without blocking. If the hook is
+// falling behind or stopped, the record is dispatched without its fields.
+func (h *enrichmentHook) enqueue(data RequestData) {
+	select {
+	case <-h.stopped:
+		h.dispatch(data)
+		return
+	default:
+	}
+	select {
+	case h.queue <- data:
+	default:
+		log.Printf("Enrichment hook queue full, skipping enrichment")
+		h.dispatch(data)
+	}
+}
+
+func (h *enrichmentHook) worker(ctx context.Context) {
+	defer close(h.stopped)
+	batch := make([]RequestData, 0, maxHookBatchSize)
+	for {
+		var data RequestData
+		select {
+		case data = <-h.queue:
+		case <-ctx.Done():
+			h.flush()
+			return
+		}
+		batch = append(batch[:0], data)
+	drain:
+		for len(batch) < cap(batch) {
+			select {
+			case data := <-h.queue:
+				batch = append(batch, data)
+			default:
+				break drain
+			}
+		}
+
+		if err := h.enrich(batch); err != nil {
+			log.Printf("Enrichment hook failed, storing records without its fields: %v", err)
+		}
+		for _, data := range batch {
+			h.dispatch(data)
+		}
+	}
+}
+
+// flush dispatches the queued records without calling the hook, so that
+// they reach the outputs before these are closed.
+func (h *enrichmentHook) flush() {
+	for {
+		select {
+		case data := <-h.queue:
+			h.dispatch(data)
+		default:
+			return
+		}
+	}
+}
+
+// enrich calls the hook for batch and merges the returned fields.
+func (h *enrichmentHook) enrich(batch []RequestData) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	respBody, err := sendRequest(h.client, req)
+	if err != nil {
+		return err
+	}
+	var results []map[string]json.RawMessage
+	if err := json.Unmarshal(respBody, &results); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	if len(results) != len(batch) {
+		return fmt.Errorf("got %d results for %d records", len(results), len(batch))
+	}
+
+	for i, fields := range results {
+		for key, raw := range fields {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				value = string(raw)
+			}
+			if batch[i].Extra == nil {
+				batch[i].Extra = map[string]string{}
+			}
+			batch[i].Extra[key] = value
+		}
+	}
+	return nil
+}
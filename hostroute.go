@@ -0,0 +1,214 @@
+package traefik_analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HostRoutingConfig stores the records of the listed hosts in a table or
+// database of their own, for tenant isolation. It applies to the postgres,
+// mysql, sqlite, timescaledb and clickhouse backends, and cannot be combined
+// with shards. Records of other hosts go to TableName and DatabaseDSN.
+type HostRoutingConfig struct {
+	// Hosts lists the routed hosts, matched without port and ignoring
+	// case.
+	Hosts []string `json:"hosts,omitempty"`
+	// TableName is the table of each routed host, in which {host} stands
+	// for the host with every character other than a letter or digit
+	// replaced by an underscore, e.g. "logs_{host}". Empty keeps the table
+	// of Config.TableName.
+	TableName string `json:"tableName,omitempty"`
+	// DSNs maps routed hosts to the DSN of their database. Hosts without
+	// one use DatabaseDSN.
+	DSNs map[string]string `json:"dsns,omitempty"`
+}
+
+func validateHostRoutingConfig(c HostRoutingConfig, shards ShardingConfig) error {
+	if len(c.Hosts) == 0 {
+		if c.TableName != "" || len(c.DSNs) > 0 {
+			return fmt.Errorf("hostRouting.hosts is required")
+		}
+		return nil
+	}
+	if len(shards.DSNs) > 0 {
+		return fmt.Errorf("hostRouting cannot be combined with shards")
+	}
+	if c.TableName != "" && !strings.Contains(c.TableName, "{host}") {
+		return fmt.Errorf("invalid hostRouting.tableName %q: {host} is missing", c.TableName)
+	}
+	listed := map[string]bool{}
+	for i, host := range c.Hosts {
+		if host == "" {
+			return fmt.Errorf("invalid hostRouting.hosts[%d]: host is empty", i)
+		}
+		listed[strings.ToLower(host)] = true
+	}
+	for host, dsn := range c.DSNs {
+		if !listed[strings.ToLower(host)] {
+			return fmt.Errorf("hostRouting.dsns entry %q is not listed in hostRouting.hosts", host)
+		}
+		if dsn == "" {
+			return fmt.Errorf("invalid hostRouting.dsns[%q]: DSN is empty", host)
+		}
+	}
+	if c.TableName == "" && len(c.DSNs) < len(listed) {
+		return fmt.Errorf("hostRouting.tableName is required for hosts without a DSN")
+	}
+	return nil
+}
+
+// hostRouted reports whether the records of the named backend are routed
+// by host.
+func hostRouted(name string, config *Config) bool {
+	return len(config.HostRouting.Hosts) > 0 && dsnSinks[name]
+}
+
+// hostTableName returns the table name of host for the given template.
+func hostTableName(template, host string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(host))
+	return strings.ReplaceAll(template, "{host}", name)
+}
+
+// hostConfigs returns a copy of config for each routed host, keyed by the
+// lowercased host.
+func hostConfigs(config *Config) map[string]*Config {
+	dsns := make(map[string]string, len(config.HostRouting.DSNs))
+	for host, dsn := range config.HostRouting.DSNs {
+		dsns[strings.ToLower(host)] = dsn
+	}
+	configs := make(map[string]*Config, len(config.HostRouting.Hosts))
+	for _, host := range config.HostRouting.Hosts {
+		host = strings.ToLower(host)
+		hostConfig := *config
+		hostConfig.HostRouting = HostRoutingConfig{}
+		if config.HostRouting.TableName != "" {
+			hostConfig.TableName = hostTableName(config.HostRouting.TableName, host)
+		}
+		if dsn, ok := dsns[host]; ok {
+			hostConfig.DatabaseDSN = dsn
+		}
+		configs[host] = &hostConfig
+	}
+	return configs
+}
+
+// hostRoutedSink writes the records of each routed host to its own sink,
+// and those of other hosts to a default sink.
+type hostRoutedSink struct {
+	hosts    map[string]Sink
+	fallback Sink
+}
+
+func newHostRoutedSink(factory SinkFactory, config *Config) (Sink, error) {
+	s := &hostRoutedSink{hosts: map[string]Sink{}}
+	fallbackConfig := *config
+	fallbackConfig.HostRouting = HostRoutingConfig{}
+	fallback, err := factory(&fallbackConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.fallback = fallback
+	for host, hostConfig := range hostConfigs(config) {
+		sink, err := factory(hostConfig)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open backend of host %s: %v", host, err)
+		}
+		s.hosts[host] = sink
+	}
+	return s, nil
+}
+
+// sink returns the sink that stores data.
+func (s *hostRoutedSink) sink(data *RequestData) Sink {
+	host := data.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if sink, ok := s.hosts[strings.ToLower(host)]; ok {
+		return sink
+	}
+	return s.fallback
+}
+
+// group splits batch by sink.
+func (s *hostRoutedSink) group(batch []RequestData) map[Sink][]RequestData {
+	grouped := map[Sink][]RequestData{}
+	for i := range batch {
+		sink := s.sink(&batch[i])
+		grouped[sink] = append(grouped[sink], batch[i])
+	}
+	return grouped
+}
+
+// Write writes the records of each host to its sink. As with shards, a
+// failing sink fails the whole batch, and records rejected by the sinks
+// that were reached are reported together.
+func (s *hostRoutedSink) Write(ctx context.Context, batch []RequestData) error {
+	var rejected []rejectedRecord
+	var partialErr error
+	for sink, records := range s.group(batch) {
+		err := sink.Write(ctx, records)
+		var partial *partialWriteError
+		if errors.As(err, &partial) {
+			rejected = append(rejected, partial.rejected...)
+			partialErr = err
+		} else if err != nil {
+			return err
+		}
+	}
+	if partialErr != nil {
+		return &partialWriteError{err: partialErr, rejected: rejected}
+	}
+	return nil
+}
+
+// writeDeadLetters stores each dead letter with the sink of its record.
+func (s *hostRoutedSink) writeDeadLetters(ctx context.Context, letters []deadLetter) error {
+	grouped := map[Sink][]deadLetter{}
+	for _, letter := range letters {
+		sink := s.sink(&letter.Record)
+		grouped[sink] = append(grouped[sink], letter)
+	}
+	for sink, sinkLetters := range grouped {
+		w, ok := sink.(deadLetterWriter)
+		if !ok {
+			continue
+		}
+		if err := w.writeDeadLetters(ctx, sinkLetters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maintain runs the upkeep of the sinks of all hosts.
+func (s *hostRoutedSink) maintain(ctx context.Context) {
+	if m, ok := s.fallback.(maintainer); ok {
+		m.maintain(ctx)
+	}
+	for _, sink := range s.hosts {
+		if m, ok := sink.(maintainer); ok {
+			m.maintain(ctx)
+		}
+	}
+}
+
+// Close closes the sinks of all hosts.
+func (s *hostRoutedSink) Close() error {
+	firstErr := s.fallback.Close()
+	for _, sink := range s.hosts {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
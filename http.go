@@ -0,0 +1,84 @@
+package traefik_analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseBody bounds how much of a backend response is read into memory.
+const maxResponseBody = 1 << 20
+
+// httpStatusError is returned by sendRequest for responses outside the
+// 2xx range.
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Body)
+}
+
+// sendRequest performs req and returns the response body. Responses outside
+// the 2xx range are turned into an *httpStatusError that quotes the start
+// of the body.
+func sendRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 512 {
+			msg = msg[:512] + "..."
+		}
+		return body, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: msg}
+	}
+	return body, nil
+}
+
+// isRetryable reports whether a request that failed with err may succeed
+// when sent again: transport errors, throttling and server errors.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.StatusCode == http.StatusTooManyRequests ||
+		statusErr.StatusCode == http.StatusRequestTimeout ||
+		statusErr.StatusCode >= 500
+}
+
+// withRetries calls fn until it succeeds, fails with an error that is not
+// retryable, or maxRetries additional attempts have been made. The delay
+// between attempts starts at backoff and doubles each time.
+func withRetries(ctx context.Context, maxRetries int, backoff time.Duration, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxRetries || !isRetryable(err) {
+			return fmt.Errorf("giving up after %d attempt(s): %v", attempt+1, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,229 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPListsConfig flags clients found in lists of datacenter, VPN or Tor exit
+// addresses. Each source is a file path or an http(s) URL with one address
+// or CIDR per line; anything after the first space or comma on a line, and
+// lines starting with #, are ignored. For example, the Tor Project publishes
+// the current exit nodes at https://check.torproject.org/torbulkexitlist.
+type IPListsConfig struct {
+	Datacenter []string `json:"datacenter,omitempty"`
+	VPN        []string `json:"vpn,omitempty"`
+	Tor        []string `json:"tor,omitempty"`
+	// RefreshInterval is how often the sources are reloaded.
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+}
+
+// maxIPListSize bounds the size of a downloaded list.
+const maxIPListSize = 64 << 20
+
+// ipRange is an inclusive range of addresses of the same family.
+type ipRange struct {
+	start, end netip.Addr
+}
+
+// ipRangeSet is a set of addresses stored as sorted, non-overlapping ranges.
+type ipRangeSet []ipRange
+
+// newIPRangeSet sorts and merges ranges.
+func newIPRangeSet(ranges []ipRange) ipRangeSet {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	var set ipRangeSet
+	for _, r := range ranges {
+		if n := len(set); n > 0 && set[n-1].end.BitLen() == r.start.BitLen() && !set[n-1].end.Next().Less(r.start) {
+			if set[n-1].end.Less(r.end) {
+				set[n-1].end = r.end
+			}
+			continue
+		}
+		set = append(set, r)
+	}
+	return set
+}
+
+func (s ipRangeSet) contains(addr netip.Addr) bool {
+	i := sort.Search(len(s), func(i int) bool { return addr.Less(s[i].start) }) - 1
+	return i >= 0 && !s[i].end.Less(addr)
+}
+
+// prefixRange returns the first and last address of prefix.
+func prefixRange(prefix netip.Prefix) ipRange {
+	prefix = prefix.Masked()
+	last := prefix.Addr().As16()
+	offset := 128 - prefix.Addr().BitLen()
+	for bit := offset + prefix.Bits(); bit < 128; bit++ {
+		last[bit/8] |= 0x80 >> (bit % 8)
+	}
+	end := netip.AddrFrom16(last)
+	if prefix.Addr().Is4() {
+		end = end.Unmap()
+	}
+	return ipRange{start: prefix.Addr(), end: end}
+}
+
+// parseIPList reads a list of addresses and CIDRs.
+func parseIPList(r io.Reader) (ipRangeSet, error) {
+	var ranges []ipRange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if end := strings.IndexAny(line, " \t,"); end >= 0 {
+			line = line[:end]
+		}
+		if prefix, err := netip.ParsePrefix(line); err == nil {
+			ranges = append(ranges, prefixRange(prefix))
+		} else if addr, err := netip.ParseAddr(line); err == nil {
+			addr = addr.Unmap().WithZone("")
+			ranges = append(ranges, ipRange{start: addr, end: addr})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newIPRangeSet(ranges), nil
+}
+
+// ipListSource is a list of addresses of one category.
+type ipListSource struct {
+	category string
+	location string
+
+	mu  sync.RWMutex
+	set ipRangeSet
+}
+
+// load reads the source and replaces the addresses in use.
+func (s *ipListSource) load(client *http.Client) error {
+	var r io.ReadCloser
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, s.location, nil)
+		if err != nil {
+			return fmt.Errorf("failed to fetch IP list %s: %v", s.location, err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to fetch IP list %s: %v", s.location, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("failed to fetch IP list %s: status %d", s.location, resp.StatusCode)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(s.location)
+		if err != nil {
+			return fmt.Errorf("failed to open IP list: %v", err)
+		}
+		r = file
+	}
+	defer r.Close()
+
+	set, err := parseIPList(io.LimitReader(r, maxIPListSize))
+	if err != nil {
+		return fmt.Errorf("failed to read IP list %s: %v", s.location, err)
+	}
+	s.mu.Lock()
+	s.set = set
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ipListSource) contains(addr netip.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set.contains(addr)
+}
+
+// ipListEnricher flags datacenter, VPN and Tor clients.
+type ipListEnricher struct {
+	sources []*ipListSource
+	client  *http.Client
+}
+
+// newIPListEnricher loads all sources and refreshes them until ctx is
+// done. Files
+// that cannot be read are a configuration error; URLs that cannot be
+// fetched are logged and retried at the next refresh, so that a network
+// outage does not prevent startup.
+func newIPListEnricher(ctx context.Context, c IPListsConfig) (*ipListEnricher, error) {
+	interval, err := time.ParseDuration(c.RefreshInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid ipLists.refreshInterval %q", c.RefreshInterval)
+	}
+
+	e := &ipListEnricher{client: &http.Client{Timeout: 30 * time.Second}}
+	for category, locations := range map[string][]string{
+		"datacenter": c.Datacenter,
+		"vpn":        c.VPN,
+		"tor":        c.Tor,
+	} {
+		for _, location := range locations {
+			source := &ipListSource{category: category, location: location}
+			if err := source.load(e.client); err != nil {
+				if !strings.Contains(location, "://") {
+					return nil, err
+				}
+				log.Printf("Skipping IP list until the next refresh: %v", err)
+			}
+			e.sources = append(e.sources, source)
+		}
+	}
+	go e.refresh(ctx, interval)
+	return e, nil
+}
+
+// refresh reloads the sources periodically, keeping the previous addresses
+// of a source that fails to load.
+func (e *ipListEnricher) refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, source := range e.sources {
+			if err := source.load(e.client); err != nil {
+				log.Printf("Keeping the previous IP list: %v", err)
+			}
+		}
+	}
+}
+
+func (e *ipListEnricher) enrich(data *RequestData) {
+	addr, err := netip.ParseAddr(data.IP)
+	if err != nil {
+		return
+	}
+	for _, source := range e.sources {
+		if !source.contains(addr) {
+			continue
+		}
+		switch source.category {
+		case "datacenter":
+			data.IsDatacenter = true
+		case "vpn":
+			data.IsVPN = true
+		case "tor":
+			data.IsTor = true
+		}
+	}
+}
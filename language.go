@@ -0,0 +1,59 @@
+package traefik_analytics
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxLanguageTagLength bounds the language tags that are recorded; longer
+// values are not valid BCP 47 tags in practice.
+const maxLanguageTagLength = 35
+
+// primaryLanguage returns the preferred language of an Accept-Language
+// header, normalized to BCP 47 casing such as "en-US" or "zh-Hant-TW". The
+// entry with the highest q-value wins, the first one on ties. The wildcard
+// and entries with q=0 are ignored.
+func primaryLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, entry := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" || len(tag) > maxLanguageTagLength {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return normalizeLanguageTag(best)
+}
+
+// normalizeLanguageTag applies the conventional casing to the subtags of a
+// language tag: lowercase language, title-case script, uppercase region.
+func normalizeLanguageTag(tag string) string {
+	subtags := strings.Split(strings.ReplaceAll(tag, "_", "-"), "-")
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		case len(subtag) == 2 || (len(subtag) == 3 && subtag[0] >= '0' && subtag[0] <= '9'):
+			subtags[i] = strings.ToUpper(subtag)
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-")
+}
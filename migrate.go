@@ -0,0 +1,410 @@
+package traefik_analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// sqlMigration is a versioned change of the schema of a SQL backend. In its
+// statements, {table} stands for the table and {idx_<name>} for the index
+// named idx_<table>_<name>.
+type sqlMigration struct {
+	version    int
+	statements []string
+}
+
+// postgresCreateTable matches schema.sql.
+const postgresCreateTable = `CREATE TABLE IF NOT EXISTS {table} (
+  id SERIAL PRIMARY KEY,
+  ip INET,
+  user_agent TEXT,
+  path TEXT NOT NULL,
+  request_time TIMESTAMP WITH TIME ZONE NOT NULL,
+  method VARCHAR(10) NOT NULL,
+  protocol VARCHAR(10) NOT NULL,
+  host TEXT NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length BIGINT,
+  response_time INTERVAL NOT NULL,
+  response_content_type TEXT,
+  status SMALLINT NOT NULL,
+  response_size BIGINT NOT NULL,
+  router TEXT,
+  service TEXT,
+  entrypoint TEXT,
+  query_string TEXT,
+  headers JSONB,
+  cookies JSONB,
+  trace_id TEXT,
+  request_id TEXT,
+  upgrade TEXT,
+  tunnel_bytes_received BIGINT NOT NULL,
+  tunnel_bytes_sent BIGINT NOT NULL,
+  grpc_service TEXT,
+  grpc_method TEXT,
+  grpc_status SMALLINT,
+  client_port INTEGER,
+  connection_reused BOOLEAN NOT NULL,
+  response_headers JSONB,
+  ttfb BIGINT NOT NULL,
+  upstream TEXT,
+  content_encoding TEXT,
+  uncompressed_size BIGINT,
+  country CHAR(2),
+  region TEXT,
+  city TEXT,
+  asn BIGINT,
+  as_org TEXT,
+  browser TEXT,
+  browser_version TEXT,
+  os TEXT,
+  os_version TEXT,
+  device_type TEXT,
+  is_bot BOOLEAN NOT NULL,
+  bot_name TEXT,
+  rdns TEXT,
+  utm_source TEXT,
+  utm_medium TEXT,
+  utm_campaign TEXT,
+  utm_term TEXT,
+  utm_content TEXT,
+  referrer_domain TEXT,
+  referrer_type TEXT,
+  language TEXT,
+  is_datacenter BOOLEAN NOT NULL,
+  is_vpn BOOLEAN NOT NULL,
+  is_tor BOOLEAN NOT NULL,
+  visitor_id TEXT,
+  session_id TEXT,
+  tls_version TEXT,
+  tls_cipher TEXT,
+  tls_fingerprint TEXT,
+  raw_path TEXT,
+  extra JSONB,
+  record_id UUID
+)`
+
+// mysqlCreateTable matches schema_mysql.sql.
+const mysqlCreateTable = `CREATE TABLE IF NOT EXISTS {table} (
+  id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  ip VARCHAR(64),
+  user_agent TEXT,
+  path VARCHAR(2048) NOT NULL,
+  request_time DATETIME(6) NOT NULL,
+  method VARCHAR(10) NOT NULL,
+  protocol VARCHAR(10) NOT NULL,
+  host VARCHAR(255) NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length BIGINT,
+  response_time BIGINT NOT NULL,
+  response_content_type TEXT,
+  status SMALLINT NOT NULL,
+  response_size BIGINT NOT NULL,
+  router VARCHAR(255),
+  service VARCHAR(255),
+  entrypoint VARCHAR(255),
+  query_string TEXT,
+  headers JSON,
+  cookies JSON,
+  trace_id VARCHAR(128),
+  request_id VARCHAR(128),
+  upgrade VARCHAR(32),
+  tunnel_bytes_received BIGINT NOT NULL,
+  tunnel_bytes_sent BIGINT NOT NULL,
+  grpc_service VARCHAR(255),
+  grpc_method VARCHAR(255),
+  grpc_status SMALLINT,
+  client_port INT,
+  connection_reused BOOLEAN NOT NULL,
+  response_headers JSON,
+  ttfb BIGINT NOT NULL,
+  upstream VARCHAR(255),
+  content_encoding VARCHAR(32),
+  uncompressed_size BIGINT,
+  country CHAR(2),
+  region VARCHAR(255),
+  city VARCHAR(255),
+  asn INT UNSIGNED,
+  as_org VARCHAR(255),
+  browser VARCHAR(64),
+  browser_version VARCHAR(64),
+  os VARCHAR(64),
+  os_version VARCHAR(64),
+  device_type VARCHAR(16),
+  is_bot BOOLEAN NOT NULL,
+  bot_name VARCHAR(64),
+  rdns VARCHAR(255),
+  utm_source VARCHAR(255),
+  utm_medium VARCHAR(255),
+  utm_campaign VARCHAR(255),
+  utm_term VARCHAR(255),
+  utm_content VARCHAR(255),
+  referrer_domain VARCHAR(255),
+  referrer_type VARCHAR(16),
+  language VARCHAR(35),
+  is_datacenter BOOLEAN NOT NULL,
+  is_vpn BOOLEAN NOT NULL,
+  is_tor BOOLEAN NOT NULL,
+  visitor_id CHAR(32),
+  session_id CHAR(36),
+  tls_version VARCHAR(16),
+  tls_cipher VARCHAR(64),
+  tls_fingerprint VARCHAR(256),
+  raw_path TEXT,
+  extra JSON,
+  record_id CHAR(36),
+  INDEX {idx_request_time} (request_time),
+  INDEX {idx_path} (path(255)),
+  INDEX {idx_ip} (ip)
+)`
+
+// sqliteCreateTable uses the storage classes of SQLite.
+const sqliteCreateTable = `CREATE TABLE IF NOT EXISTS {table} (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ip TEXT,
+  user_agent TEXT,
+  path TEXT NOT NULL,
+  request_time TIMESTAMP NOT NULL,
+  method TEXT NOT NULL,
+  protocol TEXT NOT NULL,
+  host TEXT NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length INTEGER,
+  response_time INTEGER NOT NULL,
+  response_content_type TEXT,
+  status INTEGER NOT NULL,
+  response_size INTEGER NOT NULL,
+  router TEXT,
+  service TEXT,
+  entrypoint TEXT,
+  query_string TEXT,
+  headers TEXT,
+  cookies TEXT,
+  trace_id TEXT,
+  request_id TEXT,
+  upgrade TEXT,
+  tunnel_bytes_received INTEGER NOT NULL,
+  tunnel_bytes_sent INTEGER NOT NULL,
+  grpc_service TEXT,
+  grpc_method TEXT,
+  grpc_status INTEGER,
+  client_port INTEGER,
+  connection_reused INTEGER NOT NULL,
+  response_headers TEXT,
+  ttfb INTEGER NOT NULL,
+  upstream TEXT,
+  content_encoding TEXT,
+  uncompressed_size INTEGER,
+  country TEXT,
+  region TEXT,
+  city TEXT,
+  asn INTEGER,
+  as_org TEXT,
+  browser TEXT,
+  browser_version TEXT,
+  os TEXT,
+  os_version TEXT,
+  device_type TEXT,
+  is_bot INTEGER NOT NULL,
+  bot_name TEXT,
+  rdns TEXT,
+  utm_source TEXT,
+  utm_medium TEXT,
+  utm_campaign TEXT,
+  utm_term TEXT,
+  utm_content TEXT,
+  referrer_domain TEXT,
+  referrer_type TEXT,
+  language TEXT,
+  is_datacenter INTEGER NOT NULL,
+  is_vpn INTEGER NOT NULL,
+  is_tor INTEGER NOT NULL,
+  visitor_id TEXT,
+  session_id TEXT,
+  tls_version TEXT,
+  tls_cipher TEXT,
+  tls_fingerprint TEXT,
+  raw_path TEXT,
+  extra TEXT,
+  record_id TEXT
+)`
+
+// timescaleCreateTable matches schema_timescaledb.sql, which has no serial
+// primary key as hypertables require every unique index to include the
+// partitioning column.
+var timescaleCreateTable = strings.Replace(postgresCreateTable, "  id SERIAL PRIMARY KEY,\n", "", 1)
+
+var postgresMigrations = []sqlMigration{
+	{1, []string{
+		postgresCreateTable,
+		"CREATE INDEX IF NOT EXISTS {idx_request_time} ON {table} (request_time)",
+		"CREATE INDEX IF NOT EXISTS {idx_path} ON {table} (path)",
+		"CREATE INDEX IF NOT EXISTS {idx_ip} ON {table} (ip)",
+	}},
+	// Unique indexes of partitioned tables must include request_time.
+	{2, []string{"CREATE UNIQUE INDEX IF NOT EXISTS {idx_record_id} ON {table} (record_id, request_time)"}},
+}
+
+var mysqlMigrations = []sqlMigration{
+	{1, []string{mysqlCreateTable}},
+	{2, []string{"CREATE UNIQUE INDEX {idx_record_id} ON {table} (record_id)"}},
+}
+
+var sqliteMigrations = []sqlMigration{
+	{1, []string{
+		sqliteCreateTable,
+		"CREATE INDEX IF NOT EXISTS {idx_request_time} ON {table} (request_time)",
+		"CREATE INDEX IF NOT EXISTS {idx_path} ON {table} (path)",
+		"CREATE INDEX IF NOT EXISTS {idx_ip} ON {table} (ip)",
+	}},
+	{2, []string{"CREATE UNIQUE INDEX IF NOT EXISTS {idx_record_id} ON {table} (record_id)"}},
+}
+
+var timescaleMigrations = []sqlMigration{
+	{1, []string{
+		timescaleCreateTable,
+		"CREATE INDEX IF NOT EXISTS {idx_path} ON {table} (path, request_time DESC)",
+		"CREATE INDEX IF NOT EXISTS {idx_ip} ON {table} (ip, request_time DESC)",
+	}},
+	// Unique indexes of hypertables must include request_time.
+	{2, []string{"CREATE UNIQUE INDEX IF NOT EXISTS {idx_record_id} ON {table} (record_id, request_time)"}},
+}
+
+// withCreateTable returns d creating its table with createTable, in place of
+// the statement of d in its migrations too.
+func (d sqlDialect) withCreateTable(createTable string) sqlDialect {
+	migrations := make([]sqlMigration, len(d.migrations))
+	for i, migration := range d.migrations {
+		statements := make([]string, len(migration.statements))
+		for j, statement := range migration.statements {
+			if statement == d.createTable {
+				statement = createTable
+			}
+			statements[j] = statement
+		}
+		migrations[i] = sqlMigration{version: migration.version, statements: statements}
+	}
+	d.createTable = createTable
+	d.migrations = migrations
+	return d
+}
+
+// migrateSQL applies the migrations of dialect that the database has not
+// seen yet to table, recording them in a table named after it with the
+// suffix _migrations. Columns the plugin writes but the table lacks, e.g.
+// if it was created from an older schema file, are then added, so that
+// inserts do not fail on schema drift.
+func migrateSQL(db *sql.DB, dialect sqlDialect, table sqlTable) error {
+	if table.schema != "" && dialect.createSchema != "" {
+		_, err := db.Exec(dialect.createSchema + dialect.quote(table.schema))
+		if err != nil {
+			return fmt.Errorf("failed to create schema: %v", err)
+		}
+	}
+	migrations := table.qualify(table.name + "_migrations")
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + migrations + " (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	var current int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM " + migrations).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	// The first migration creates the table. Columns an existing table
+	// lacks are added before later migrations, which may index them.
+	columnsAdded := false
+	for _, migration := range dialect.migrations {
+		if migration.version <= current {
+			continue
+		}
+		if migration.version > 1 && !columnsAdded {
+			err := addMissingColumns(db, dialect, table)
+			if err != nil {
+				return err
+			}
+			columnsAdded = true
+		}
+		for _, statement := range migration.statements {
+			_, err := db.Exec(table.expand(statement))
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d: %v", migration.version, err)
+			}
+		}
+		_, err := db.Exec("INSERT INTO "+migrations+" (version, applied_at) VALUES ("+
+			dialect.placeholder(1)+", "+dialect.placeholder(2)+")", migration.version, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d: %v", migration.version, err)
+		}
+		log.Printf("Applied schema migration %d to %s", migration.version, table.quoted)
+	}
+	if columnsAdded {
+		return nil
+	}
+	return addMissingColumns(db, dialect, table)
+}
+
+// addMissingColumns adds the columns of sqlColumns that table lacks, as
+// defined in the CREATE TABLE statement of dialect.
+func addMissingColumns(db *sql.DB, dialect sqlDialect, table sqlTable) error {
+	existing, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range sqlColumns {
+		if existing[col.name] {
+			continue
+		}
+		definition := columnDefinition(dialect.createTable, col.name)
+		if definition == "" {
+			return fmt.Errorf("%s has no column %s", table.quoted, col.name)
+		}
+		_, err := db.Exec("ALTER TABLE " + table.quoted + " ADD COLUMN " + definition)
+		if err != nil {
+			return fmt.Errorf("failed to add column %s: %v", col.name, err)
+		}
+		log.Printf("Added missing column %s to %s", col.name, table.quoted)
+	}
+	return nil
+}
+
+// tableColumns returns the lowercased names of the columns of table.
+func tableColumns(db *sql.DB, table sqlTable) (map[string]bool, error) {
+	rows, err := db.Query("SELECT * FROM " + table.quoted + " WHERE 1 = 0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %v", err)
+	}
+	names, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %v", err)
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[strings.ToLower(name)] = true
+	}
+	return existing, nil
+}
+
+// columnDefinition returns the definition of column in createTable without
+// NOT NULL, as the rows already stored have no value for it.
+func columnDefinition(createTable, column string) string {
+	for _, line := range strings.Split(createTable, "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if strings.HasPrefix(line, column+" ") {
+			return strings.Replace(line, " NOT NULL", "", 1)
+		}
+	}
+	return ""
+}
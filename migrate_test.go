@@ -0,0 +1,138 @@
+package traefik_analytics
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// baselineTable is request_logs as created by the first schema.sql, in
+// SQLite types.
+const baselineTable = `CREATE TABLE request_logs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ip TEXT NOT NULL,
+  user_agent TEXT,
+  path TEXT NOT NULL,
+  request_time TIMESTAMP NOT NULL,
+  method TEXT NOT NULL,
+  protocol TEXT NOT NULL,
+  host TEXT NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length INTEGER,
+  response_time INTEGER NOT NULL
+)`
+
+// openBaselineDB returns a SQLite database holding the baseline table and
+// a configuration writing to it.
+func openBaselineDB(t *testing.T) (*sql.DB, *Config) {
+	t.Helper()
+	config := CreateConfig()
+	config.DatabaseDSN = filepath.Join(t.TempDir(), "analytics.db")
+	db, err := sql.Open("sqlite", config.DatabaseDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(baselineTable); err != nil {
+		t.Fatal(err)
+	}
+	return db, config
+}
+
+func writeTestRecord(t *testing.T, sink Sink) {
+	t.Helper()
+	data := RequestData{
+		IP:       "203.0.113.7",
+		Path:     "/",
+		Time:     time.Now(),
+		Method:   "GET",
+		Protocol: "HTTP/1.1",
+		Host:     "example.com",
+		RecordID: newUUIDv7(time.Now()),
+	}
+	if err := sink.Write(context.Background(), []RequestData{data}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBaselineTable(t *testing.T) {
+	db, config := openBaselineDB(t)
+	table := newSQLTable(sqliteDialect, config)
+	for run := 0; run < 2; run++ {
+		if err := migrateSQL(db, sqliteDialect, table); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range sqlColumns {
+		if !columns[col.name] {
+			t.Errorf("column %s was not added", col.name)
+		}
+	}
+	var version int
+	if err := db.QueryRow(`SELECT MAX(version) FROM request_logs_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if want := sqliteMigrations[len(sqliteMigrations)-1].version; version != want {
+		t.Errorf("schema version %d, want %d", version, want)
+	}
+
+	sink, err := newSQLSink(sqliteDialect, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	writeTestRecord(t, sink)
+}
+
+func TestWriteBaselineTableWithoutMigration(t *testing.T) {
+	db, config := openBaselineDB(t)
+	dialect := sqliteDialect
+	dialect.autoMigrate = false
+	sink, err := newSQLSink(dialect, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	writeTestRecord(t, sink)
+
+	var host string
+	if err := db.QueryRow(`SELECT host FROM request_logs`).Scan(&host); err != nil {
+		t.Fatal(err)
+	}
+	if host != "example.com" {
+		t.Errorf("stored host %q, want example.com", host)
+	}
+	if len(sink.columns) != 12 {
+		t.Errorf("writing %d columns, want the 12 of the baseline table", len(sink.columns))
+	}
+}
+
+func TestColumnDefinition(t *testing.T) {
+	tests := []struct {
+		createTable string
+		column      string
+		want        string
+	}{
+		{postgresCreateTable, "path", "path TEXT"},
+		{postgresCreateTable, "response_time", "response_time INTERVAL"},
+		{postgresCreateTable, "extra", "extra JSONB"},
+		{postgresCreateTable, "record_id", "record_id UUID"},
+		{mysqlCreateTable, "record_id", "record_id CHAR(36)"},
+		{sqliteCreateTable, "ip", "ip TEXT"},
+		{sqliteCreateTable, "missing", ""},
+	}
+	for _, tt := range tests {
+		if got := columnDefinition(tt.createTable, tt.column); got != tt.want {
+			t.Errorf("columnDefinition(%s) = %q, want %q", tt.column, got, tt.want)
+		}
+	}
+}
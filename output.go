@@ -0,0 +1,363 @@
+package traefik_analytics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceDelay is how long after a backend is opened its first upkeep
+// runs; later upkeep runs every maintenanceInterval.
+const maintenanceDelay = time.Minute
+
+// maintainer is implemented by sinks with periodic upkeep, such as deleting
+// expired records.
+type maintainer interface {
+	maintain(ctx context.Context)
+}
+
+// singleWorkerSinks keep state that must not be split across several
+// instances, such as a local file or cumulative counters, and always run a
+// single worker.
+var singleWorkerSinks = map[string]bool{
+	"file":       true,
+	"prometheus": true,
+	"sqlite":     true,
+}
+
+// output is a storage backend together with its own queue and worker, so
+// that a slow or failing backend does not hold back the others.
+type output struct {
+	storageType   string
+	config        *Config
+	dataChan      chan RequestData
+	batchSize     int
+	flushInterval time.Duration
+	// workers is the number of workers, each with its own connection.
+	workers int
+	// done is closed when the middleware shuts down.
+	done            <-chan struct{}
+	shutdownTimeout time.Duration
+	// spill holds records while the backend is unreachable; nil if
+	// spilling is disabled.
+	spill *spillFile
+	// retryInitial and retryMax bound the backoff between attempts to
+	// open the backend.
+	retryInitial time.Duration
+	retryMax     time.Duration
+	backpressure *backpressure
+	breaker      *circuitBreaker
+	// deadLetters receives rejected records; nil without a dead-letter
+	// file.
+	deadLetters *deadLetterFile
+	// wal replaces the queue when the write-ahead log is enabled.
+	wal *writeAheadLog
+	// maintaining is set while the sink of a worker is being maintained,
+	// so that workers do not run the same upkeep concurrently.
+	maintaining atomic.Bool
+}
+
+// newOutput creates the queue for a storage backend of the middleware
+// instance name. The workers are started separately with start, and stop
+// once ctx is done.
+func newOutput(ctx context.Context, name, storageType string, config *Config) (*output, error) {
+	flushInterval, _ := time.ParseDuration(config.FlushInterval)
+	shutdownTimeout, _ := time.ParseDuration(config.ShutdownTimeout)
+	retryInitial, _ := time.ParseDuration(config.RetryBackoff.InitialInterval)
+	retryMax, _ := time.ParseDuration(config.RetryBackoff.MaxInterval)
+	workers := config.WorkerCount
+	if singleWorkerSinks[storageType] || config.WAL.Directory != "" {
+		workers = 1
+	}
+	o := &output{
+		storageType:     storageType,
+		config:          config,
+		dataChan:        make(chan RequestData, config.QueueSize),
+		batchSize:       config.BatchSize,
+		flushInterval:   flushInterval,
+		workers:         workers,
+		done:            ctx.Done(),
+		shutdownTimeout: shutdownTimeout,
+		breaker:         newCircuitBreaker(config.CircuitBreaker, storageType),
+		retryInitial:    retryInitial,
+		retryMax:        retryMax,
+	}
+	o.backpressure = newBackpressure(config.Backpressure, config.QueueMaxBytes, o.dataChan, name, storageType)
+	if config.DeadLetter.Path != "" {
+		o.deadLetters = &deadLetterFile{path: config.DeadLetter.Path}
+	}
+	if config.WAL.Directory != "" {
+		wal, err := openWriteAheadLog(config.WAL, name, storageType)
+		if err != nil {
+			return nil, err
+		}
+		o.wal = wal
+	}
+	if config.Spill.Directory != "" {
+		spill, err := newSpillFile(config.Spill, name, storageType)
+		if err != nil {
+			return nil, err
+		}
+		o.spill = spill
+	}
+	return o, nil
+}
+
+// start starts the workers.
+func (o *output) start() {
+	for i := 0; i < o.workers; i++ {
+		go o.processingWorker()
+	}
+}
+
+// enqueue hands a record to the worker, applying the backpressure policy
+// if the queue is full, or appends it to the write-ahead log. Records are
+// discarded once the middleware shuts down.
+func (o *output) enqueue(data RequestData) {
+	select {
+	case <-o.done:
+		return
+	default:
+	}
+	if o.wal != nil {
+		if err := o.wal.append(&data); err != nil {
+			o.backpressure.drop(err.Error())
+		}
+		return
+	}
+	o.backpressure.enqueue(o.dataChan, data)
+}
+
+// processingWorker hands queued records to the storage backend until the
+// middleware shuts down. Opening the backend is retried with exponential
+// backoff and jitter, so that many Traefik instances do not reconnect in
+// lockstep after an outage.
+func (o *output) processingWorker() {
+	for attempt := 1; ; attempt++ {
+		err := o.runWorker()
+		if err == nil {
+			return
+		}
+		delay := o.retryDelay(attempt)
+		log.Printf("Worker for %s encountered an error (attempt %d), retrying in %s: %v", o.storageType, attempt, delay, err)
+		if !o.wait(delay) {
+			return
+		}
+	}
+}
+
+// retryDelay returns the backoff before the given retry: the initial
+// interval doubled for every previous attempt, capped at the maximum, of
+// which a random half is kept.
+func (o *output) retryDelay(attempt int) time.Duration {
+	delay := o.retryMax
+	if attempt < 32 && o.retryInitial<<(attempt-1) < o.retryMax {
+		delay = o.retryInitial << (attempt - 1)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// wait pauses for d before the backend is opened again, spilling queued
+// records meanwhile if enabled. It reports false once the middleware shuts
+// down.
+func (o *output) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var queue <-chan RequestData
+	if o.spill != nil {
+		queue = o.dataChan
+	}
+	batch := make([]RequestData, 0, o.batchSize)
+	for {
+		select {
+		case data := <-queue:
+			o.backpressure.dequeued(&data)
+			batch = o.drainQueued(append(batch[:0], data))
+			o.spillBatch(batch)
+		case <-timer.C:
+			return true
+		case <-o.done:
+			if o.spill != nil {
+				o.spillQueued()
+			}
+			return false
+		}
+	}
+}
+
+// runWorker opens the storage backend and feeds it queued records in
+// batches. A batch is written once it holds batchSize records or its first
+// record has waited for flushInterval.
+func (o *output) runWorker() error {
+	sink, err := newSink(o.storageType, o.config)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	if m, ok := sink.(maintainer); ok && o.maintaining.CompareAndSwap(false, true) {
+		defer o.maintain(m)()
+	}
+	if o.wal != nil {
+		return o.shipWAL(sink)
+	}
+	o.replaySpilled(sink)
+
+	batch := make([]RequestData, 0, o.batchSize)
+	timer := time.NewTimer(o.flushInterval)
+	timer.Stop()
+	for {
+		select {
+		case data := <-o.dataChan:
+			o.backpressure.dequeued(&data)
+			if len(batch) == 0 {
+				timer.Reset(o.flushInterval)
+			}
+			batch = append(batch, data)
+			if len(batch) < o.batchSize {
+				continue
+			}
+			timer.Stop()
+		case <-timer.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-o.done:
+			o.flushQueued(sink, batch)
+			return nil
+		}
+
+		o.write(context.Background(), sink, batch)
+		batch = batch[:0]
+	}
+}
+
+// maintain runs the upkeep of m periodically until the middleware shuts
+// down or the returned function is called, which waits for the upkeep to
+// stop so that the sink can be closed.
+func (o *output) maintain(m maintainer) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		timer := time.NewTimer(maintenanceDelay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			case <-o.done:
+				return
+			}
+			m.maintain(ctx)
+			timer.Reset(maintenanceInterval)
+		}
+	}()
+	return func() {
+		cancel()
+		<-stopped
+		o.maintaining.Store(false)
+	}
+}
+
+// write hands batch to the backend. If the backend fails without storing
+// any of the records, or the circuit is open, they are spilled if enabled;
+// after a successful write, earlier spilled records are replayed.
+func (o *output) write(ctx context.Context, sink Sink, batch []RequestData) {
+	if !o.breaker.allow() {
+		if o.spill == nil {
+			stats.Add(o.backpressure.statsPrefix+"circuitOpen", int64(len(batch)))
+		}
+		o.spillBatch(batch)
+		return
+	}
+	err := sink.Write(ctx, batch)
+	var partial *partialWriteError
+	o.breaker.record(err == nil || errors.As(err, &partial))
+	if err == nil {
+		o.replaySpilled(sink)
+		return
+	}
+	log.Printf("Failed to write data to %s: %v", o.storageType, err)
+	// Continue processing other requests
+	if partial == nil {
+		o.spillBatch(batch)
+		return
+	}
+	o.storeRejected(ctx, sink, partial.rejected)
+}
+
+// spillBatch stores batch in the spill file, if enabled.
+func (o *output) spillBatch(batch []RequestData) {
+	if o.spill == nil {
+		return
+	}
+	err := o.spill.append(batch)
+	if err != nil {
+		log.Printf("Failed to spill data for %s: %v", o.storageType, err)
+	}
+}
+
+// replaySpilled writes spilled records to the backend, if there are any.
+func (o *output) replaySpilled(sink Sink) {
+	if o.spill == nil || !o.spill.pending() {
+		return
+	}
+	ctx := context.Background()
+	err := o.spill.replay(ctx, sink, o.batchSize, func(rejected []rejectedRecord) {
+		o.storeRejected(ctx, sink, rejected)
+	})
+	if err != nil {
+		log.Printf("Failed to replay spilled data to %s: %v", o.storageType, err)
+	}
+}
+
+// flushQueued writes batch and the records still queued before shutdown.
+// After shutdownTimeout, the remaining records are spilled if enabled, or
+// discarded.
+func (o *output) flushQueued(sink Sink, batch []RequestData) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	for {
+		batch = o.drainQueued(batch)
+		if len(batch) == 0 {
+			return
+		}
+		o.write(ctx, sink, batch)
+		if ctx.Err() != nil {
+			break
+		}
+		batch = batch[:0]
+	}
+	if o.spill == nil {
+		log.Printf("Shutdown timeout for %s reached, discarding %d queued records", o.storageType, len(o.dataChan))
+		return
+	}
+	o.spillQueued()
+}
+
+// spillQueued moves all queued records to the spill file.
+func (o *output) spillQueued() {
+	batch := make([]RequestData, 0, o.batchSize)
+	for batch = o.drainQueued(batch); len(batch) > 0; batch = o.drainQueued(batch[:0]) {
+		o.spillBatch(batch)
+	}
+}
+
+// drainQueued appends records that are already waiting in the queue, up to
+// the capacity of batch, without blocking.
+func (o *output) drainQueued(batch []RequestData) []RequestData {
+	for len(batch) < cap(batch) {
+		select {
+		case data := <-o.dataChan:
+			o.backpressure.dequeued(&data)
+			batch = append(batch, data)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
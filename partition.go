@@ -0,0 +1,261 @@
+package traefik_analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Partitioning intervals.
+const (
+	partitionDaily   = "daily"
+	partitionMonthly = "monthly"
+)
+
+// maxPostgresIdentifier is the length in bytes above which PostgreSQL
+// truncates identifiers.
+const maxPostgresIdentifier = 63
+
+// partitionSuffixLength is the length of the longest partition suffix,
+// that of daily partitions.
+const partitionSuffixLength = len("_p20060102")
+
+// PartitioningConfig splits the table of the postgres backend into
+// partitions by request_time, using declarative partitioning. Partitions are
+// created ahead of time, and with RetentionDays, expired partitions are
+// dropped instead of deleting rows. Records outside the created partitions
+// are kept in a default partition, and moved to their partition once it is
+// created.
+//
+// With AutoMigrate, the table is created partitioned. An existing table
+// must already be partitioned by range on request_time, which requires
+// dropping the id primary key of schema.sql.
+type PartitioningConfig struct {
+	// Interval is "daily" or "monthly"; empty disables partitioning.
+	Interval string `json:"interval,omitempty"`
+	// Premake is the number of partitions created ahead of the current
+	// one.
+	Premake int `json:"premake,omitempty"`
+}
+
+func validatePartitioningConfig(c PartitioningConfig, tableName string) error {
+	if c.Interval != "" && len(tableName) > maxPostgresIdentifier {
+		return fmt.Errorf("invalid tableName %q: partitioned tables are limited to %d bytes", tableName, maxPostgresIdentifier)
+	}
+	switch c.Interval {
+	case "", partitionDaily, partitionMonthly:
+	default:
+		return fmt.Errorf("invalid partitioning.interval %q", c.Interval)
+	}
+	if c.Premake < 0 {
+		return fmt.Errorf("partitioning.premake must not be negative")
+	}
+	return nil
+}
+
+// partitionedDialect returns dialect with its table created partitioned by
+// range on request_time. Unique indexes of a partitioned table must include
+// the partitioning column, so it has no id primary key.
+func partitionedDialect(d sqlDialect) sqlDialect {
+	return d.withCreateTable(strings.Replace(d.createTable, "  id SERIAL PRIMARY KEY,\n", "", 1) + " PARTITION BY RANGE (request_time)")
+}
+
+// partitionManager creates and drops the partitions of a table.
+type partitionManager struct {
+	table sqlTable
+	// prefix starts the names of the partitions.
+	prefix string
+	// timeColumn is the quoted column the table is partitioned by.
+	timeColumn    string
+	monthly       bool
+	premake       int
+	retentionDays int
+}
+
+func newPartitionManager(table sqlTable, config *Config) *partitionManager {
+	return &partitionManager{
+		table:         table,
+		prefix:        partitionPrefix(table.name),
+		timeColumn:    table.quote(mappedColumn(config.Columns, "request_time")),
+		monthly:       config.Partitioning.Interval == partitionMonthly,
+		premake:       config.Partitioning.Premake,
+		retentionDays: config.RetentionDays,
+	}
+}
+
+// partitionPrefix returns the prefix of the partition names of table. It is
+// the table name unless the names would be truncated, in which case the
+// name is shortened and a hash of it appended, so that tables sharing a
+// long prefix keep distinct partitions.
+func partitionPrefix(table string) string {
+	if len(table)+partitionSuffixLength <= maxPostgresIdentifier {
+		return table
+	}
+	h := fnv.New32a()
+	h.Write([]byte(table))
+	hash := fmt.Sprintf("_%08x", h.Sum32())
+	end := maxPostgresIdentifier - partitionSuffixLength - len(hash)
+	for end > 0 && !utf8.RuneStart(table[end]) {
+		end--
+	}
+	return table[:end] + hash
+}
+
+// start returns the start of the partition holding t.
+func (p *partitionManager) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p.monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the partition following the one starting at
+// start.
+func (p *partitionManager) next(start time.Time) time.Time {
+	if p.monthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// name returns the name of the partition starting at start, e.g.
+// request_logs_p20240131 or request_logs_p202401.
+func (p *partitionManager) name(start time.Time) string {
+	if p.monthly {
+		return p.prefix + "_p" + start.Format("200601")
+	}
+	return p.prefix + "_p" + start.Format("20060102")
+}
+
+// defaultName returns the name of the default partition.
+func (p *partitionManager) defaultName() string {
+	return p.prefix + "_default"
+}
+
+// parseName returns the start of the partition with the given name, or
+// false if it was not created by the manager.
+func (p *partitionManager) parseName(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, p.prefix+"_p")
+	if !ok {
+		return time.Time{}, false
+	}
+	layout := "20060102"
+	if p.monthly {
+		layout = "200601"
+	}
+	start, err := time.Parse(layout, suffix)
+	return start, err == nil && len(suffix) == len(layout)
+}
+
+// maintain creates the default, current and upcoming partitions and drops
+// expired ones.
+func (p *partitionManager) maintain(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+p.table.qualify(p.defaultName())+
+		" PARTITION OF "+p.table.quoted+" DEFAULT")
+	if err != nil {
+		return fmt.Errorf("failed to create default partition: %v", err)
+	}
+	now := time.Now()
+	start := p.start(now)
+	for i := 0; i <= p.premake; i++ {
+		end := p.next(start)
+		err := p.create(ctx, db, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to create partition: %v", err)
+		}
+		start = end
+	}
+	if p.retentionDays == 0 {
+		return nil
+	}
+
+	cutoff := now.AddDate(0, 0, -p.retentionDays)
+	_, err = db.ExecContext(ctx, "DELETE FROM "+p.table.qualify(p.defaultName())+" WHERE "+p.timeColumn+" < $1", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired rows of the default partition: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = $1::regclass`, p.table.quoted)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %v", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list partitions: %v", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	for _, name := range names {
+		start, ok := p.parseName(name)
+		if !ok || p.next(start).After(cutoff) {
+			continue
+		}
+		_, err := db.ExecContext(ctx, "ALTER TABLE "+p.table.quoted+" DETACH PARTITION "+p.table.qualify(name))
+		if err == nil {
+			_, err = db.ExecContext(ctx, "DROP TABLE "+p.table.qualify(name))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop partition %s: %v", name, err)
+		}
+		log.Printf("Dropped partition %s older than %d days", name, p.retentionDays)
+	}
+	return nil
+}
+
+// create creates the partition from start to end unless it exists. Rows of
+// its range that were stored in the default partition meanwhile are moved
+// to it, since a partition cannot be added while the default one holds
+// rows of its range. A lock on the table keeps concurrent writers from
+// creating the same partition.
+func (p *partitionManager) create(ctx context.Context, db *sql.DB, start, end time.Time) error {
+	name := p.table.qualify(p.name(start))
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::regclass::oid::bigint)`, p.table.quoted)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	defaultPartition := p.table.qualify(p.defaultName())
+	inRange := " WHERE " + p.timeColumn + " >= $1 AND " + p.timeColumn + " < $2"
+	statements := []string{
+		"CREATE TABLE " + name + " (LIKE " + p.table.quoted + " INCLUDING DEFAULTS INCLUDING CONSTRAINTS)",
+		"INSERT INTO " + name + " SELECT * FROM " + defaultPartition + inRange,
+		"DELETE FROM " + defaultPartition + inRange,
+	}
+	for i, statement := range statements {
+		var args []interface{}
+		if i > 0 {
+			args = []interface{}{start, end}
+		}
+		if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')",
+		p.table.quoted, name, start.Format(time.RFC3339), end.Format(time.RFC3339)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,97 @@
+package traefik_analytics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPartitionManager(table, interval string) *partitionManager {
+	config := CreateConfig()
+	config.TableName = table
+	config.Partitioning.Interval = interval
+	return newPartitionManager(newSQLTable(postgresDialect, config), config)
+}
+
+func TestPartitionNames(t *testing.T) {
+	tests := []struct {
+		table    string
+		interval string
+		time     time.Time
+		want     string
+		next     time.Time
+	}{
+		{"request_logs", partitionDaily, time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC), "request_logs_p20240131", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"request_logs", partitionDaily, time.Date(2024, 2, 1, 0, 30, 0, 0, time.FixedZone("", 3600)), "request_logs_p20240131", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"request_logs", partitionMonthly, time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC), "request_logs_p202412", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"request_logs", partitionDaily, time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC), "request_logs_p20240228", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{strings.Repeat("t", 53), partitionDaily, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), strings.Repeat("t", 53) + "_p20240101", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		p := testPartitionManager(tt.table, tt.interval)
+		start := p.start(tt.time)
+		if got := p.name(start); got != tt.want {
+			t.Errorf("name(%s) = %s, want %s", tt.time, got, tt.want)
+		}
+		if got := p.next(start); !got.Equal(tt.next) {
+			t.Errorf("next(%s) = %s, want %s", start, got, tt.next)
+		}
+		if got, ok := p.parseName(tt.want); !ok || !got.Equal(start) {
+			t.Errorf("parseName(%s) = %s, %v, want %s", tt.want, got, ok, start)
+		}
+	}
+}
+
+func TestPartitionNamesOfLongTables(t *testing.T) {
+	tables := []string{
+		strings.Repeat("t", 54),
+		strings.Repeat("t", 63),
+		strings.Repeat("t", 62) + "u",
+		strings.Repeat("é", 31),
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seen := map[string]string{}
+	for _, table := range tables {
+		for _, interval := range []string{partitionDaily, partitionMonthly} {
+			p := testPartitionManager(table, interval)
+			for _, name := range []string{p.name(day), p.defaultName()} {
+				if len(name) > maxPostgresIdentifier {
+					t.Errorf("partition name %s of %s is %d bytes long", name, table, len(name))
+				}
+				if !strings.HasPrefix(name, p.prefix) {
+					t.Errorf("partition name %s of %s lacks the prefix %s", name, table, p.prefix)
+				}
+				if other, ok := seen[name]; ok && other != table {
+					t.Errorf("tables %s and %s share the partition %s", other, table, name)
+				}
+				seen[name] = table
+			}
+			if _, ok := p.parseName(p.name(day)); !ok {
+				t.Errorf("parseName does not accept %s", p.name(day))
+			}
+			if _, ok := p.parseName(p.defaultName()); ok {
+				t.Errorf("parseName accepts the default partition %s", p.defaultName())
+			}
+		}
+	}
+}
+
+func TestValidatePartitioningConfig(t *testing.T) {
+	tests := []struct {
+		config  PartitioningConfig
+		table   string
+		wantErr bool
+	}{
+		{PartitioningConfig{}, strings.Repeat("t", 100), false},
+		{PartitioningConfig{Interval: partitionDaily}, "request_logs", false},
+		{PartitioningConfig{Interval: partitionMonthly, Premake: 3}, strings.Repeat("t", 63), false},
+		{PartitioningConfig{Interval: partitionDaily}, strings.Repeat("t", 64), true},
+		{PartitioningConfig{Interval: "weekly"}, "request_logs", true},
+		{PartitioningConfig{Interval: partitionDaily, Premake: -1}, "request_logs", true},
+	}
+	for _, tt := range tests {
+		if err := validatePartitioningConfig(tt.config, tt.table); (err != nil) != tt.wantErr {
+			t.Errorf("validatePartitioningConfig(%+v, %d bytes) = %v, want error %v", tt.config, len(tt.table), err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PathNormalizationConfig reduces paths to templates such as /users/:id
+// before they are stored, to keep their cardinality low.
+type PathNormalizationConfig struct {
+	// Rules are applied in order to the whole path, replacing all matches.
+	Rules []PathRule `json:"rules,omitempty"`
+	// CollapseIDs replaces path segments that look like IDs: numbers with
+	// :id, UUIDs with :uuid and hex strings of 16 or more digits with :hash.
+	CollapseIDs bool `json:"collapseIDs,omitempty"`
+	// KeepRawPath stores the path before normalization in raw_path.
+	KeepRawPath bool `json:"keepRawPath,omitempty"`
+}
+
+// PathRule replaces matches of Pattern, e.g. "/users/[^/]+" with
+// "/users/:name". Replacement may refer to capture groups as $1.
+type PathRule struct {
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+var uuidSegment = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// pathRule is a compiled PathRule.
+type pathRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// pathNormalizer applies the normalization to records.
+type pathNormalizer struct {
+	rules       []pathRule
+	collapseIDs bool
+	keepRaw     bool
+}
+
+// newPathNormalizer compiles the rules.
+func newPathNormalizer(c PathNormalizationConfig) (*pathNormalizer, error) {
+	n := &pathNormalizer{collapseIDs: c.CollapseIDs, keepRaw: c.KeepRawPath}
+	for i, rule := range c.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pathNormalization.rules[%d].pattern: %v", i, err)
+		}
+		n.rules = append(n.rules, pathRule{re: re, replacement: rule.Replacement})
+	}
+	return n, nil
+}
+
+// normalize replaces the path of data by its template.
+func (n *pathNormalizer) normalize(data *RequestData) {
+	if len(n.rules) == 0 && !n.collapseIDs {
+		return
+	}
+	path := data.Path
+	for _, rule := range n.rules {
+		path = rule.re.ReplaceAllString(path, rule.replacement)
+	}
+	if n.collapseIDs {
+		path = collapseIDSegments(path)
+	}
+	if n.keepRaw {
+		data.RawPath = data.Path
+	}
+	data.Path = path
+}
+
+// collapseIDSegments replaces the path segments that look like IDs.
+func collapseIDSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+		case isDigits(segment):
+			segments[i] = ":id"
+		case uuidSegment.MatchString(segment):
+			segments[i] = ":uuid"
+		case len(segment) >= 16 && isHexID(strings.ToLower(segment)):
+			segments[i] = ":hash"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isDigits reports whether s consists of ASCII digits only.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
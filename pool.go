@@ -0,0 +1,72 @@
+package traefik_analytics
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// maxInternedStrings bounds the memory used by intern. Once reached, new
+// values are returned as is.
+const maxInternedStrings = 10000
+
+// maxInternedLength is the length above which values are not interned, as
+// long values are unlikely to repeat.
+const maxInternedLength = 128
+
+var (
+	recorderPool    = sync.Pool{New: func() interface{} { return new(responseRecorder) }}
+	requestDataPool = sync.Pool{New: func() interface{} { return new(RequestData) }}
+
+	internedStrings sync.Map
+	internedCount   atomic.Int64
+)
+
+// acquireResponseRecorder returns a recorder wrapping rw from the pool.
+func acquireResponseRecorder(rw http.ResponseWriter) *responseRecorder {
+	r := recorderPool.Get().(*responseRecorder)
+	r.ResponseWriter = rw
+	return r
+}
+
+// releaseResponseRecorder returns r to the pool. A decoder that was not
+// finished is stopped. The tunnel, if any, is not affected.
+func releaseResponseRecorder(r *responseRecorder) {
+	if r.decoder != nil {
+		r.decoder.pw.Close()
+	}
+	*r = responseRecorder{}
+	recorderPool.Put(r)
+}
+
+// acquireRequestData returns a zeroed record from the pool. Records are
+// built and enriched in place, then copied into the queues by value, so a
+// pooled record is released as soon as it has been handed on.
+func acquireRequestData() *RequestData {
+	return requestDataPool.Get().(*RequestData)
+}
+
+// releaseRequestData returns data to the pool.
+func releaseRequestData(data *RequestData) {
+	*data = RequestData{}
+	requestDataPool.Put(data)
+}
+
+// intern returns a shared copy of s, so that the many queued records with
+// the same method, protocol or host do not each hold their own.
+func intern(s string) string {
+	if s == "" || len(s) > maxInternedLength {
+		return s
+	}
+	if v, ok := internedStrings.Load(s); ok {
+		return v.(string)
+	}
+	if internedCount.Load() >= maxInternedStrings {
+		return s
+	}
+	v, loaded := internedStrings.LoadOrStore(s, s)
+	if !loaded {
+		internedCount.Add(1)
+	}
+	return v.(string)
+}
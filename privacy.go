@@ -0,0 +1,122 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PrivacySignalsConfig sets how requests with the Do Not Track (DNT: 1) or
+// Global Privacy Control (Sec-GPC: 1) header are recorded: "ignore" records
+// them like any other request, "skip" records nothing, and "strip" records
+// them without identifying fields. When both headers are present, the
+// stricter handling applies.
+type PrivacySignalsConfig struct {
+	DNT string `json:"dnt,omitempty"`
+	GPC string `json:"gpc,omitempty"`
+}
+
+// ConsentConfig gates identifying fields on a consent cookie. When
+// CookieName is set, requests without the cookie are recorded without
+// identifying fields, as with the "strip" privacy signal handling.
+type ConsentConfig struct {
+	CookieName string `json:"cookieName,omitempty"`
+	// CookieValue, if set, must be contained in the cookie value, e.g.
+	// "analytics:yes" for consent managers storing several categories in
+	// one cookie.
+	CookieValue string `json:"cookieValue,omitempty"`
+}
+
+// Privacy signal handling, from least to most strict.
+const (
+	privacyIgnore = iota
+	privacyStrip
+	privacySkip
+)
+
+// privacySignals decides how a request is recorded from its headers and
+// consent cookie.
+type privacySignals struct {
+	dnt, gpc int
+	consent  ConsentConfig
+}
+
+func newPrivacySignals(c PrivacySignalsConfig, consent ConsentConfig) (*privacySignals, error) {
+	dnt, err := parsePrivacyHandling("privacySignals.dnt", c.DNT)
+	if err != nil {
+		return nil, err
+	}
+	gpc, err := parsePrivacyHandling("privacySignals.gpc", c.GPC)
+	if err != nil {
+		return nil, err
+	}
+	return &privacySignals{dnt: dnt, gpc: gpc, consent: consent}, nil
+}
+
+func parsePrivacyHandling(name, value string) (int, error) {
+	switch value {
+	case "ignore":
+		return privacyIgnore, nil
+	case "strip":
+		return privacyStrip, nil
+	case "skip":
+		return privacySkip, nil
+	}
+	return 0, fmt.Errorf("unsupported %s %q", name, value)
+}
+
+// handling returns how req is to be recorded.
+func (p *privacySignals) handling(req *http.Request) int {
+	handling := privacyIgnore
+	if strings.TrimSpace(req.Header.Get("DNT")) == "1" {
+		handling = p.dnt
+	}
+	if strings.TrimSpace(req.Header.Get("Sec-GPC")) == "1" && p.gpc > handling {
+		handling = p.gpc
+	}
+	if handling < privacyStrip && !p.consented(req) {
+		handling = privacyStrip
+	}
+	return handling
+}
+
+// consented reports whether req carries the consent cookie, or true if
+// consent is not required.
+func (p *privacySignals) consented(req *http.Request) bool {
+	if p.consent.CookieName == "" {
+		return true
+	}
+	cookie, err := req.Cookie(p.consent.CookieName)
+	if err != nil {
+		return false
+	}
+	if strings.Contains(cookie.Value, p.consent.CookieValue) {
+		return true
+	}
+	// Consent managers often URL-encode the value.
+	value, err := url.QueryUnescape(cookie.Value)
+	return err == nil && strings.Contains(value, p.consent.CookieValue)
+}
+
+// stripIdentifying clears the fields of data that identify the client or
+// allow following it across requests or to other sites, such as the query
+// string and referer, which may carry tokens or user IDs, and the IDs
+// shared with tracing systems. Derived, coarse fields such as the country,
+// referrer domain or browser family are kept.
+func stripIdentifying(data *RequestData) {
+	data.IP = ""
+	data.ClientPort = 0
+	data.RDNS = ""
+	data.City = ""
+	data.UserAgent = ""
+	data.Referer = ""
+	data.QueryString = ""
+	data.Headers = nil
+	data.Cookies = nil
+	data.TraceID = ""
+	data.RequestID = ""
+	data.VisitorID = ""
+	data.SessionID = ""
+	data.TLSFingerprint = ""
+}
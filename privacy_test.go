@@ -0,0 +1,43 @@
+package traefik_analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripIdentifying(t *testing.T) {
+	data := RequestData{
+		IP:             "203.0.113.7",
+		ClientPort:     51234,
+		RDNS:           "host.example.net",
+		City:           "Lyon",
+		UserAgent:      "Mozilla/5.0",
+		Referer:        "https://example.org/account?user=42",
+		QueryString:    "token=secret",
+		Headers:        map[string]string{"x-user": "42"},
+		Cookies:        map[string]string{"session": "abc"},
+		TraceID:        "4bf92f3577b34da6a3ce929d0e0e4736",
+		RequestID:      "req-1",
+		VisitorID:      "v1",
+		SessionID:      "s1",
+		TLSFingerprint: "t13d1516h2",
+
+		Path:           "/pricing",
+		Country:        "FR",
+		Browser:        "Firefox",
+		ReferrerDomain: "example.org",
+		UTMSource:      "newsletter",
+	}
+	stripIdentifying(&data)
+
+	want := RequestData{
+		Path:           "/pricing",
+		Country:        "FR",
+		Browser:        "Firefox",
+		ReferrerDomain: "example.org",
+		UTMSource:      "newsletter",
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("stripped record\n%+v\nwant\n%+v", data, want)
+	}
+}
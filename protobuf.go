@@ -0,0 +1,33 @@
+package traefik_analytics
+
+import "bytes"
+
+// Minimal protocol buffers wire-format encoding for the few fixed messages
+// the plugin sends, see https://protobuf.dev/programming-guides/encoding/
+
+// appendProtoVarint appends v in base-128 varint encoding.
+func appendProtoVarint(buf *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	buf.WriteByte(byte(v))
+}
+
+// appendProtoBytes appends a length-delimited field.
+func appendProtoBytes(buf *bytes.Buffer, field int, data []byte) {
+	appendProtoVarint(buf, uint64(field)<<3|2)
+	appendProtoVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// appendProtoString appends a string field.
+func appendProtoString(buf *bytes.Buffer, field int, s string) {
+	appendProtoBytes(buf, field, []byte(s))
+}
+
+// appendProtoUint appends a varint field.
+func appendProtoUint(buf *bytes.Buffer, field int, v uint64) {
+	appendProtoVarint(buf, uint64(field)<<3)
+	appendProtoVarint(buf, v)
+}
@@ -0,0 +1,64 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Vectors from https://protobuf.dev/programming-guides/encoding/ and the
+// limits of the varint encoding.
+func TestAppendProtoVarint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "00"},
+		{1, "01"},
+		{127, "7f"},
+		{128, "8001"},
+		{150, "9601"},
+		{300, "ac02"},
+		{16383, "ff7f"},
+		{16384, "808001"},
+		{1<<32 - 1, "ffffffff0f"},
+		{1<<63 - 1, "ffffffffffffffff7f"},
+		{1<<64 - 1, "ffffffffffffffffff01"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		appendProtoVarint(&buf, tt.v)
+		if got := hex.EncodeToString(buf.Bytes()); got != tt.want {
+			t.Errorf("appendProtoVarint(%d) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestAppendProtoFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		append func(*bytes.Buffer)
+		want   string
+	}{
+		// Field 1 = 150, the first example of the encoding guide.
+		{"varint", func(b *bytes.Buffer) { appendProtoUint(b, 1, 150) }, "089601"},
+		// Field 2 = "testing".
+		{"string", func(b *bytes.Buffer) { appendProtoString(b, 2, "testing") }, "120774657374696e67"},
+		{"empty bytes", func(b *bytes.Buffer) { appendProtoBytes(b, 3, nil) }, "1a00"},
+		// Field numbers from 16 on take two bytes for the tag.
+		{"large field number", func(b *bytes.Buffer) { appendProtoUint(b, 16, 1) }, "800101"},
+		// Field 3 holding the message with field 1 = 150.
+		{"embedded message", func(b *bytes.Buffer) {
+			var msg bytes.Buffer
+			appendProtoUint(&msg, 1, 150)
+			appendProtoBytes(b, 3, msg.Bytes())
+		}, "1a03089601"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tt.append(&buf)
+		if got := hex.EncodeToString(buf.Bytes()); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// QueryConfig controls whether the query string of a request is recorded.
+type QueryConfig struct {
+	// Mode is "none" to drop the query string, "raw" to keep it as sent or
+	// "params" to keep only the parameters listed in Params.
+	Mode   string   `json:"mode,omitempty"`
+	Params []string `json:"params,omitempty"`
+	// Denylist names parameters that are never recorded, whatever the mode.
+	// Names are matched case-insensitively.
+	Denylist []string `json:"denylist,omitempty"`
+}
+
+// queryFilter reduces a raw query string to what may be recorded.
+type queryFilter struct {
+	mode    string
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+// newQueryFilter validates the settings and creates the filter.
+func newQueryFilter(c QueryConfig) (*queryFilter, error) {
+	switch c.Mode {
+	case "none", "raw":
+	case "params":
+		if len(c.Params) == 0 {
+			return nil, fmt.Errorf("query.params is required in params mode")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported query.mode %q", c.Mode)
+	}
+
+	f := &queryFilter{
+		mode:    c.Mode,
+		allowed: map[string]bool{},
+		denied:  map[string]bool{},
+	}
+	for _, name := range c.Params {
+		f.allowed[strings.ToLower(name)] = true
+	}
+	for _, name := range c.Denylist {
+		f.denied[strings.ToLower(name)] = true
+	}
+	return f, nil
+}
+
+// filter returns the parameters of rawQuery that may be recorded, in their
+// original order and encoding.
+func (f *queryFilter) filter(rawQuery string) string {
+	if f.mode == "none" || rawQuery == "" {
+		return ""
+	}
+
+	kept := make([]string, 0, strings.Count(rawQuery, "&")+1)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawName, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		name = strings.ToLower(name)
+		if f.denied[name] || (f.mode == "params" && !f.allowed[name]) {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,138 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitedClients bounds the memory used by rateLimiter. Clients
+// beyond it are not limited until the next summary frees space.
+const maxRateLimitedClients = 100000
+
+// RateLimitConfig caps the records stored for a single client address, so
+// that a scanner or scraper loop cannot flood the storage.
+//
+// Requests over the limit are counted instead of recorded. Every
+// SummaryInterval, a summary record is stored for each client that was
+// limited: its last suppressed request, with the number of suppressed
+// requests in the suppressed_requests key of the extra field.
+type RateLimitConfig struct {
+	// PerMinute is the number of records per minute allowed for a client.
+	// Zero disables the limit.
+	PerMinute int `json:"perMinute,omitempty"`
+	// Burst is the number of records a client may use up at once; it
+	// defaults to PerMinute.
+	Burst           int    `json:"burst,omitempty"`
+	SummaryInterval string `json:"summaryInterval,omitempty"`
+}
+
+// clientBucket is the token bucket of a client.
+type clientBucket struct {
+	tokens     float64
+	updated    time.Time
+	suppressed int
+	last       RequestData
+}
+
+// rateLimiter limits the records per client address.
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+	emit      func(*RequestData)
+
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+}
+
+// newRateLimiter validates the settings and emits summaries to emit until
+// ctx is done.
+func newRateLimiter(ctx context.Context, c RateLimitConfig, emit func(*RequestData)) (*rateLimiter, error) {
+	if c.PerMinute < 0 || c.Burst < 0 {
+		return nil, fmt.Errorf("rateLimit.perMinute and rateLimit.burst must not be negative")
+	}
+	l := &rateLimiter{
+		perSecond: float64(c.PerMinute) / 60,
+		burst:     float64(c.Burst),
+		emit:      emit,
+		clients:   map[string]*clientBucket{},
+	}
+	if c.PerMinute == 0 {
+		return l, nil
+	}
+	if c.Burst == 0 {
+		l.burst = float64(c.PerMinute)
+	}
+	interval, err := time.ParseDuration(c.SummaryInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid rateLimit.summaryInterval %q", c.SummaryInterval)
+	}
+	go l.summarize(ctx, interval)
+	return l, nil
+}
+
+// allow reports whether data is recorded, taking a token from the bucket of
+// its client. Suppressed records are counted for the next summary.
+func (l *rateLimiter) allow(data *RequestData) bool {
+	if l.perSecond == 0 || data.IP == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.clients[data.IP]
+	if !ok {
+		if len(l.clients) >= maxRateLimitedClients {
+			return true
+		}
+		bucket = &clientBucket{tokens: l.burst, updated: data.Time}
+		l.clients[data.IP] = bucket
+	}
+	if elapsed := data.Time.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens = min(l.burst, bucket.tokens+elapsed.Seconds()*l.perSecond)
+		bucket.updated = data.Time
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	bucket.suppressed++
+	bucket.last = *data
+	return false
+}
+
+// summarize periodically emits a summary record for every limited client
+// and forgets clients whose bucket is full again.
+func (l *rateLimiter) summarize(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		var summaries []RequestData
+		l.mu.Lock()
+		for ip, bucket := range l.clients {
+			if bucket.suppressed > 0 {
+				summary := bucket.last
+				summary.Extra = map[string]string{"suppressed_requests": strconv.Itoa(bucket.suppressed)}
+				summary.RecordID = newUUIDv7(now)
+				summaries = append(summaries, summary)
+				bucket.suppressed = 0
+				bucket.last = RequestData{}
+			} else if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.perSecond >= l.burst {
+				delete(l.clients, ip)
+			}
+		}
+		l.mu.Unlock()
+
+		for _, summary := range summaries {
+			l.emit(&summary)
+		}
+	}
+}
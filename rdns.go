@@ -0,0 +1,73 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RDNSConfig enables reverse DNS lookups of client addresses. Lookups run on
+// background workers, so they never delay responses.
+type RDNSConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Timeout bounds each lookup; the name is left empty when it expires.
+	Timeout string `json:"timeout,omitempty"`
+	// CacheSize and CacheTTL control how many results, including failed
+	// lookups, are kept and for how long.
+	CacheSize int    `json:"cacheSize,omitempty"`
+	CacheTTL  string `json:"cacheTTL,omitempty"`
+}
+
+// rdnsEntry is a cached lookup result.
+type rdnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// rdnsEnricher sets the reverse DNS name of the client.
+type rdnsEnricher struct {
+	timeout time.Duration
+	ttl     time.Duration
+	cache   *lruCache
+}
+
+func newRDNSEnricher(c RDNSConfig) (*rdnsEnricher, error) {
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid rdns.timeout %q", c.Timeout)
+	}
+	ttl, err := time.ParseDuration(c.CacheTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid rdns.cacheTTL %q", c.CacheTTL)
+	}
+	if c.CacheSize < 1 {
+		return nil, fmt.Errorf("rdns.cacheSize must be at least 1")
+	}
+	return &rdnsEnricher{timeout: timeout, ttl: ttl, cache: newLRUCache(c.CacheSize)}, nil
+}
+
+func (e *rdnsEnricher) enrich(data *RequestData) {
+	if data.IP == "" {
+		return
+	}
+	now := time.Now()
+	if cached, ok := e.cache.get(data.IP); ok {
+		entry := cached.(rdnsEntry)
+		if now.Before(entry.expires) {
+			data.RDNS = entry.name
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	var name string
+	names, err := net.DefaultResolver.LookupAddr(ctx, data.IP)
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	e.cache.add(data.IP, rdnsEntry{name: name, expires: now.Add(e.ttl)})
+	data.RDNS = name
+}
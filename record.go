@@ -0,0 +1,30 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// recordMap returns the fields of a record as a generic map, using the same
+// names and encoding as the JSON sinks. Numbers are kept as json.Number.
+func recordMap(data *RequestData) (map[string]interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var m map[string]interface{}
+	err = dec.Decode(&m)
+	return m, err
+}
+
+// pageURL returns the URL of the requested page with the recorded query
+// string. The scheme is not part of the record and assumed to be https.
+func pageURL(data *RequestData) string {
+	u := "https://" + data.Host + data.Path
+	if data.QueryString != "" {
+		u += "?" + data.QueryString
+	}
+	return u
+}
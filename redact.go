@@ -0,0 +1,99 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactionRule masks matches of a regular expression in recorded URLs,
+// e.g. e-mail addresses or IDs in paths.
+type RedactionRule struct {
+	// Preset selects a built-in rule: "email", "uuid" or "numericID".
+	// Pattern is used instead when no preset is given.
+	Preset  string `json:"preset,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	// Replacement may refer to capture groups as $1 or ${name}. Presets
+	// have their own default.
+	Replacement string `json:"replacement,omitempty"`
+	// Fields lists the fields the rule applies to: "path", "query" and
+	// "referer". All three when empty.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// redactionPresets are the built-in patterns and their replacements. Query
+// strings are matched in their encoded form, hence %40 for @.
+var redactionPresets = map[string][2]string{
+	"email":     {`[A-Za-z0-9._%+-]+(?:@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, "[email]"},
+	"uuid":      {`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`, ":uuid"},
+	"numericID": {`/[0-9]+\b`, "/:id"},
+}
+
+// redactionRule is a compiled RedactionRule.
+type redactionRule struct {
+	re          *regexp.Regexp
+	replacement string
+	path        bool
+	query       bool
+	referer     bool
+}
+
+// redactor applies the redaction rules to records.
+type redactor struct {
+	rules []redactionRule
+}
+
+// newRedactor compiles the rules.
+func newRedactor(rules []RedactionRule) (*redactor, error) {
+	r := &redactor{}
+	for i, rule := range rules {
+		pattern, replacement := rule.Pattern, "[redacted]"
+		if rule.Preset != "" {
+			preset, ok := redactionPresets[rule.Preset]
+			if !ok {
+				return nil, fmt.Errorf("unsupported redact[%d].preset %q", i, rule.Preset)
+			}
+			pattern, replacement = preset[0], preset[1]
+		}
+		if rule.Replacement != "" {
+			replacement = rule.Replacement
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact[%d].pattern: %v", i, err)
+		}
+
+		compiled := redactionRule{re: re, replacement: replacement}
+		if len(rule.Fields) == 0 {
+			compiled.path, compiled.query, compiled.referer = true, true, true
+		}
+		for _, field := range rule.Fields {
+			switch field {
+			case "path":
+				compiled.path = true
+			case "query":
+				compiled.query = true
+			case "referer":
+				compiled.referer = true
+			default:
+				return nil, fmt.Errorf("unsupported redact[%d].fields entry %q", i, field)
+			}
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// redact applies the rules to the URL fields of data.
+func (r *redactor) redact(data *RequestData) {
+	for _, rule := range r.rules {
+		if rule.path {
+			data.Path = rule.re.ReplaceAllString(data.Path, rule.replacement)
+		}
+		if rule.query && data.QueryString != "" {
+			data.QueryString = rule.re.ReplaceAllString(data.QueryString, rule.replacement)
+		}
+		if rule.referer && data.Referer != "" {
+			data.Referer = rule.re.ReplaceAllString(data.Referer, rule.replacement)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package traefik_analytics
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ReferrerConfig controls the classification of the Referer header.
+type ReferrerConfig struct {
+	Classify bool `json:"classify,omitempty"`
+	// InternalHosts are treated like the requested host, e.g. the other
+	// domains of the same site. Subdomains match too.
+	InternalHosts []string `json:"internalHosts,omitempty"`
+	// SearchEngines and SocialNetworks extend the built-in domain lists.
+	// A domain ending with a dot, such as "google.", matches any TLD.
+	SearchEngines  []string `json:"searchEngines,omitempty"`
+	SocialNetworks []string `json:"socialNetworks,omitempty"`
+}
+
+// Referrer types.
+const (
+	referrerDirect   = "direct"
+	referrerInternal = "internal"
+	referrerSearch   = "search"
+	referrerSocial   = "social"
+	referrerOther    = "other"
+)
+
+var knownSearchEngines = []string{
+	"google.", "bing.com", "search.yahoo.com", "duckduckgo.com", "yandex.",
+	"baidu.com", "ecosia.org", "search.brave.com", "startpage.com",
+	"qwant.com", "kagi.com", "naver.com", "seznam.cz",
+}
+
+var knownSocialNetworks = []string{
+	"facebook.com", "instagram.com", "t.co", "twitter.com", "x.com",
+	"linkedin.com", "lnkd.in", "reddit.com", "pinterest.", "youtube.com",
+	"tiktok.com", "news.ycombinator.com", "threads.net", "bsky.app",
+	"mastodon.social", "vk.com", "weibo.com",
+}
+
+// referrerEnricher sets the referring domain and its type.
+type referrerEnricher struct {
+	internal []string
+	search   []string
+	social   []string
+}
+
+func newReferrerEnricher(c ReferrerConfig) *referrerEnricher {
+	lower := func(domains []string) []string {
+		out := make([]string, 0, len(domains))
+		for _, d := range domains {
+			out = append(out, strings.ToLower(strings.TrimPrefix(d, "www.")))
+		}
+		return out
+	}
+	return &referrerEnricher{
+		internal: lower(c.InternalHosts),
+		search:   append(lower(c.SearchEngines), knownSearchEngines...),
+		social:   append(lower(c.SocialNetworks), knownSocialNetworks...),
+	}
+}
+
+func (e *referrerEnricher) enrich(data *RequestData) {
+	if data.Referer == "" {
+		data.ReferrerType = referrerDirect
+		return
+	}
+	u, err := url.Parse(data.Referer)
+	if err != nil || u.Host == "" {
+		data.ReferrerType = referrerOther
+		return
+	}
+	domain := normalizeHost(u.Host)
+	data.ReferrerDomain = domain
+
+	switch {
+	case domain == normalizeHost(data.Host) || matchesDomain(domain, e.internal):
+		data.ReferrerType = referrerInternal
+	case matchesDomain(domain, e.search):
+		data.ReferrerType = referrerSearch
+	case matchesDomain(domain, e.social):
+		data.ReferrerType = referrerSocial
+	default:
+		data.ReferrerType = referrerOther
+	}
+}
+
+// normalizeHost lowercases host and strips the port and a leading "www.".
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of
+// one. Domains ending with a dot match any TLD, but not subdomains.
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if strings.HasSuffix(d, ".") {
+			if strings.HasPrefix(host, d) {
+				return true
+			}
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
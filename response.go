@@ -0,0 +1,199 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// responseRecorder wraps the ResponseWriter handed to the next handler and
+// records what the upstream sent back.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	// headerTime is when the final response header was written, i.e. when
+	// the first byte went out to the client.
+	headerTime time.Time
+
+	// upstreamHeaders name response headers that identify the upstream
+	// server. The first one present is kept in upstream and, with
+	// stripUpstream, removed before the response reaches the client.
+	upstreamHeaders []string
+	stripUpstream   bool
+	upstream        string
+
+	// encoding is the Content-Encoding of the response. With
+	// measureUncompressed, encoded bodies are decoded by decoder to learn
+	// their uncompressed size.
+	encoding            string
+	measureUncompressed bool
+	decoder             *sizeDecoder
+
+	// tunnel is set once the connection has been hijacked, e.g. for a
+	// WebSocket upgrade.
+	tunnel *tunnelConn
+}
+
+// WriteHeader records the first final status code. Informational responses
+// such as 103 Early Hints precede the final one and are passed through
+// without being recorded.
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		r.setStatus(code)
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write implies a 200 status if no header was written yet and counts the
+// body bytes sent to the client.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.setStatus(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	if r.decoder != nil {
+		r.decoder.Write(b[:n])
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher so streaming responses keep working.
+func (r *responseRecorder) Flush() {
+	if r.status == 0 {
+		r.setStatus(http.StatusOK)
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so protocol upgrades keep working. The
+// returned connection and buffers count the bytes that pass through the
+// tunnel, including those the server had already read ahead.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", r.ResponseWriter)
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.status == 0 {
+		r.setStatus(http.StatusSwitchingProtocols)
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r.tunnel = &tunnelConn{Conn: conn, closed: make(chan struct{})}
+	buffered, _ := brw.Peek(brw.Reader.Buffered())
+	r.tunnel.received.Add(int64(len(buffered)))
+	reader := io.MultiReader(bytes.NewReader(bytes.Clone(buffered)), r.tunnel)
+	brw = bufio.NewReadWriter(bufio.NewReader(reader), bufio.NewWriter(r.tunnel))
+	return r.tunnel, brw, nil
+}
+
+// setStatus records the final status and when it was sent. It is called
+// before the header is passed on, so upstream headers can still be removed.
+func (r *responseRecorder) setStatus(code int) {
+	r.status = code
+	r.headerTime = time.Now()
+
+	header := r.ResponseWriter.Header()
+	r.encoding = header.Get("Content-Encoding")
+	if r.measureUncompressed && r.encoding != "" {
+		r.decoder = newSizeDecoder(r.encoding)
+	}
+
+	for _, name := range r.upstreamHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		if r.upstream == "" {
+			r.upstream = value
+		}
+		if r.stripUpstream {
+			header.Del(name)
+		}
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statusCode returns the recorded status. A handler that writes nothing
+// results in an implicit 200.
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// timeToFirstByte returns the time from start until the response header
+// was written. If the handler wrote nothing, the response goes out when it
+// returns, which is at end.
+func (r *responseRecorder) timeToFirstByte(start, end time.Time) time.Duration {
+	if r.headerTime.IsZero() {
+		return end.Sub(start)
+	}
+	return r.headerTime.Sub(start)
+}
+
+// uncompressedSize returns the uncompressed size of the response body, or
+// nil if it cannot be determined. It must be called once the handler has
+// returned.
+func (r *responseRecorder) uncompressedSize() *int64 {
+	if r.encoding == "" || strings.EqualFold(r.encoding, "identity") {
+		size := r.size
+		return &size
+	}
+	if r.decoder == nil {
+		return nil
+	}
+	size, ok := r.decoder.finish()
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// tunnelConn is a hijacked client connection. It counts the bytes read
+// from and written to the client and signals when it is closed.
+type tunnelConn struct {
+	net.Conn
+	received  atomic.Int64
+	sent      atomic.Int64
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+func (c *tunnelConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
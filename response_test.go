@@ -0,0 +1,70 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hijackableRecorder hands out one end of a pipe, with bytes the server
+// already read ahead in the buffer.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn      net.Conn
+	readAhead string
+}
+
+func (h *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r := bufio.NewReader(io.MultiReader(strings.NewReader(h.readAhead), h.conn))
+	r.Peek(len(h.readAhead))
+	return h.conn, bufio.NewReadWriter(r, bufio.NewWriter(h.conn)), nil
+}
+
+func TestHijackCountsBufferedBytes(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	rw := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server, readAhead: "early"}
+	recorder := acquireResponseRecorder(rw)
+	defer releaseResponseRecorder(recorder)
+
+	conn, brw, err := recorder.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		client.Write([]byte(" late"))
+		io.ReadAll(client)
+	}()
+	got := make([]byte, len("early late"))
+	if _, err := io.ReadFull(brw, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "early late" {
+		t.Errorf("read %q, want %q", got, "early late")
+	}
+	brw.WriteString("reply")
+	if err := brw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	tunnel := recorder.tunnel
+	select {
+	case <-tunnel.closed:
+	default:
+		t.Error("closing the connection did not close the tunnel")
+	}
+	if got := tunnel.received.Load(); got != int64(len("early late")) {
+		t.Errorf("counted %d bytes received, want %d", got, len("early late"))
+	}
+	if got := tunnel.sent.Load(); got != int64(len("reply")) {
+		t.Errorf("counted %d bytes sent, want %d", got, len("reply"))
+	}
+	if recorder.statusCode() != http.StatusSwitchingProtocols {
+		t.Errorf("status %d, want %d", recorder.statusCode(), http.StatusSwitchingProtocols)
+	}
+}
@@ -0,0 +1,73 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Units of the response_time column of the SQL backends.
+const (
+	responseTimeNanoseconds  = "ns"
+	responseTimeMilliseconds = "ms"
+	responseTimeInterval     = "interval"
+)
+
+// responseTimeTypes are the column types of response_time for each driver
+// and unit.
+var responseTimeTypes = map[string]map[string]string{
+	"postgres": {responseTimeInterval: "INTERVAL", responseTimeMilliseconds: "DOUBLE PRECISION", responseTimeNanoseconds: "BIGINT"},
+	"mysql":    {responseTimeNanoseconds: "BIGINT", responseTimeMilliseconds: "DOUBLE"},
+	"sqlite":   {responseTimeNanoseconds: "INTEGER", responseTimeMilliseconds: "REAL"},
+}
+
+// defaultResponseTimeUnits are the units matching the schema files.
+var defaultResponseTimeUnits = map[string]string{
+	"postgres": responseTimeInterval,
+	"mysql":    responseTimeNanoseconds,
+	"sqlite":   responseTimeNanoseconds,
+}
+
+func validateResponseTimeUnit(unit string) error {
+	switch unit {
+	case "", responseTimeNanoseconds, responseTimeMilliseconds, responseTimeInterval:
+		return nil
+	}
+	return fmt.Errorf("invalid responseTimeUnit %q", unit)
+}
+
+// responseTimeDialect returns dialect with the response_time column created
+// with the type of unit, and the column binding values in unit. An empty
+// unit keeps the type of the schema file.
+func responseTimeDialect(d sqlDialect, unit string) (sqlDialect, sqlColumn, error) {
+	defaultUnit := defaultResponseTimeUnits[d.driver]
+	if unit == "" {
+		unit = defaultUnit
+	}
+	columnType, ok := responseTimeTypes[d.driver][unit]
+	if !ok {
+		return d, sqlColumn{}, fmt.Errorf("responseTimeUnit %q is not supported by %s", unit, d.driver)
+	}
+	if unit != defaultUnit {
+		defaultType := responseTimeTypes[d.driver][defaultUnit]
+		d = d.withCreateTable(strings.Replace(d.createTable,
+			"  response_time "+defaultType+" ", "  response_time "+columnType+" ", 1))
+	}
+	return d, responseTimeColumn(unit), nil
+}
+
+// responseTimeColumn binds the response time in unit.
+func responseTimeColumn(unit string) sqlColumn {
+	switch unit {
+	case responseTimeMilliseconds:
+		return sqlColumn{"response_time", func(d *RequestData) interface{} {
+			return float64(d.ResponseTime) / float64(time.Millisecond)
+		}}
+	case responseTimeInterval:
+		return sqlColumn{"response_time", func(d *RequestData) interface{} {
+			return strconv.FormatInt(d.ResponseTime.Microseconds(), 10) + " microseconds"
+		}}
+	}
+	return sqlColumn{"response_time", func(d *RequestData) interface{} { return int64(d.ResponseTime) }}
+}
@@ -0,0 +1,57 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// sampler decides which requests are recorded when only a fraction of the
+// traffic is needed.
+type sampler struct {
+	rate       float64
+	byIP       bool
+	slowerThan time.Duration
+}
+
+func newSampler(config *Config) (*sampler, error) {
+	if config.SamplingRate < 0 || config.SamplingRate > 1 || math.IsNaN(config.SamplingRate) {
+		return nil, fmt.Errorf("invalid samplingRate %v, must be between 0 and 1", config.SamplingRate)
+	}
+	s := &sampler{rate: config.SamplingRate, byIP: config.SampleByClientIP}
+	if config.KeepSlowerThan != "" {
+		slowerThan, err := time.ParseDuration(config.KeepSlowerThan)
+		if err != nil || slowerThan <= 0 {
+			return nil, fmt.Errorf("invalid keepSlowerThan %q", config.KeepSlowerThan)
+		}
+		s.slowerThan = slowerThan
+	}
+	return s, nil
+}
+
+// sample reports whether a request from the client address ip is recorded.
+// By client IP, an address is always either sampled in or out, so that the
+// recorded requests of a visitor are complete.
+func (s *sampler) sample(ip string) bool {
+	switch {
+	case s.rate >= 1:
+		return true
+	case s.rate <= 0:
+		return false
+	case s.byIP:
+		h := fnv.New64a()
+		h.Write([]byte(ip))
+		return float64(h.Sum64()) < s.rate*math.MaxUint64
+	default:
+		return rand.Float64() < s.rate
+	}
+}
+
+// keep reports whether a request that was not sampled is recorded anyway,
+// as server errors and slow requests are the most interesting ones.
+func (s *sampler) keep(status int, responseTime time.Duration) bool {
+	return status >= http.StatusInternalServerError || (s.slowerThan > 0 && responseTime >= s.slowerThan)
+}
@@ -0,0 +1,72 @@
+CREATE TABLE request_logs (
+  ip STRING,
+  user_agent STRING,
+  path STRING NOT NULL,
+  request_time TIMESTAMP NOT NULL,
+  method STRING NOT NULL,
+  protocol STRING NOT NULL,
+  host STRING NOT NULL,
+  accept_language STRING,
+  referer STRING,
+  content_type STRING,
+  content_length INT64,
+  response_time INT64 NOT NULL,
+  response_content_type STRING,
+  status INT64 NOT NULL,
+  response_size INT64 NOT NULL,
+  router STRING,
+  service STRING,
+  entrypoint STRING,
+  query_string STRING,
+  headers JSON,
+  cookies JSON,
+  trace_id STRING,
+  request_id STRING,
+  upgrade STRING,
+  tunnel_bytes_received INT64 NOT NULL,
+  tunnel_bytes_sent INT64 NOT NULL,
+  grpc_service STRING,
+  grpc_method STRING,
+  grpc_status INT64,
+  client_port INT64,
+  connection_reused BOOL NOT NULL,
+  response_headers JSON,
+  ttfb INT64 NOT NULL,
+  upstream STRING,
+  content_encoding STRING,
+  uncompressed_size INT64,
+  country STRING,
+  region STRING,
+  city STRING,
+  asn INT64,
+  as_org STRING,
+  browser STRING,
+  browser_version STRING,
+  os STRING,
+  os_version STRING,
+  device_type STRING,
+  is_bot BOOL NOT NULL,
+  bot_name STRING,
+  rdns STRING,
+  utm_source STRING,
+  utm_medium STRING,
+  utm_campaign STRING,
+  utm_term STRING,
+  utm_content STRING,
+  referrer_domain STRING,
+  referrer_type STRING,
+  language STRING,
+  is_datacenter BOOL NOT NULL,
+  is_vpn BOOL NOT NULL,
+  is_tor BOOL NOT NULL,
+  visitor_id STRING,
+  session_id STRING,
+  tls_version STRING,
+  tls_cipher STRING,
+  tls_fingerprint STRING,
+  raw_path STRING,
+  extra JSON,
+  record_id STRING
+)
+PARTITION BY DATE(request_time)
+CLUSTER BY host;
@@ -0,0 +1,75 @@
+CREATE TABLE request_logs (
+  ip String,
+  user_agent String,
+  path String,
+  request_time DateTime64(6, 'UTC'),
+  method LowCardinality(String),
+  protocol LowCardinality(String),
+  host LowCardinality(String),
+  accept_language String,
+  referer String,
+  content_type String,
+  content_length Int64,
+  response_time Int64,
+  response_content_type String,
+  status UInt16,
+  response_size UInt64,
+  router LowCardinality(String),
+  service LowCardinality(String),
+  entrypoint LowCardinality(String),
+  query_string String,
+  headers Map(String, String),
+  cookies Map(String, String),
+  trace_id String,
+  request_id String,
+  upgrade LowCardinality(String),
+  tunnel_bytes_received UInt64,
+  tunnel_bytes_sent UInt64,
+  grpc_service LowCardinality(String),
+  grpc_method LowCardinality(String),
+  grpc_status Nullable(UInt8),
+  client_port UInt16,
+  connection_reused Bool,
+  response_headers Map(String, String),
+  ttfb Int64,
+  upstream LowCardinality(String),
+  content_encoding LowCardinality(String),
+  uncompressed_size Nullable(UInt64),
+  country LowCardinality(String),
+  region LowCardinality(String),
+  city LowCardinality(String),
+  asn UInt32,
+  as_org LowCardinality(String),
+  browser LowCardinality(String),
+  browser_version LowCardinality(String),
+  os LowCardinality(String),
+  os_version LowCardinality(String),
+  device_type LowCardinality(String),
+  is_bot Bool,
+  bot_name LowCardinality(String),
+  rdns String,
+  utm_source LowCardinality(String),
+  utm_medium LowCardinality(String),
+  utm_campaign LowCardinality(String),
+  utm_term LowCardinality(String),
+  utm_content LowCardinality(String),
+  referrer_domain LowCardinality(String),
+  referrer_type LowCardinality(String),
+  language LowCardinality(String),
+  is_datacenter Bool,
+  is_vpn Bool,
+  is_tor Bool,
+  visitor_id String,
+  session_id String,
+  tls_version LowCardinality(String),
+  tls_cipher LowCardinality(String),
+  tls_fingerprint String,
+  raw_path String,
+  extra Map(String, String),
+  record_id String
+)
+-- Records written again, e.g. when a batch is retried or the write-ahead
+-- log is replayed, share the sorting key and are merged away.
+ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(request_time)
+ORDER BY (host, request_time, record_id);
@@ -0,0 +1,77 @@
+-- With autoMigrate, the plugin creates this schema itself; keep it in sync
+-- with migrate.go.
+CREATE TABLE request_logs (
+  id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  ip VARCHAR(64),
+  user_agent TEXT,
+  path VARCHAR(2048) NOT NULL,
+  request_time DATETIME(6) NOT NULL,
+  method VARCHAR(10) NOT NULL,
+  protocol VARCHAR(10) NOT NULL,
+  host VARCHAR(255) NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length BIGINT,
+  response_time BIGINT NOT NULL, -- nanoseconds; DOUBLE with responseTimeUnit "ms"
+  response_content_type TEXT,
+  status SMALLINT NOT NULL,
+  response_size BIGINT NOT NULL,
+  router VARCHAR(255),
+  service VARCHAR(255),
+  entrypoint VARCHAR(255),
+  query_string TEXT,
+  headers JSON,
+  cookies JSON,
+  trace_id VARCHAR(128),
+  request_id VARCHAR(128),
+  upgrade VARCHAR(32),
+  tunnel_bytes_received BIGINT NOT NULL,
+  tunnel_bytes_sent BIGINT NOT NULL,
+  grpc_service VARCHAR(255),
+  grpc_method VARCHAR(255),
+  grpc_status SMALLINT,
+  client_port INT,
+  connection_reused BOOLEAN NOT NULL,
+  response_headers JSON,
+  ttfb BIGINT NOT NULL,
+  upstream VARCHAR(255),
+  content_encoding VARCHAR(32),
+  uncompressed_size BIGINT,
+  country CHAR(2),
+  region VARCHAR(255),
+  city VARCHAR(255),
+  asn INT UNSIGNED,
+  as_org VARCHAR(255),
+  browser VARCHAR(64),
+  browser_version VARCHAR(64),
+  os VARCHAR(64),
+  os_version VARCHAR(64),
+  device_type VARCHAR(16),
+  is_bot BOOLEAN NOT NULL,
+  bot_name VARCHAR(64),
+  rdns VARCHAR(255),
+  utm_source VARCHAR(255),
+  utm_medium VARCHAR(255),
+  utm_campaign VARCHAR(255),
+  utm_term VARCHAR(255),
+  utm_content VARCHAR(255),
+  referrer_domain VARCHAR(255),
+  referrer_type VARCHAR(16),
+  language VARCHAR(35),
+  is_datacenter BOOLEAN NOT NULL,
+  is_vpn BOOLEAN NOT NULL,
+  is_tor BOOLEAN NOT NULL,
+  visitor_id CHAR(32),
+  session_id CHAR(36),
+  tls_version VARCHAR(16),
+  tls_cipher VARCHAR(64),
+  tls_fingerprint VARCHAR(256),
+  raw_path TEXT,
+  extra JSON,
+  record_id CHAR(36),
+  INDEX idx_request_logs_request_time (request_time),
+  INDEX idx_request_logs_path (path(255)),
+  INDEX idx_request_logs_ip (ip),
+  UNIQUE INDEX idx_request_logs_record_id (record_id)
+);
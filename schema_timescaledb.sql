@@ -0,0 +1,81 @@
+-- Hypertables require every unique index to include the partitioning column,
+-- so unlike schema.sql this table has no serial primary key. The plugin turns
+-- it into a hypertable on startup.
+-- With autoMigrate, the plugin creates this schema itself; keep it in sync
+-- with migrate.go.
+CREATE EXTENSION IF NOT EXISTS timescaledb;
+
+CREATE TABLE request_logs (
+  ip INET,
+  user_agent TEXT,
+  path TEXT NOT NULL,
+  request_time TIMESTAMP WITH TIME ZONE NOT NULL,
+  method VARCHAR(10) NOT NULL,
+  protocol VARCHAR(10) NOT NULL,
+  host TEXT NOT NULL,
+  accept_language TEXT,
+  referer TEXT,
+  content_type TEXT,
+  content_length BIGINT,
+  response_time INTERVAL NOT NULL, -- DOUBLE PRECISION with responseTimeUnit "ms", BIGINT with "ns"
+  response_content_type TEXT,
+  status SMALLINT NOT NULL,
+  response_size BIGINT NOT NULL,
+  router TEXT,
+  service TEXT,
+  entrypoint TEXT,
+  query_string TEXT,
+  headers JSONB,
+  cookies JSONB,
+  trace_id TEXT,
+  request_id TEXT,
+  upgrade TEXT,
+  tunnel_bytes_received BIGINT NOT NULL,
+  tunnel_bytes_sent BIGINT NOT NULL,
+  grpc_service TEXT,
+  grpc_method TEXT,
+  grpc_status SMALLINT,
+  client_port INTEGER,
+  connection_reused BOOLEAN NOT NULL,
+  response_headers JSONB,
+  ttfb BIGINT NOT NULL,
+  upstream TEXT,
+  content_encoding TEXT,
+  uncompressed_size BIGINT,
+  country CHAR(2),
+  region TEXT,
+  city TEXT,
+  asn BIGINT,
+  as_org TEXT,
+  browser TEXT,
+  browser_version TEXT,
+  os TEXT,
+  os_version TEXT,
+  device_type TEXT,
+  is_bot BOOLEAN NOT NULL,
+  bot_name TEXT,
+  rdns TEXT,
+  utm_source TEXT,
+  utm_medium TEXT,
+  utm_campaign TEXT,
+  utm_term TEXT,
+  utm_content TEXT,
+  referrer_domain TEXT,
+  referrer_type TEXT,
+  language TEXT,
+  is_datacenter BOOLEAN NOT NULL,
+  is_vpn BOOLEAN NOT NULL,
+  is_tor BOOLEAN NOT NULL,
+  visitor_id TEXT,
+  session_id TEXT,
+  tls_version TEXT,
+  tls_cipher TEXT,
+  tls_fingerprint TEXT,
+  raw_path TEXT,
+  extra JSONB,
+  record_id UUID
+);
+
+CREATE INDEX idx_request_logs_path ON request_logs (path, request_time DESC);
+CREATE INDEX idx_request_logs_ip ON request_logs (ip, request_time DESC);
+CREATE UNIQUE INDEX idx_request_logs_record_id ON request_logs (record_id, request_time);
@@ -0,0 +1,182 @@
+package traefik_analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Sharding keys.
+const (
+	shardByHost       = "host"
+	shardByVisitor    = "visitor"
+	shardByRoundRobin = "roundRobin"
+)
+
+// shardableSinks are the backends that connect with DatabaseDSN.
+var shardableSinks = map[string]bool{
+	"postgres":    true,
+	"mysql":       true,
+	"timescaledb": true,
+	"clickhouse":  true,
+}
+
+// ShardingConfig spreads writes across several databases of the postgres,
+// mysql, timescaledb or clickhouse backend, each with the same schema.
+type ShardingConfig struct {
+	// DSNs replace DatabaseDSN when set.
+	DSNs []string `json:"dsns,omitempty"`
+	// Key is "host" or "visitor", which keep the records of a host or
+	// visitor together in one database, or "roundRobin", which writes
+	// successive batches to the databases in turn. Records without a
+	// visitor ID are sharded by client address.
+	Key string `json:"key,omitempty"`
+}
+
+func validateShardingConfig(c ShardingConfig) error {
+	if len(c.DSNs) == 0 {
+		return nil
+	}
+	switch c.Key {
+	case shardByHost, shardByVisitor, shardByRoundRobin:
+	default:
+		return fmt.Errorf("invalid shards.key %q", c.Key)
+	}
+	for i, dsn := range c.DSNs {
+		if dsn == "" {
+			return fmt.Errorf("invalid shards.dsns[%d]: DSN is empty", i)
+		}
+	}
+	return nil
+}
+
+// sharded reports whether writes to the named backend are sharded.
+func sharded(name string, config *Config) bool {
+	return len(config.Shards.DSNs) > 0 && shardableSinks[name]
+}
+
+// shardConfigs returns a copy of config for each shard.
+func shardConfigs(config *Config) []*Config {
+	configs := make([]*Config, len(config.Shards.DSNs))
+	for i, dsn := range config.Shards.DSNs {
+		shard := *config
+		shard.DatabaseDSN = dsn
+		configs[i] = &shard
+	}
+	return configs
+}
+
+// shardedSink splits batches across one sink per shard.
+type shardedSink struct {
+	key    string
+	shards []Sink
+	next   atomic.Uint64
+}
+
+func newShardedSink(factory SinkFactory, config *Config) (Sink, error) {
+	s := &shardedSink{key: config.Shards.Key}
+	for i, shardConfig := range shardConfigs(config) {
+		shard, err := factory(shardConfig)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open shard %d: %v", i, err)
+		}
+		s.shards = append(s.shards, shard)
+	}
+	return s, nil
+}
+
+// shard returns the index of the shard that stores data.
+func (s *shardedSink) shard(data *RequestData) int {
+	key := data.Host
+	if s.key == shardByVisitor {
+		key = data.VisitorID
+		if key == "" {
+			key = data.IP
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// Write writes the records of each shard to it. If a shard fails, the
+// error is returned and the whole batch may be written again, so shards
+// that succeeded can see duplicates. Records rejected by shards that were
+// reached are reported together.
+func (s *shardedSink) Write(ctx context.Context, batch []RequestData) error {
+	if s.key == shardByRoundRobin {
+		i := int((s.next.Add(1) - 1) % uint64(len(s.shards)))
+		return s.shards[i].Write(ctx, batch)
+	}
+
+	batches := make([][]RequestData, len(s.shards))
+	for i := range batch {
+		shard := s.shard(&batch[i])
+		batches[shard] = append(batches[shard], batch[i])
+	}
+	var rejected []rejectedRecord
+	var partialErr error
+	for i, shardBatch := range batches {
+		if len(shardBatch) == 0 {
+			continue
+		}
+		err := s.shards[i].Write(ctx, shardBatch)
+		var partial *partialWriteError
+		if errors.As(err, &partial) {
+			rejected = append(rejected, partial.rejected...)
+			partialErr = fmt.Errorf("shard %d: %v", i, err)
+		} else if err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	if partialErr != nil {
+		return &partialWriteError{err: partialErr, rejected: rejected}
+	}
+	return nil
+}
+
+// writeDeadLetters stores each dead letter in the dead-letter table of the
+// shard its record belongs to; round-robin letters go to the first shard.
+func (s *shardedSink) writeDeadLetters(ctx context.Context, letters []deadLetter) error {
+	grouped := make([][]deadLetter, len(s.shards))
+	for _, letter := range letters {
+		shard := 0
+		if s.key != shardByRoundRobin {
+			shard = s.shard(&letter.Record)
+		}
+		grouped[shard] = append(grouped[shard], letter)
+	}
+	for i, shardLetters := range grouped {
+		w, ok := s.shards[i].(deadLetterWriter)
+		if len(shardLetters) == 0 || !ok {
+			continue
+		}
+		if err := w.writeDeadLetters(ctx, shardLetters); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// maintain runs the upkeep of every shard.
+func (s *shardedSink) maintain(ctx context.Context) {
+	for _, shard := range s.shards {
+		if m, ok := shard.(maintainer); ok {
+			m.maintain(ctx)
+		}
+	}
+}
+
+// Close closes all shards.
+func (s *shardedSink) Close() error {
+	var firstErr error
+	for _, shard := range s.shards {
+		if err := shard.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
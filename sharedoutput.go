@@ -0,0 +1,110 @@
+package traefik_analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// dsnSinks are the backends whose outputs can be shared between middleware
+// instances, keyed by their database.
+var dsnSinks = map[string]bool{
+	"postgres":    true,
+	"mysql":       true,
+	"sqlite":      true,
+	"timescaledb": true,
+	"clickhouse":  true,
+}
+
+// sharedOutput is an output used by several middleware instances.
+type sharedOutput struct {
+	out    *output
+	refs   int
+	cancel context.CancelFunc
+}
+
+// sharedOutputs holds the shared outputs keyed by backend and DSN.
+var sharedOutputs = struct {
+	sync.Mutex
+	outputs map[string]*sharedOutput
+}{outputs: map[string]*sharedOutput{}}
+
+// outputSettings returns the settings of config that the output and its
+// sink use, leaving out those applied when records are captured.
+func outputSettings(config *Config) Config {
+	return Config{
+		DatabaseDSN:      config.DatabaseDSN,
+		AutoMigrate:      config.AutoMigrate,
+		TableName:        config.TableName,
+		SchemaName:       config.SchemaName,
+		ResponseTimeUnit: config.ResponseTimeUnit,
+		Columns:          config.Columns,
+		Shards:           config.Shards,
+		HostRouting:      config.HostRouting,
+		Partitioning:     config.Partitioning,
+		SQLPool:          config.SQLPool,
+		RetentionDays:    config.RetentionDays,
+		BatchSize:        config.BatchSize,
+		FlushInterval:    config.FlushInterval,
+		WorkerCount:      config.WorkerCount,
+		ShutdownTimeout:  config.ShutdownTimeout,
+		Spill:            config.Spill,
+		WAL:              config.WAL,
+		QueueSize:        config.QueueSize,
+		QueueMaxBytes:    config.QueueMaxBytes,
+		Backpressure:     config.Backpressure,
+		CircuitBreaker:   config.CircuitBreaker,
+		RetryBackoff:     config.RetryBackoff,
+		DeadLetter:       config.DeadLetter,
+		TimescaleDB:      config.TimescaleDB,
+	}
+}
+
+// acquireSharedOutput returns the output writing to the tables of
+// config, creating and starting it if no other middleware instance with
+// the same output settings uses it yet. Records keep the labels of the
+// instance that captured them, such as its router and service. The
+// instance gives the output up once ctx is done, and the output shuts down
+// once no instance uses it anymore, so it lives on across a configuration
+// reload that keeps its settings.
+func acquireSharedOutput(ctx context.Context, storageType string, config *Config) (*output, error) {
+	settings, err := json.Marshal(outputSettings(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode output settings: %v", err)
+	}
+	key := storageType + "\x00" + string(settings)
+	sharedOutputs.Lock()
+	defer sharedOutputs.Unlock()
+
+	shared, ok := sharedOutputs.outputs[key]
+	if !ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		name := fmt.Sprintf("shared-%08x", h.Sum32())
+
+		outputCtx, cancel := context.WithCancel(context.Background())
+		out, err := newOutput(outputCtx, name, storageType, config)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		out.start()
+		shared = &sharedOutput{out: out, cancel: cancel}
+		sharedOutputs.outputs[key] = shared
+	}
+	shared.refs++
+
+	go func() {
+		<-ctx.Done()
+		sharedOutputs.Lock()
+		defer sharedOutputs.Unlock()
+		shared.refs--
+		if shared.refs == 0 {
+			shared.cancel()
+			delete(sharedOutputs.outputs, key)
+		}
+	}()
+	return shared.out, nil
+}
@@ -0,0 +1,47 @@
+package traefik_analytics
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireSharedOutput(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "analytics.db")
+	newConfig := func(change func(*Config)) *Config {
+		config := CreateConfig()
+		config.DatabaseDSN = dsn
+		config.SharedWriter = true
+		change(config)
+		return config
+	}
+	tests := []struct {
+		name   string
+		change func(*Config)
+		shared bool
+	}{
+		{"same settings", func(*Config) {}, true},
+		{"other router label", func(c *Config) { c.Router = "other" }, true},
+		{"other capture settings", func(c *Config) { c.SamplingRate = 0.5 }, true},
+		{"other batch size", func(c *Config) { c.BatchSize = 7 }, false},
+		{"other retry backoff", func(c *Config) { c.RetryBackoff.MaxInterval = "7m" }, false},
+		{"other table", func(c *Config) { c.TableName = "other_logs" }, false},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, err := acquireSharedOutput(ctx, "sqlite", newConfig(func(*Config) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := acquireSharedOutput(ctx, "sqlite", newConfig(tt.change))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (out == first) != tt.shared {
+				t.Errorf("shared %v, want %v", out == first, tt.shared)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sink persists batches of request records to a storage backend.
+type Sink interface {
+	// Write stores the given records. Implementations must not retain the
+	// slice after returning.
+	Write(ctx context.Context, batch []RequestData) error
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// partialWriteError is returned by Write when the backend was reached but
+// rejected some records of the batch, e.g. for a constraint violation. The
+// batch must not be written again, e.g. from the spill file.
+type partialWriteError struct {
+	err error
+	// rejected holds the records that were not stored.
+	rejected []rejectedRecord
+}
+
+// rejectedRecord is a record refused by a backend.
+type rejectedRecord struct {
+	data RequestData
+	err  error
+}
+
+func (e *partialWriteError) Error() string { return e.err.Error() }
+
+func (e *partialWriteError) Unwrap() error { return e.err }
+
+// SinkFactory creates a Sink from the plugin configuration.
+type SinkFactory func(config *Config) (Sink, error)
+
+// sinkRegistration describes a registered storage backend.
+type sinkRegistration struct {
+	factory SinkFactory
+	// validate checks the configuration without connecting anywhere, so
+	// that New can reject obvious mistakes up front. It may be nil.
+	validate func(config *Config) error
+}
+
+// sinkRegistry holds the registered storage backends, keyed by name.
+var sinkRegistry = map[string]sinkRegistration{}
+
+// RegisterSink makes a storage backend available under the given name.
+// It is intended to be called from init functions and panics if the name
+// is already taken.
+func RegisterSink(name string, factory SinkFactory) {
+	registerSink(name, factory, nil)
+}
+
+// registerSink is RegisterSink with an optional configuration check.
+func registerSink(name string, factory SinkFactory, validate func(config *Config) error) {
+	if factory == nil {
+		panic("traefik_analytics: RegisterSink factory is nil")
+	}
+	if _, dup := sinkRegistry[name]; dup {
+		panic("traefik_analytics: RegisterSink called twice for sink " + name)
+	}
+	sinkRegistry[name] = sinkRegistration{factory: factory, validate: validate}
+}
+
+// lookupSink returns the backend registered under the given name.
+func lookupSink(name string) (sinkRegistration, error) {
+	reg, ok := sinkRegistry[name]
+	if !ok {
+		return sinkRegistration{}, fmt.Errorf("unknown storage type %q (available: %s)", name, strings.Join(registeredSinks(), ", "))
+	}
+	return reg, nil
+}
+
+// validateSink checks that the named backend exists and accepts the configuration.
+func validateSink(name string, config *Config) error {
+	reg, err := lookupSink(name)
+	if err != nil {
+		return err
+	}
+	if reg.validate == nil {
+		return nil
+	}
+	if sharded(name, config) {
+		for i, shardConfig := range shardConfigs(config) {
+			if err := reg.validate(shardConfig); err != nil {
+				return fmt.Errorf("invalid shards.dsns[%d]: %v", i, err)
+			}
+		}
+		return nil
+	}
+	if hostRouted(name, config) {
+		for host, hostConfig := range hostConfigs(config) {
+			if err := reg.validate(hostConfig); err != nil {
+				return fmt.Errorf("invalid hostRouting for host %s: %v", host, err)
+			}
+		}
+	}
+	return reg.validate(config)
+}
+
+// newSink creates the storage backend registered under the given name.
+func newSink(name string, config *Config) (Sink, error) {
+	reg, err := lookupSink(name)
+	if err != nil {
+		return nil, err
+	}
+	if sharded(name, config) {
+		return newShardedSink(reg.factory, config)
+	}
+	if hostRouted(name, config) {
+		return newHostRoutedSink(reg.factory, config)
+	}
+	return reg.factory(config)
+}
+
+// registeredSinks returns the sorted names of all registered storage backends.
+func registeredSinks() []string {
+	names := make([]string, 0, len(sinkRegistry))
+	for name := range sinkRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requireDSN is the configuration check shared by DSN-based backends.
+func requireDSN(config *Config) error {
+	if config.DatabaseDSN == "" {
+		return fmt.Errorf("DatabaseDSN is required")
+	}
+	return nil
+}
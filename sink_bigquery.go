@@ -0,0 +1,149 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	registerSink("bigquery", newBigQuerySink, validateBigQueryConfig)
+}
+
+// BigQueryConfig holds the settings of the BigQuery sink.
+type BigQueryConfig struct {
+	ProjectID string `json:"projectID,omitempty"`
+	Dataset   string `json:"dataset,omitempty"`
+	Table     string `json:"table,omitempty"`
+	// CredentialsFile is an optional service account key file. By default
+	// credentials are loaded from the environment (Application Default
+	// Credentials), including GKE Workload Identity.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+}
+
+// validateBigQueryConfig checks the BigQuery settings.
+func validateBigQueryConfig(config *Config) error {
+	c := config.BigQuery
+	switch {
+	case c.ProjectID == "":
+		return fmt.Errorf("bigquery.projectID is required")
+	case c.Dataset == "":
+		return fmt.Errorf("bigquery.dataset is required")
+	case c.Table == "":
+		return fmt.Errorf("bigquery.table is required")
+	}
+	return nil
+}
+
+// bigQuerySink streams request records into a BigQuery table.
+//
+// The Storage Write API is only available over gRPC, which cannot be used
+// from a Traefik plugin, so rows are streamed with the tabledata.insertAll
+// REST method instead. Every row carries its record ID as insertId, which
+// lets BigQuery drop duplicates of retried requests on a best-effort basis.
+type bigQuerySink struct {
+	client   *http.Client
+	tokens   *googleTokenSource
+	endpoint string
+}
+
+// newBigQuerySink loads the credentials. No connection is made until the
+// first write.
+func newBigQuerySink(config *Config) (Sink, error) {
+	err := validateBigQueryConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := newGoogleTokenSource(config.BigQuery.CredentialsFile, []string{
+		"https://www.googleapis.com/auth/bigquery.insertdata",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := config.BigQuery
+	return &bigQuerySink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		tokens: tokens,
+		endpoint: "https://bigquery.googleapis.com/bigquery/v2/projects/" + url.PathEscape(c.ProjectID) +
+			"/datasets/" + url.PathEscape(c.Dataset) + "/tables/" + url.PathEscape(c.Table) + "/insertAll",
+	}, nil
+}
+
+// bigQueryRow is a row of the insertAll request.
+type bigQueryRow struct {
+	InsertID string       `json:"insertId"`
+	JSON     *RequestData `json:"json"`
+}
+
+// Write streams the records with a single insertAll request.
+func (s *bigQuerySink) Write(ctx context.Context, batch []RequestData) error {
+	rows := make([]bigQueryRow, len(batch))
+	id := make([]byte, 16)
+	for i := range batch {
+		insertID := batch[i].RecordID
+		if insertID == "" {
+			rand.Read(id)
+			insertID = hex.EncodeToString(id)
+		}
+		rows[i] = bigQueryRow{InsertID: insertID, JSON: &batch[i]}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"rows":                rows,
+		"ignoreUnknownValues": true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = s.tokens.authorize(req)
+	if err != nil {
+		return err
+	}
+
+	respBody, err := sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to insert data: %v", err)
+	}
+
+	var resp struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	err = json.Unmarshal(respBody, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to decode insert response: %v", err)
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		reason := "unknown error"
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("failed to insert %d of %d rows: %s", len(resp.InsertErrors), len(batch), reason)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *bigQuerySink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,147 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func init() {
+	registerSink("cassandra", newCassandraSink, validateCassandraConfig)
+}
+
+// CassandraConfig holds the settings of the Cassandra/ScyllaDB sink.
+type CassandraConfig struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Keyspace string   `json:"keyspace,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	// Consistency is a CQL consistency level such as "local_quorum".
+	Consistency string `json:"consistency,omitempty"`
+	// LocalDC routes queries to the given datacenter first, which keeps
+	// writes local in multi-datacenter clusters.
+	LocalDC string `json:"localDC,omitempty"`
+}
+
+// validateCassandraConfig checks the Cassandra settings.
+func validateCassandraConfig(config *Config) error {
+	c := config.Cassandra
+	if len(c.Hosts) == 0 {
+		return fmt.Errorf("cassandra.hosts is required")
+	}
+	if c.Keyspace == "" {
+		return fmt.Errorf("cassandra.keyspace is required")
+	}
+	var consistency gocql.Consistency
+	err := consistency.UnmarshalText([]byte(strings.ToUpper(c.Consistency)))
+	if err != nil {
+		return fmt.Errorf("invalid cassandra.consistency %q", c.Consistency)
+	}
+	return nil
+}
+
+// cassandraInsert writes a row of the request_logs table, which is
+// partitioned by host and day (see schema_cassandra.cql).
+const cassandraInsert = `INSERT INTO request_logs (
+    host, day, request_time, id, ip, user_agent, path, method, protocol,
+    accept_language, referer, content_type, content_length, response_time,
+    response_content_type, status, response_size, router, service, entrypoint,
+    query_string, headers, cookies, trace_id, request_id, upgrade,
+    tunnel_bytes_received, tunnel_bytes_sent, grpc_service, grpc_method, grpc_status,
+    client_port, connection_reused, response_headers, ttfb,
+    upstream, content_encoding, uncompressed_size, country, region, city,
+    asn, as_org, browser, browser_version, os, os_version, device_type,
+    is_bot, bot_name, rdns,
+    utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+    referrer_domain, referrer_type, language,
+    is_datacenter, is_vpn, is_tor, visitor_id, session_id,
+    tls_version, tls_cipher, tls_fingerprint, raw_path, extra
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// cassandraSink stores request records in Cassandra or ScyllaDB.
+type cassandraSink struct {
+	session *gocql.Session
+}
+
+// newCassandraSink connects to the cluster.
+func newCassandraSink(config *Config) (Sink, error) {
+	err := validateCassandraConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c := config.Cassandra
+
+	cluster := gocql.NewCluster(c.Hosts...)
+	cluster.Keyspace = c.Keyspace
+	cluster.Consistency = gocql.ParseConsistency(strings.ToUpper(c.Consistency))
+	cluster.Timeout = 10 * time.Second
+	if c.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: c.Username, Password: c.Password}
+	}
+	if c.LocalDC != "" {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.DCAwareRoundRobinPolicy(c.LocalDC))
+	} else {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	}
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cluster: %v", err)
+	}
+	return &cassandraSink{session: session}, nil
+}
+
+// cassandraPartition identifies a partition of request_logs.
+type cassandraPartition struct {
+	host string
+	day  string
+}
+
+// Write groups the records by partition and writes each group with an
+// unlogged batch, so every batch is handled by a single replica set.
+func (s *cassandraSink) Write(ctx context.Context, batch []RequestData) error {
+	groups := map[cassandraPartition][]*RequestData{}
+	for i := range batch {
+		data := &batch[i]
+		key := cassandraPartition{host: data.Host, day: data.Time.UTC().Format("2006-01-02")}
+		groups[key] = append(groups[key], data)
+	}
+
+	var firstErr error
+	for key, rows := range groups {
+		b := s.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		for _, data := range rows {
+			b.Query(cassandraInsert,
+				key.host, key.day, data.Time, gocql.UUIDFromTime(data.Time), data.IP,
+				data.UserAgent, data.Path, data.Method, data.Protocol, data.AcceptLanguage,
+				data.Referer, data.ContentType, data.ContentLength, int64(data.ResponseTime),
+				data.ResponseContentType, data.Status, data.ResponseSize,
+				data.Router, data.Service, data.EntryPoint, data.QueryString,
+				data.Headers, data.Cookies, data.TraceID, data.RequestID, data.Upgrade,
+				data.TunnelBytesReceived, data.TunnelBytesSent, data.GRPCService, data.GRPCMethod, data.GRPCStatus,
+				data.ClientPort, data.ConnectionReused, data.ResponseHeaders, int64(data.TTFB),
+				data.Upstream, data.ContentEncoding, data.UncompressedSize, data.Country, data.Region, data.City,
+				int64(data.ASN), data.ASOrg, data.Browser, data.BrowserVersion, data.OS, data.OSVersion, data.DeviceType,
+				data.IsBot, data.BotName, data.RDNS,
+				data.UTMSource, data.UTMMedium, data.UTMCampaign, data.UTMTerm, data.UTMContent,
+				data.ReferrerDomain, data.ReferrerType, data.Language,
+				data.IsDatacenter, data.IsVPN, data.IsTor, data.VisitorID, data.SessionID,
+				data.TLSVersion, data.TLSCipher, data.TLSFingerprint, data.RawPath, data.Extra,
+			)
+		}
+		err := s.session.ExecuteBatch(b)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to insert data: %v", err)
+		}
+	}
+	return firstErr
+}
+
+// Close closes the session.
+func (s *cassandraSink) Close() error {
+	s.session.Close()
+	return nil
+}
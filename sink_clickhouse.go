@@ -0,0 +1,322 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("clickhouse", newClickHouseSink, validateClickHouseConfig)
+}
+
+// clickHouseTimeFormat is the DateTime64(6) text format accepted by ClickHouse.
+const clickHouseTimeFormat = "2006-01-02 15:04:05.000000"
+
+// clickHouseSink stores request records in ClickHouse over its HTTP
+// interface. Rows are sent with async_insert enabled so the server batches
+// them before writing parts, which keeps per-request inserts cheap. Writes
+// wait for the server to flush them, so that rows it fails to store are
+// retried like any failed write instead of being lost.
+type clickHouseSink struct {
+	client   *http.Client
+	endpoint string
+	base     string
+	database string
+	user     string
+	password string
+}
+
+// clickHouseRow is the JSONEachRow representation of a request record.
+type clickHouseRow struct {
+	IP                  string            `json:"ip"`
+	UserAgent           string            `json:"user_agent"`
+	Path                string            `json:"path"`
+	RequestTime         string            `json:"request_time"`
+	Method              string            `json:"method"`
+	Protocol            string            `json:"protocol"`
+	Host                string            `json:"host"`
+	AcceptLanguage      string            `json:"accept_language"`
+	Referer             string            `json:"referer"`
+	ContentType         string            `json:"content_type"`
+	ContentLength       int64             `json:"content_length"`
+	ResponseTime        int64             `json:"response_time"`
+	ResponseContentType string            `json:"response_content_type"`
+	Status              int               `json:"status"`
+	ResponseSize        int64             `json:"response_size"`
+	Router              string            `json:"router"`
+	Service             string            `json:"service"`
+	EntryPoint          string            `json:"entrypoint"`
+	QueryString         string            `json:"query_string"`
+	Headers             map[string]string `json:"headers"`
+	Cookies             map[string]string `json:"cookies"`
+	TraceID             string            `json:"trace_id"`
+	RequestID           string            `json:"request_id"`
+	Upgrade             string            `json:"upgrade"`
+	TunnelBytesReceived int64             `json:"tunnel_bytes_received"`
+	TunnelBytesSent     int64             `json:"tunnel_bytes_sent"`
+	GRPCService         string            `json:"grpc_service"`
+	GRPCMethod          string            `json:"grpc_method"`
+	GRPCStatus          *int              `json:"grpc_status"`
+	ClientPort          int               `json:"client_port"`
+	ConnectionReused    bool              `json:"connection_reused"`
+	ResponseHeaders     map[string]string `json:"response_headers"`
+	TTFB                int64             `json:"ttfb"`
+	Upstream            string            `json:"upstream"`
+	ContentEncoding     string            `json:"content_encoding"`
+	UncompressedSize    *int64            `json:"uncompressed_size"`
+	Country             string            `json:"country"`
+	Region              string            `json:"region"`
+	City                string            `json:"city"`
+	ASN                 uint32            `json:"asn"`
+	ASOrg               string            `json:"as_org"`
+	Browser             string            `json:"browser"`
+	BrowserVersion      string            `json:"browser_version"`
+	OS                  string            `json:"os"`
+	OSVersion           string            `json:"os_version"`
+	DeviceType          string            `json:"device_type"`
+	IsBot               bool              `json:"is_bot"`
+	BotName             string            `json:"bot_name"`
+	RDNS                string            `json:"rdns"`
+	UTMSource           string            `json:"utm_source"`
+	UTMMedium           string            `json:"utm_medium"`
+	UTMCampaign         string            `json:"utm_campaign"`
+	UTMTerm             string            `json:"utm_term"`
+	UTMContent          string            `json:"utm_content"`
+	ReferrerDomain      string            `json:"referrer_domain"`
+	ReferrerType        string            `json:"referrer_type"`
+	Language            string            `json:"language"`
+	IsDatacenter        bool              `json:"is_datacenter"`
+	IsVPN               bool              `json:"is_vpn"`
+	IsTor               bool              `json:"is_tor"`
+	VisitorID           string            `json:"visitor_id"`
+	SessionID           string            `json:"session_id"`
+	TLSVersion          string            `json:"tls_version"`
+	TLSCipher           string            `json:"tls_cipher"`
+	TLSFingerprint      string            `json:"tls_fingerprint"`
+	RawPath             string            `json:"raw_path"`
+	Extra               map[string]string `json:"extra"`
+	RecordID            string            `json:"record_id"`
+}
+
+func validateClickHouseConfig(config *Config) error {
+	err := requireDSN(config)
+	if err != nil {
+		return err
+	}
+	return validateTableName(config)
+}
+
+// newClickHouseSink parses the DSN and checks that the server is reachable.
+// The DSN has the form http(s)://user:password@host:8123/database.
+func newClickHouseSink(config *Config) (Sink, error) {
+	u, err := url.Parse(config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ClickHouse DSN: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported ClickHouse DSN scheme %q", u.Scheme)
+	}
+
+	s := &clickHouseSink{
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+
+	table := quoteBackticks(config.TableName)
+	if config.SchemaName != "" {
+		table = quoteBackticks(config.SchemaName) + "." + table
+	}
+	query := url.Values{}
+	query.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	query.Set("async_insert", "1")
+	query.Set("wait_for_async_insert", "1")
+	// Tables created before a column was added, such as record_id, keep
+	// accepting rows.
+	query.Set("input_format_skip_unknown_fields", "1")
+	s.database = strings.Trim(u.Path, "/")
+	if s.database != "" {
+		query.Set("database", s.database)
+	}
+	base := url.URL{Scheme: u.Scheme, Host: u.Host}
+	s.base = base.String()
+	s.endpoint = s.base + "/?" + query.Encode()
+
+	err = s.ping(s.base + "/ping")
+	if err != nil {
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	if config.RetentionDays > 0 {
+		err = s.setRetentionTTL(table, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set retention TTL: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// ping checks the ClickHouse HTTP health endpoint.
+func (s *clickHouseSink) ping(endpoint string) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	_, err = sendRequest(s.client, req)
+	return err
+}
+
+// setRetentionTTL lets ClickHouse drop expired rows itself during merges.
+// Changing the TTL is a mutation of the whole table, so it is only done if
+// the table has a different one.
+func (s *clickHouseSink) setRetentionTTL(table string, config *Config) error {
+	database := "currentDatabase()"
+	if config.SchemaName != "" {
+		database = clickHouseString(config.SchemaName)
+	}
+	engine, err := s.exec(fmt.Sprintf("SELECT engine_full FROM system.tables WHERE database = %s AND name = %s FORMAT TabSeparatedRaw",
+		database, clickHouseString(config.TableName)))
+	if err != nil {
+		return err
+	}
+	ttl := fmt.Sprintf("TTL request_time + toIntervalDay(%d)", config.RetentionDays)
+	if strings.Contains(string(engine), ttl) {
+		return nil
+	}
+	_, err = s.exec(fmt.Sprintf("ALTER TABLE %s MODIFY TTL request_time + INTERVAL %d DAY", table, config.RetentionDays))
+	return err
+}
+
+// clickHouseString quotes s as a string literal.
+func clickHouseString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// exec runs a statement and returns its output.
+func (s *clickHouseSink) exec(statement string) ([]byte, error) {
+	endpoint := s.base + "/"
+	if s.database != "" {
+		endpoint += "?" + url.Values{"database": {s.database}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(statement))
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	return sendRequest(s.client, req)
+}
+
+// Write sends the records as a single JSONEachRow insert.
+func (s *clickHouseSink) Write(ctx context.Context, batch []RequestData) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, data := range batch {
+		err := enc.Encode(clickHouseRow{
+			IP:                  data.IP,
+			UserAgent:           data.UserAgent,
+			Path:                data.Path,
+			RequestTime:         data.Time.UTC().Format(clickHouseTimeFormat),
+			Method:              data.Method,
+			Protocol:            data.Protocol,
+			Host:                data.Host,
+			AcceptLanguage:      data.AcceptLanguage,
+			Referer:             data.Referer,
+			ContentType:         data.ContentType,
+			ContentLength:       data.ContentLength,
+			ResponseTime:        int64(data.ResponseTime),
+			ResponseContentType: data.ResponseContentType,
+			Status:              data.Status,
+			ResponseSize:        data.ResponseSize,
+			Router:              data.Router,
+			Service:             data.Service,
+			EntryPoint:          data.EntryPoint,
+			QueryString:         data.QueryString,
+			Headers:             data.Headers,
+			Cookies:             data.Cookies,
+			TraceID:             data.TraceID,
+			RequestID:           data.RequestID,
+			Upgrade:             data.Upgrade,
+			TunnelBytesReceived: data.TunnelBytesReceived,
+			TunnelBytesSent:     data.TunnelBytesSent,
+			GRPCService:         data.GRPCService,
+			GRPCMethod:          data.GRPCMethod,
+			GRPCStatus:          data.GRPCStatus,
+			ClientPort:          data.ClientPort,
+			ConnectionReused:    data.ConnectionReused,
+			ResponseHeaders:     data.ResponseHeaders,
+			TTFB:                int64(data.TTFB),
+			Upstream:            data.Upstream,
+			ContentEncoding:     data.ContentEncoding,
+			UncompressedSize:    data.UncompressedSize,
+			Country:             data.Country,
+			Region:              data.Region,
+			City:                data.City,
+			ASN:                 data.ASN,
+			ASOrg:               data.ASOrg,
+			Browser:             data.Browser,
+			BrowserVersion:      data.BrowserVersion,
+			OS:                  data.OS,
+			OSVersion:           data.OSVersion,
+			DeviceType:          data.DeviceType,
+			IsBot:               data.IsBot,
+			BotName:             data.BotName,
+			RDNS:                data.RDNS,
+			UTMSource:           data.UTMSource,
+			UTMMedium:           data.UTMMedium,
+			UTMCampaign:         data.UTMCampaign,
+			UTMTerm:             data.UTMTerm,
+			UTMContent:          data.UTMContent,
+			ReferrerDomain:      data.ReferrerDomain,
+			ReferrerType:        data.ReferrerType,
+			Language:            data.Language,
+			IsDatacenter:        data.IsDatacenter,
+			IsVPN:               data.IsVPN,
+			IsTor:               data.IsTor,
+			VisitorID:           data.VisitorID,
+			SessionID:           data.SessionID,
+			TLSVersion:          data.TLSVersion,
+			TLSCipher:           data.TLSCipher,
+			TLSFingerprint:      data.TLSFingerprint,
+			RawPath:             data.RawPath,
+			Extra:               data.Extra,
+			RecordID:            data.RecordID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to insert data: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *clickHouseSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,45 @@
+package traefik_analytics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClickHouseInsertSettings(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			return
+		}
+		query = r.URL.Query()
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.DatabaseDSN = server.URL + "/analytics"
+	config.RetentionDays = 0
+	sink, err := newClickHouseSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), []RequestData{{Time: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"async_insert":          "1",
+		"wait_for_async_insert": "1",
+		"database":              "analytics",
+		"query":                 "INSERT INTO `request_logs` FORMAT JSONEachRow",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
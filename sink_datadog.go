@@ -0,0 +1,197 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("datadog", newDatadogSink, validateDatadogConfig)
+}
+
+// DatadogConfig holds the settings of the Datadog logs intake sink.
+type DatadogConfig struct {
+	APIKey string `json:"apiKey,omitempty"`
+	// Site is the Datadog site, e.g. "datadoghq.com" or "datadoghq.eu".
+	Site    string `json:"site,omitempty"`
+	Service string `json:"service,omitempty"`
+	Source  string `json:"source,omitempty"`
+	// Tags are static tags such as "env:prod" added to every log.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// datadogMaxEntries is the maximum number of logs per intake request.
+const datadogMaxEntries = 1000
+
+// validateDatadogConfig checks the Datadog settings.
+func validateDatadogConfig(config *Config) error {
+	if config.Datadog.APIKey == "" {
+		return fmt.Errorf("datadog.apiKey is required")
+	}
+	if config.Datadog.Site == "" {
+		return fmt.Errorf("datadog.site is required")
+	}
+	return nil
+}
+
+// datadogSink sends request records to the Datadog logs intake API. Fields
+// use Datadog's standard attribute names so that they line up with APM and
+// the built-in web access log facets.
+type datadogSink struct {
+	client   *http.Client
+	config   DatadogConfig
+	endpoint string
+}
+
+// newDatadogSink creates the sink. No connection is made until the first write.
+func newDatadogSink(config *Config) (Sink, error) {
+	err := validateDatadogConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &datadogSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		config:   config.Datadog,
+		endpoint: "https://http-intake.logs." + config.Datadog.Site + "/api/v2/logs",
+	}, nil
+}
+
+// datadogLog is a log entry of the intake request.
+type datadogLog struct {
+	Source   string            `json:"ddsource,omitempty"`
+	Tags     string            `json:"ddtags,omitempty"`
+	Hostname string            `json:"hostname,omitempty"`
+	Service  string            `json:"service,omitempty"`
+	Message  string            `json:"message"`
+	Date     int64             `json:"date"`
+	Duration int64             `json:"duration"`
+	HTTP     datadogHTTP       `json:"http"`
+	Network  datadogNetwork    `json:"network"`
+	Extra    map[string]string `json:"analytics,omitempty"`
+}
+
+type datadogHTTP struct {
+	Method     string            `json:"method"`
+	StatusCode int               `json:"status_code"`
+	URLDetails map[string]string `json:"url_details"`
+	UserAgent  string            `json:"useragent,omitempty"`
+	Referer    string            `json:"referer,omitempty"`
+	Version    string            `json:"version,omitempty"`
+}
+
+type datadogNetwork struct {
+	Client struct {
+		IP   string `json:"ip"`
+		Port int    `json:"port,omitempty"`
+	} `json:"client"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// logEntry maps a record to a Datadog log entry.
+func (s *datadogSink) logEntry(data *RequestData) datadogLog {
+	tags := append([]string{}, s.config.Tags...)
+	tags = append(tags, "host:"+data.Host, "method:"+data.Method)
+
+	entry := datadogLog{
+		Source:   s.config.Source,
+		Tags:     strings.Join(tags, ","),
+		Hostname: data.Host,
+		Service:  s.config.Service,
+		Message:  data.Method + " " + data.Host + data.Path,
+		Date:     data.Time.UnixMilli(),
+		Duration: int64(data.ResponseTime),
+		HTTP: datadogHTTP{
+			Method:     data.Method,
+			StatusCode: data.Status,
+			URLDetails: map[string]string{"host": data.Host, "path": data.Path},
+			UserAgent:  data.UserAgent,
+			Referer:    data.Referer,
+			Version:    strings.TrimPrefix(data.Protocol, "HTTP/"),
+		},
+		Extra: map[string]string{
+			"accept_language": data.AcceptLanguage,
+			"language":        data.Language,
+			"content_type":    data.ContentType,
+			"query_string":    data.QueryString,
+			"trace_id":        data.TraceID,
+			"request_id":      data.RequestID,
+			"grpc_service":    data.GRPCService,
+			"grpc_method":     data.GRPCMethod,
+			"router":          data.Router,
+			"service":         data.Service,
+			"entrypoint":      data.EntryPoint,
+			"upstream":        data.Upstream,
+			"country":         data.Country,
+			"region":          data.Region,
+			"city":            data.City,
+			"as_org":          data.ASOrg,
+		},
+	}
+	entry.Network.Client.IP = data.IP
+	entry.Network.Client.Port = data.ClientPort
+	entry.Network.BytesRead = data.ContentLength
+	entry.Network.BytesWritten = data.ResponseSize
+	return entry
+}
+
+// Write sends the records in gzip-compressed requests of at most
+// datadogMaxEntries logs.
+func (s *datadogSink) Write(ctx context.Context, batch []RequestData) error {
+	for start := 0; start < len(batch); start += datadogMaxEntries {
+		end := start + datadogMaxEntries
+		if end > len(batch) {
+			end = len(batch)
+		}
+		err := s.send(ctx, batch[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send performs a single intake request.
+func (s *datadogSink) send(ctx context.Context, batch []RequestData) error {
+	entries := make([]datadogLog, len(batch))
+	for i := range batch {
+		entries[i] = s.logEntry(&batch[i])
+	}
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	err := json.NewEncoder(zw).Encode(entries)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", s.config.APIKey)
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to send logs: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *datadogSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
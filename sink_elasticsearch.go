@@ -0,0 +1,273 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("elasticsearch", newElasticsearchSink, validateElasticsearchConfig)
+}
+
+// ElasticsearchConfig holds the settings of the Elasticsearch/OpenSearch sink.
+type ElasticsearchConfig struct {
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// APIKey is the base64-encoded key sent as "Authorization: ApiKey ...".
+	APIKey string `json:"apiKey,omitempty"`
+	// IndexPrefix is combined with the record date to build daily indices,
+	// e.g. "traefik-analytics-2024.05.31".
+	IndexPrefix string `json:"indexPrefix,omitempty"`
+	// ManageTemplate installs an index template for the daily indices on startup.
+	ManageTemplate bool `json:"manageTemplate,omitempty"`
+}
+
+// validateElasticsearchConfig checks the Elasticsearch settings.
+func validateElasticsearchConfig(config *Config) error {
+	if config.Elasticsearch.URL == "" {
+		return fmt.Errorf("elasticsearch.url is required")
+	}
+	if config.Elasticsearch.IndexPrefix == "" {
+		return fmt.Errorf("elasticsearch.indexPrefix is required")
+	}
+	return nil
+}
+
+// elasticsearchSink indexes request records through the _bulk API.
+type elasticsearchSink struct {
+	client      *http.Client
+	config      ElasticsearchConfig
+	baseURL     string
+	indexPrefix string
+}
+
+// newElasticsearchSink creates the sink and installs the index template if
+// requested.
+func newElasticsearchSink(config *Config) (Sink, error) {
+	err := validateElasticsearchConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &elasticsearchSink{
+		client:      &http.Client{Timeout: 30 * time.Second},
+		config:      config.Elasticsearch,
+		baseURL:     strings.TrimSuffix(config.Elasticsearch.URL, "/"),
+		indexPrefix: strings.TrimSuffix(config.Elasticsearch.IndexPrefix, "-") + "-",
+	}
+
+	if config.Elasticsearch.ManageTemplate {
+		err = s.putIndexTemplate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to install index template: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// putIndexTemplate creates or updates the composable index template that
+// maps the fields of the daily indices.
+func (s *elasticsearchSink) putIndexTemplate() error {
+	keyword := map[string]string{"type": "keyword"}
+	template := map[string]interface{}{
+		"index_patterns": []string{s.indexPrefix + "*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"ip":                    keyword,
+					"user_agent":            map[string]interface{}{"type": "text", "fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 512}}},
+					"path":                  keyword,
+					"request_time":          map[string]string{"type": "date"},
+					"method":                keyword,
+					"protocol":              keyword,
+					"host":                  keyword,
+					"accept_language":       keyword,
+					"referer":               keyword,
+					"content_type":          keyword,
+					"content_length":        map[string]string{"type": "long"},
+					"response_time":         map[string]string{"type": "long"},
+					"response_content_type": keyword,
+					"status":                map[string]string{"type": "short"},
+					"response_size":         map[string]string{"type": "long"},
+					"router":                keyword,
+					"service":               keyword,
+					"entrypoint":            keyword,
+					"query_string":          keyword,
+					"headers":               map[string]string{"type": "flattened"},
+					"cookies":               map[string]string{"type": "flattened"},
+					"trace_id":              keyword,
+					"request_id":            keyword,
+					"upgrade":               keyword,
+					"tunnel_bytes_received": map[string]string{"type": "long"},
+					"tunnel_bytes_sent":     map[string]string{"type": "long"},
+					"grpc_service":          keyword,
+					"grpc_method":           keyword,
+					"grpc_status":           map[string]string{"type": "byte"},
+					"client_port":           map[string]string{"type": "integer"},
+					"connection_reused":     map[string]string{"type": "boolean"},
+					"response_headers":      map[string]string{"type": "flattened"},
+					"ttfb":                  map[string]string{"type": "long"},
+					"upstream":              keyword,
+					"content_encoding":      keyword,
+					"uncompressed_size":     map[string]string{"type": "long"},
+					"country":               keyword,
+					"region":                keyword,
+					"city":                  keyword,
+					"asn":                   map[string]string{"type": "long"},
+					"as_org":                keyword,
+					"browser":               keyword,
+					"browser_version":       keyword,
+					"os":                    keyword,
+					"os_version":            keyword,
+					"device_type":           keyword,
+					"is_bot":                map[string]string{"type": "boolean"},
+					"bot_name":              keyword,
+					"rdns":                  keyword,
+					"utm_source":            keyword,
+					"utm_medium":            keyword,
+					"utm_campaign":          keyword,
+					"utm_term":              keyword,
+					"utm_content":           keyword,
+					"referrer_domain":       keyword,
+					"referrer_type":         keyword,
+					"language":              keyword,
+					"is_datacenter":         map[string]string{"type": "boolean"},
+					"is_vpn":                map[string]string{"type": "boolean"},
+					"is_tor":                map[string]string{"type": "boolean"},
+					"visitor_id":            keyword,
+					"session_id":            keyword,
+					"tls_version":           keyword,
+					"tls_cipher":            keyword,
+					"tls_fingerprint":       keyword,
+					"raw_path":              keyword,
+					"extra":                 map[string]string{"type": "flattened"},
+					"record_id":             keyword,
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(s.indexPrefix, "-")
+	req, err := s.newRequest(context.Background(), http.MethodPut, "/_index_template/"+name, body)
+	if err != nil {
+		return err
+	}
+	_, err = sendRequest(s.client, req)
+	return err
+}
+
+// newRequest builds an authenticated request against the cluster.
+func (s *elasticsearchSink) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case s.config.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.config.APIKey)
+	case s.config.Username != "":
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	return req, nil
+}
+
+// elasticsearchBulkResponse is the part of the _bulk response we inspect.
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Write indexes the records with a single bulk request. Each record goes to
+// the daily index matching its request time, with its record ID as the
+// document ID, so that records written again replace themselves.
+func (s *elasticsearchSink) Write(ctx context.Context, batch []RequestData) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for i := range batch {
+		index := s.indexPrefix + batch[i].Time.UTC().Format("2006.01.02")
+		action := map[string]string{"_index": index}
+		if batch[i].RecordID != "" {
+			action["_id"] = batch[i].RecordID
+		}
+		err := enc.Encode(map[string]map[string]string{"index": action})
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		err = enc.Encode(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, "/_bulk", body.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	respBody, err := sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to index data: %v", err)
+	}
+
+	var resp elasticsearchBulkResponse
+	err = json.Unmarshal(respBody, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to decode bulk response: %v", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	// Documents the cluster refused are rejected, so that they are dead-
+	// lettered; if any failed for a reason that may pass, such as a full
+	// queue, the whole batch is retried, which the document IDs make safe.
+	var rejected []rejectedRecord
+	retryable := 0
+	var reason string
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			itemErr := fmt.Errorf("%s: %s", result.Error.Type, result.Error.Reason)
+			if reason == "" {
+				reason = itemErr.Error()
+			}
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 || i >= len(batch) {
+				retryable++
+			} else {
+				rejected = append(rejected, rejectedRecord{data: batch[i], err: itemErr})
+			}
+		}
+	}
+	err = fmt.Errorf("failed to index %d of %d records: %s", retryable+len(rejected), len(batch), reason)
+	if retryable > 0 || len(rejected) == 0 {
+		return err
+	}
+	return &partialWriteError{err: err, rejected: rejected}
+}
+
+// Close releases idle HTTP connections.
+func (s *elasticsearchSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,152 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestElasticsearchDocumentIDs(t *testing.T) {
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for line := 0; scanner.Scan(); line++ {
+			if line%2 != 0 {
+				continue
+			}
+			var action map[string]map[string]string
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Error(err)
+			}
+			ids = append(ids, action["index"]["_id"])
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.Elasticsearch.URL = server.URL
+	config.Elasticsearch.IndexPrefix = "analytics"
+	sink, err := newElasticsearchSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	recordID := newUUIDv7(time.Now())
+	batch := []RequestData{{Time: time.Now(), RecordID: recordID}, {Time: time.Now()}}
+	if err := sink.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != recordID || ids[1] != "" {
+		t.Errorf("document IDs %q, want [%s, none]", ids, recordID)
+	}
+}
+
+// newBulkTestSink returns a sink whose bulk requests are answered with the
+// given item statuses.
+func newBulkTestSink(t *testing.T, config *Config, statuses []int) Sink {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		var items []map[string]interface{}
+		for _, status := range statuses {
+			result := map[string]interface{}{"status": status}
+			switch {
+			case status == http.StatusTooManyRequests:
+				result["error"] = map[string]string{"type": "es_rejected_execution_exception", "reason": "queue full"}
+			case status >= 300:
+				result["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+			}
+			failed = failed || status >= 300
+			items = append(items, map[string]interface{}{"index": result})
+		}
+		resp := map[string]interface{}{"errors": failed, "items": items}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	config.Elasticsearch.URL = server.URL
+	config.Elasticsearch.IndexPrefix = "analytics"
+	config.Elasticsearch.ManageTemplate = false
+	sink, err := newElasticsearchSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func TestElasticsearchBulkFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRejected []string
+	}{
+		{"all indexed", []int{201, 201, 201}, false, nil},
+		{"mapping errors", []int{201, 400, 201}, true, []string{"/1"}},
+		{"queue full", []int{201, 429, 201}, true, nil},
+		{"queue full and mapping errors", []int{400, 429, 201}, true, nil},
+		{"server errors", []int{503, 503, 503}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newBulkTestSink(t, CreateConfig(), tt.statuses)
+			batch := []RequestData{{Path: "/0", Time: time.Now()}, {Path: "/1", Time: time.Now()}, {Path: "/2", Time: time.Now()}}
+			err := sink.Write(context.Background(), batch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var partial *partialWriteError
+			if !errors.As(err, &partial) {
+				if tt.wantRejected != nil {
+					t.Fatalf("got %v, want rejected records %v", err, tt.wantRejected)
+				}
+				return
+			}
+			var got []string
+			for _, r := range partial.rejected {
+				got = append(got, r.data.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantRejected, ",") {
+				t.Errorf("rejected %v, want %v", got, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestElasticsearchDeadLetters(t *testing.T) {
+	config := CreateConfig()
+	config.StorageType = "elasticsearch"
+	config.DeadLetter.Path = filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	sink := newBulkTestSink(t, config, []int{201, 400, 201})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, err := newOutput(ctx, "test", "elasticsearch", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []RequestData{{Path: "/0", Time: time.Now()}, {Path: "/1", Time: time.Now()}, {Path: "/2", Time: time.Now()}}
+	out.write(ctx, sink, batch)
+
+	content, err := os.ReadFile(config.DeadLetter.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"path":"/1"`) || !strings.Contains(lines[0], "mapper_parsing_exception") {
+		t.Errorf("dead letters %q, want only the record of /1", lines)
+	}
+}
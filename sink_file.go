@@ -0,0 +1,239 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerSink("file", newFileSink, validateFileConfig)
+}
+
+// FileConfig holds the settings of the JSON Lines file sink.
+type FileConfig struct {
+	Path string `json:"path,omitempty"`
+	// MaxSizeBytes rotates the file once it grows beyond this size.
+	// Zero disables size-based rotation.
+	MaxSizeBytes int64 `json:"maxSizeBytes,omitempty"`
+	// RotateInterval rotates the file once it is older than this Go
+	// duration string. Empty disables time-based rotation.
+	RotateInterval string `json:"rotateInterval,omitempty"`
+	// MaxBackups is the number of rotated files to keep. Zero keeps all.
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// Compress gzips rotated files.
+	Compress bool `json:"compress,omitempty"`
+}
+
+// validateFileConfig checks the file sink settings.
+func validateFileConfig(config *Config) error {
+	if config.File.Path == "" {
+		return fmt.Errorf("file.path is required")
+	}
+	if config.File.RotateInterval != "" {
+		_, err := time.ParseDuration(config.File.RotateInterval)
+		if err != nil {
+			return fmt.Errorf("invalid file.rotateInterval: %v", err)
+		}
+	}
+	return nil
+}
+
+// fileSink appends request records as newline-delimited JSON to a local
+// file and rotates it by size and age.
+type fileSink struct {
+	config         FileConfig
+	rotateInterval time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// newFileSink opens (or creates) the output file.
+func newFileSink(config *Config) (Sink, error) {
+	err := validateFileConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{config: config.File}
+	if config.File.RotateInterval != "" {
+		s.rotateInterval, _ = time.ParseDuration(config.File.RotateInterval)
+	}
+
+	err = os.MkdirAll(filepath.Dir(config.File.Path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	err = s.open()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the output file for appending.
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	s.openedAt = time.Now()
+	return nil
+}
+
+// Write appends one JSON line per record, rotating first if needed.
+func (s *fileSink) Write(ctx context.Context, batch []RequestData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		err := s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.needsRotation() {
+		err := s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for i := range batch {
+		err := enc.Encode(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+	n := w.Buffered()
+	err := w.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	s.size += int64(n)
+	return nil
+}
+
+// needsRotation reports whether the current file is too large or too old.
+func (s *fileSink) needsRotation() bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.config.MaxSizeBytes > 0 && s.size >= s.config.MaxSizeBytes {
+		return true
+	}
+	return s.rotateInterval > 0 && time.Since(s.openedAt) >= s.rotateInterval
+}
+
+// rotate moves the current file aside and opens a fresh one. Compression
+// and cleanup of old backups happen in the background.
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	ext := filepath.Ext(s.config.Path)
+	base := strings.TrimSuffix(s.config.Path, ext)
+	rotated := base + "-" + time.Now().UTC().Format("20060102T150405.000") + ext
+
+	err := os.Rename(s.config.Path, rotated)
+	if err != nil {
+		return fmt.Errorf("failed to rotate file: %v", err)
+	}
+	err = s.open()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if s.config.Compress {
+			err := gzipFile(rotated)
+			if err != nil {
+				log.Printf("Failed to compress %s: %v", rotated, err)
+			}
+		}
+		if s.config.MaxBackups > 0 {
+			s.removeOldBackups(base, ext)
+		}
+	}()
+	return nil
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// removeOldBackups deletes all but the newest MaxBackups rotated files.
+func (s *fileSink) removeOldBackups(base, ext string) {
+	matches, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+	// The timestamp in the name makes lexical order chronological.
+	sort.Strings(matches)
+	for len(matches) > s.config.MaxBackups {
+		err = os.Remove(matches[0])
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove old analytics file: %v", err)
+		}
+		matches = matches[1:]
+	}
+}
+
+// Close closes the output file.
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
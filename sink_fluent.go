@@ -0,0 +1,169 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerSink("fluent", newFluentSink, validateFluentConfig)
+}
+
+// FluentConfig holds the settings of the Fluentd/Fluent Bit forward output.
+type FluentConfig struct {
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	TLS     bool   `json:"tls,omitempty"`
+	// RequireAck waits for the receiver to acknowledge every chunk, which
+	// gives at-least-once delivery to the aggregator.
+	RequireAck bool `json:"requireAck,omitempty"`
+}
+
+// validateFluentConfig checks the Fluent settings.
+func validateFluentConfig(config *Config) error {
+	if config.Fluent.Address == "" {
+		return fmt.Errorf("fluent.address is required")
+	}
+	if config.Fluent.Tag == "" {
+		return fmt.Errorf("fluent.tag is required")
+	}
+	return nil
+}
+
+// fluentSink sends request records with the Fluent forward protocol, one
+// Forward-mode message per batch. A broken connection is dropped and
+// redialed on the next write.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+type fluentSink struct {
+	config FluentConfig
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newFluentSink connects to the receiver.
+func newFluentSink(config *Config) (Sink, error) {
+	err := validateFluentConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fluentSink{config: config.Fluent}
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the receiver.
+func (s *fluentSink) connect() error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.config.Address, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", s.config.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to fluent: %v", err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Write sends the records as a single Forward-mode message:
+//
+//	[tag, [[time, record], ...], {"chunk": id}]
+func (s *fluentSink) Write(ctx context.Context, batch []RequestData) error {
+	msg := appendMsgpackArrayHeader(nil, 3)
+	msg = appendMsgpackString(msg, s.config.Tag)
+	msg = appendMsgpackArrayHeader(msg, len(batch))
+	for i := range batch {
+		record, err := recordMap(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		msg = appendMsgpackArrayHeader(msg, 2)
+		msg = appendMsgpackEventTime(msg, batch[i].Time)
+		msg = appendMsgpackValue(msg, record)
+	}
+
+	var chunk string
+	if s.config.RequireAck {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		msg = appendMsgpackMapHeader(msg, 1)
+		msg = appendMsgpackString(msg, "chunk")
+		msg = appendMsgpackString(msg, chunk)
+	} else {
+		msg = appendMsgpackMapHeader(msg, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.send(ctx, msg, chunk)
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to forward data: %v", err)
+	}
+	return nil
+}
+
+// send writes the message and, if chunk is set, waits for its ack.
+func (s *fluentSink) send(ctx context.Context, msg []byte, chunk string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	err := s.conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(msg)
+	if err != nil || chunk == "" {
+		return err
+	}
+
+	resp, err := readMsgpackStringMap(s.reader)
+	if err != nil {
+		return fmt.Errorf("failed to read ack: %v", err)
+	}
+	if resp["ack"] != chunk {
+		return fmt.Errorf("unexpected ack %q", resp["ack"])
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (s *fluentSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,211 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("ga4", newGA4Sink, validateGA4Config)
+}
+
+// GA4Config holds the settings of the Google Analytics 4 forwarding sink.
+type GA4Config struct {
+	MeasurementID string `json:"measurementId,omitempty"`
+	APISecret     string `json:"apiSecret,omitempty"`
+	// Endpoint is the Measurement Protocol collect URL. Use
+	// "https://www.google-analytics.com/debug/mp/collect" to validate events.
+	Endpoint string `json:"endpoint,omitempty"`
+	// ClientIDSalt keys the hash the client ID is derived from. Without it a
+	// random salt is used, so client IDs change when Traefik restarts.
+	ClientIDSalt string `json:"clientIdSalt,omitempty"`
+	MaxRetries   int    `json:"maxRetries,omitempty"`
+	RetryBackoff string `json:"retryBackoff,omitempty"`
+}
+
+// ga4MaxEvents is the number of events the Measurement Protocol accepts per
+// request.
+const ga4MaxEvents = 25
+
+// validateGA4Config checks the GA4 settings.
+func validateGA4Config(config *Config) error {
+	c := config.GA4
+	switch {
+	case c.MeasurementID == "":
+		return fmt.Errorf("ga4.measurementId is required")
+	case c.APISecret == "":
+		return fmt.Errorf("ga4.apiSecret is required")
+	case c.Endpoint == "":
+		return fmt.Errorf("ga4.endpoint is required")
+	}
+	_, err := time.ParseDuration(c.RetryBackoff)
+	if err != nil {
+		return fmt.Errorf("invalid ga4.retryBackoff: %v", err)
+	}
+	return nil
+}
+
+// ga4Sink mirrors page views to Google Analytics 4 through the Measurement
+// Protocol. Only successful GET requests answered with an HTML document are
+// sent; assets, API calls and other requests are dropped.
+type ga4Sink struct {
+	client   *http.Client
+	config   GA4Config
+	endpoint string
+	salt     []byte
+	backoff  time.Duration
+}
+
+// newGA4Sink creates the sink. No connection is made until the first write.
+func newGA4Sink(config *Config) (Sink, error) {
+	err := validateGA4Config(config)
+	if err != nil {
+		return nil, err
+	}
+	c := config.GA4
+	backoff, _ := time.ParseDuration(c.RetryBackoff)
+
+	salt := []byte(c.ClientIDSalt)
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		rand.Read(salt)
+	}
+
+	query := url.Values{}
+	query.Set("measurement_id", c.MeasurementID)
+	query.Set("api_secret", c.APISecret)
+
+	return &ga4Sink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		config:   c,
+		endpoint: c.Endpoint + "?" + query.Encode(),
+		salt:     salt,
+		backoff:  backoff,
+	}, nil
+}
+
+// isPageView reports whether a record looks like a page view: a successful
+// GET request answered with an HTML document.
+func isPageView(data *RequestData) bool {
+	if data.Method != http.MethodGet || data.Status < 200 || data.Status > 299 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(data.ResponseContentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// clientID derives a GA client ID from IP and User-Agent. The salted HMAC
+// keeps the address from being recoverable from the ID, and the result uses
+// the "<random>.<timestamp>" shape of the gtag.js client ID.
+func (s *ga4Sink) clientID(data *RequestData) string {
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(data.IP + "|" + data.UserAgent))
+	sum := mac.Sum(nil)
+	return fmt.Sprintf("%d.%d", binary.BigEndian.Uint32(sum[0:4]), binary.BigEndian.Uint32(sum[4:8]))
+}
+
+// ga4Event is an event of a Measurement Protocol request.
+type ga4Event struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// event maps a record to a page_view event.
+func (s *ga4Sink) event(data *RequestData) ga4Event {
+	params := map[string]interface{}{
+		"page_location":        pageURL(data),
+		"engagement_time_msec": 1,
+	}
+	if data.Referer != "" {
+		params["page_referrer"] = data.Referer
+	}
+	if data.Language != "" {
+		params["language"] = strings.ToLower(data.Language)
+	}
+	return ga4Event{Name: "page_view", Params: params}
+}
+
+// Write sends the page views of the batch. All events of a request must
+// share a client ID, so records are grouped by client first and each group
+// is sent in chunks of at most ga4MaxEvents.
+func (s *ga4Sink) Write(ctx context.Context, batch []RequestData) error {
+	var order []string
+	groups := map[string][]*RequestData{}
+	for i := range batch {
+		data := &batch[i]
+		if !isPageView(data) {
+			continue
+		}
+		id := s.clientID(data)
+		if _, ok := groups[id]; !ok {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], data)
+	}
+
+	var firstErr error
+	for _, id := range order {
+		records := groups[id]
+		for len(records) > 0 {
+			n := min(len(records), ga4MaxEvents)
+			err := s.send(ctx, id, records[:n])
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			records = records[n:]
+		}
+	}
+	return firstErr
+}
+
+// send delivers the events of one client, retrying transient failures. The
+// request is timestamped with the first record so events are attributed to
+// when they happened rather than when they were sent.
+func (s *ga4Sink) send(ctx context.Context, clientID string, records []*RequestData) error {
+	events := make([]ga4Event, len(records))
+	for i, data := range records {
+		events[i] = s.event(data)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"client_id":        clientID,
+		"timestamp_micros": records[0].Time.UnixMicro(),
+		"events":           events,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	err = withRetries(ctx, s.config.MaxRetries, s.backoff, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		_, err = sendRequest(s.client, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send events: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *ga4Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
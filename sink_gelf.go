@@ -0,0 +1,251 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerSink("gelf", newGELFSink, validateGELFConfig)
+}
+
+// GELFConfig holds the settings of the Graylog GELF output.
+type GELFConfig struct {
+	// Network is "udp" or "tcp".
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Compression is "gzip", "zlib" or "none". It only applies to UDP, as
+	// GELF over TCP does not support compression.
+	Compression string `json:"compression,omitempty"`
+	// ChunkSize is the maximum UDP datagram size; larger messages are chunked.
+	ChunkSize int `json:"chunkSize,omitempty"`
+}
+
+// GELF chunking limits, see https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// validateGELFConfig checks the GELF settings.
+func validateGELFConfig(config *Config) error {
+	c := config.GELF
+	switch c.Network {
+	case "udp", "tcp":
+	default:
+		return fmt.Errorf("unsupported gelf.network %q", c.Network)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("gelf.address is required")
+	}
+	switch c.Compression {
+	case "gzip", "zlib", "none":
+	default:
+		return fmt.Errorf("unsupported gelf.compression %q", c.Compression)
+	}
+	if c.Network == "udp" && c.ChunkSize <= gelfChunkHeaderSize {
+		return fmt.Errorf("gelf.chunkSize must be larger than %d", gelfChunkHeaderSize)
+	}
+	return nil
+}
+
+// gelfSink sends one GELF 1.1 message per request record. A broken
+// connection is dropped and redialed on the next write.
+type gelfSink struct {
+	config   GELFConfig
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newGELFSink connects to the Graylog input.
+func newGELFSink(config *Config) (Sink, error) {
+	err := validateGELFConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "traefik"
+	}
+
+	s := &gelfSink{config: config.GELF, hostname: hostname}
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the Graylog input.
+func (s *gelfSink) connect() error {
+	conn, err := net.DialTimeout(s.config.Network, s.config.Address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to graylog: %v", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// message renders a record as a GELF message. Record fields become
+// additional fields with the mandatory underscore prefix.
+func (s *gelfSink) message(data *RequestData) ([]byte, error) {
+	fields, err := recordMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make(map[string]interface{}, len(fields)+5)
+	for k, v := range fields {
+		// GELF fields must be strings or numbers, so maps are flattened.
+		if sub, ok := v.(map[string]interface{}); ok {
+			for subKey, subValue := range sub {
+				msg["_"+k+"_"+subKey] = subValue
+			}
+			continue
+		}
+		msg["_"+k] = v
+	}
+	msg["version"] = "1.1"
+	msg["host"] = s.hostname
+	msg["short_message"] = data.Method + " " + data.Host + data.Path
+	msg["timestamp"] = float64(data.Time.UnixMilli()) / 1000
+	msg["level"] = 6 // informational
+
+	return json.Marshal(msg)
+}
+
+// Write sends the records, one message each.
+func (s *gelfSink) Write(ctx context.Context, batch []RequestData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	err := s.conn.SetWriteDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	var stream bytes.Buffer
+	for i := range batch {
+		msg, err := s.message(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+
+		if s.config.Network == "tcp" {
+			// Messages are delimited by a null byte.
+			stream.Write(msg)
+			stream.WriteByte(0)
+			continue
+		}
+
+		err = s.sendDatagram(msg)
+		if err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to send GELF message: %v", err)
+		}
+	}
+
+	if stream.Len() > 0 {
+		_, err = s.conn.Write(stream.Bytes())
+		if err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to send GELF message: %v", err)
+		}
+	}
+	return nil
+}
+
+// sendDatagram compresses msg and sends it in one or more UDP chunks.
+func (s *gelfSink) sendDatagram(msg []byte) error {
+	payload, err := s.compress(msg)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) <= s.config.ChunkSize {
+		_, err = s.conn.Write(payload)
+		return err
+	}
+
+	chunkData := s.config.ChunkSize - gelfChunkHeaderSize
+	count := (len(payload) + chunkData - 1) / chunkData
+	if count > gelfMaxChunks {
+		return fmt.Errorf("message of %d bytes needs more than %d chunks", len(payload), gelfMaxChunks)
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunk := make([]byte, 0, s.config.ChunkSize)
+	for seq := 0; seq < count; seq++ {
+		end := (seq + 1) * chunkData
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk = append(chunk[:0], 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(seq), byte(count))
+		chunk = append(chunk, payload[seq*chunkData:end]...)
+		_, err = s.conn.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compress applies the configured compression.
+func (s *gelfSink) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch s.config.Compression {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	_, err := w.Write(msg)
+	if err == nil {
+		err = w.Close()
+	}
+	return buf.Bytes(), err
+}
+
+// Close closes the connection.
+func (s *gelfSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
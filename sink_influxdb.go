@@ -0,0 +1,146 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("influxdb", newInfluxDBSink, validateInfluxDBConfig)
+}
+
+// InfluxDBConfig holds the settings of the InfluxDB v2 sink.
+type InfluxDBConfig struct {
+	URL    string `json:"url,omitempty"`
+	Token  string `json:"token,omitempty"`
+	Org    string `json:"org,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	// Measurement is the measurement name written for every request.
+	Measurement string `json:"measurement,omitempty"`
+}
+
+// validateInfluxDBConfig checks the InfluxDB settings.
+func validateInfluxDBConfig(config *Config) error {
+	switch {
+	case config.InfluxDB.URL == "":
+		return fmt.Errorf("influxdb.url is required")
+	case config.InfluxDB.Org == "":
+		return fmt.Errorf("influxdb.org is required")
+	case config.InfluxDB.Bucket == "":
+		return fmt.Errorf("influxdb.bucket is required")
+	case config.InfluxDB.Measurement == "":
+		return fmt.Errorf("influxdb.measurement is required")
+	}
+	return nil
+}
+
+// Escaping rules of the line protocol, see
+// https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxDBSink writes request metrics to InfluxDB v2 as line protocol.
+type influxDBSink struct {
+	client      *http.Client
+	endpoint    string
+	token       string
+	measurement string
+}
+
+// newInfluxDBSink creates the sink. No connection is made until the first write.
+func newInfluxDBSink(config *Config) (Sink, error) {
+	err := validateInfluxDBConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("org", config.InfluxDB.Org)
+	query.Set("bucket", config.InfluxDB.Bucket)
+	query.Set("precision", "ns")
+
+	return &influxDBSink{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		endpoint:    strings.TrimSuffix(config.InfluxDB.URL, "/") + "/api/v2/write?" + query.Encode(),
+		token:       config.InfluxDB.Token,
+		measurement: influxMeasurementEscaper.Replace(config.InfluxDB.Measurement),
+	}, nil
+}
+
+// Write sends one point per record.
+func (s *influxDBSink) Write(ctx context.Context, batch []RequestData) error {
+	var body bytes.Buffer
+	for i := range batch {
+		s.appendPoint(&body, &batch[i])
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to write points: %v", err)
+	}
+	return nil
+}
+
+// appendPoint renders a record as a single line-protocol point.
+func (s *influxDBSink) appendPoint(buf *bytes.Buffer, data *RequestData) {
+	buf.WriteString(s.measurement)
+	appendInfluxTag(buf, "host", data.Host)
+	appendInfluxTag(buf, "method", data.Method)
+	appendInfluxTag(buf, "protocol", data.Protocol)
+	appendInfluxTag(buf, "status", strconv.Itoa(data.Status))
+	appendInfluxTag(buf, "router", data.Router)
+	appendInfluxTag(buf, "service", data.Service)
+	appendInfluxTag(buf, "entrypoint", data.EntryPoint)
+	appendInfluxTag(buf, "upstream", data.Upstream)
+	appendInfluxTag(buf, "country", data.Country)
+	appendInfluxTag(buf, "device_type", data.DeviceType)
+	appendInfluxTag(buf, "is_bot", strconv.FormatBool(data.IsBot))
+	appendInfluxTag(buf, "referrer_type", data.ReferrerType)
+
+	buf.WriteString(" response_time_ms=")
+	buf.WriteString(strconv.FormatFloat(float64(data.ResponseTime)/float64(time.Millisecond), 'f', -1, 64))
+	buf.WriteString(",ttfb_ms=")
+	buf.WriteString(strconv.FormatFloat(float64(data.TTFB)/float64(time.Millisecond), 'f', -1, 64))
+	buf.WriteString(",content_length=")
+	buf.WriteString(strconv.FormatInt(data.ContentLength, 10))
+	buf.WriteString("i,response_size=")
+	buf.WriteString(strconv.FormatInt(data.ResponseSize, 10))
+	buf.WriteString("i ")
+	buf.WriteString(strconv.FormatInt(data.Time.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
+
+// appendInfluxTag appends ",key=value". Empty values are not allowed in the
+// line protocol, so such tags are left out.
+func appendInfluxTag(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	buf.WriteString(influxTagEscaper.Replace(value))
+}
+
+// Close releases idle HTTP connections.
+func (s *influxDBSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
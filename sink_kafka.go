@@ -0,0 +1,107 @@
+package traefik_analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func init() {
+	registerSink("kafka", newKafkaSink, validateKafkaConfig)
+}
+
+// KafkaConfig holds the settings of the Kafka sink.
+type KafkaConfig struct {
+	Brokers []string `json:"brokers,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+	// PartitionKey selects the record field used as message key: "host",
+	// "ip", "path" or "service". Messages with the same key land in the same
+	// partition.
+	// When empty, messages are spread evenly across partitions.
+	PartitionKey string `json:"partitionKey,omitempty"`
+}
+
+// kafkaPartitionKeys maps the supported PartitionKey values to record fields.
+var kafkaPartitionKeys = map[string]func(data *RequestData) string{
+	"host":    func(d *RequestData) string { return d.Host },
+	"ip":      func(d *RequestData) string { return d.IP },
+	"path":    func(d *RequestData) string { return d.Path },
+	"service": func(d *RequestData) string { return d.Service },
+}
+
+// validateKafkaConfig checks the Kafka settings.
+func validateKafkaConfig(config *Config) error {
+	if len(config.Kafka.Brokers) == 0 {
+		return fmt.Errorf("kafka.brokers is required")
+	}
+	if config.Kafka.Topic == "" {
+		return fmt.Errorf("kafka.topic is required")
+	}
+	if config.Kafka.PartitionKey != "" {
+		if _, ok := kafkaPartitionKeys[config.Kafka.PartitionKey]; !ok {
+			return fmt.Errorf("unsupported kafka.partitionKey %q", config.Kafka.PartitionKey)
+		}
+	}
+	return nil
+}
+
+// kafkaSink publishes request records as JSON messages to a Kafka topic.
+type kafkaSink struct {
+	writer *kafka.Writer
+	key    func(data *RequestData) string
+}
+
+// newKafkaSink creates the Kafka producer. Connections to the brokers are
+// established on the first write.
+func newKafkaSink(config *Config) (Sink, error) {
+	err := validateKafkaConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Kafka.Brokers...),
+		Topic:        config.Kafka.Topic,
+		Balancer:     &kafka.RoundRobin{},
+		RequiredAcks: kafka.RequireOne,
+		// Records already arrive in batches, don't hold them back.
+		BatchTimeout: 10 * time.Millisecond,
+	}
+
+	s := &kafkaSink{writer: writer}
+	if config.Kafka.PartitionKey != "" {
+		writer.Balancer = &kafka.Hash{}
+		s.key = kafkaPartitionKeys[config.Kafka.PartitionKey]
+	}
+
+	return s, nil
+}
+
+// Write publishes one message per record.
+func (s *kafkaSink) Write(ctx context.Context, batch []RequestData) error {
+	messages := make([]kafka.Message, len(batch))
+	for i := range batch {
+		value, err := json.Marshal(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		messages[i].Value = value
+		if s.key != nil {
+			messages[i].Key = []byte(s.key(&batch[i]))
+		}
+	}
+
+	err := s.writer.WriteMessages(ctx, messages...)
+	if err != nil {
+		return fmt.Errorf("failed to publish data: %v", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes the broker connections.
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
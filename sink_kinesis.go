@@ -0,0 +1,283 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("kinesis", newKinesisSink, validateKinesisConfig)
+}
+
+// KinesisConfig holds the settings of the AWS Kinesis Data Streams sink.
+type KinesisConfig struct {
+	StreamName string `json:"streamName,omitempty"`
+	Region     string `json:"region,omitempty"`
+	// Endpoint overrides the regional endpoint, e.g. for LocalStack.
+	Endpoint string `json:"endpoint,omitempty"`
+	// PartitionKey selects the record field used as partition key, e.g. "ip".
+	PartitionKey string `json:"partitionKey,omitempty"`
+	// Aggregate packs many records into each Kinesis record using the KPL
+	// aggregation format, which consumers built on the KCL or the
+	// aggregation libraries unpack transparently. This greatly reduces the
+	// number of billed PUT payload units.
+	Aggregate bool `json:"aggregate,omitempty"`
+	// AccessKeyID, SecretAccessKey and SessionToken default to the
+	// standard AWS_* environment variables.
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+}
+
+// Service limits of PutRecords.
+const (
+	kinesisMaxRecords      = 500
+	kinesisMaxRequestBytes = 5 << 20
+	// kinesisMaxRecordBytes leaves headroom below the 1MiB record limit for
+	// the partition key and the aggregation framing.
+	kinesisMaxRecordBytes = 1000 << 10
+)
+
+// kinesisAggregationMagic prefixes KPL aggregated records.
+var kinesisAggregationMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// validateKinesisConfig checks the Kinesis settings.
+func validateKinesisConfig(config *Config) error {
+	c := config.Kinesis
+	switch {
+	case c.StreamName == "":
+		return fmt.Errorf("kinesis.streamName is required")
+	case c.Region == "":
+		return fmt.Errorf("kinesis.region is required")
+	}
+	if _, ok := templateFields[c.PartitionKey]; !ok {
+		return fmt.Errorf("unsupported kinesis.partitionKey %q", c.PartitionKey)
+	}
+	return nil
+}
+
+// kinesisSink puts request records into a Kinesis data stream with the
+// PutRecords API.
+type kinesisSink struct {
+	client       *http.Client
+	config       KinesisConfig
+	endpoint     string
+	creds        awsCredentials
+	partitionKey func(data *RequestData) string
+}
+
+// newKinesisSink creates the sink. No connection is made until the first write.
+func newKinesisSink(config *Config) (Sink, error) {
+	err := validateKinesisConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := config.Kinesis
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kinesis." + c.Region + ".amazonaws.com"
+	}
+
+	return &kinesisSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		config:   c,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		creds: awsCredentialsFromEnv(awsCredentials{
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+			SessionToken:    c.SessionToken,
+		}),
+		partitionKey: templateFields[c.PartitionKey],
+	}, nil
+}
+
+// kinesisRecord is an entry of the PutRecords request. Data is base64
+// encoded by encoding/json.
+type kinesisRecord struct {
+	Data         []byte `json:"Data"`
+	PartitionKey string `json:"PartitionKey"`
+}
+
+// Write encodes the records, aggregating them if enabled, and sends them in
+// as few PutRecords requests as the service limits allow.
+func (s *kinesisSink) Write(ctx context.Context, batch []RequestData) error {
+	var records []kinesisRecord
+	var agg kinesisAggregator
+	for i := range batch {
+		data, err := json.Marshal(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		key := s.partitionKey(&batch[i])
+		if key == "" {
+			key = "-"
+		}
+		if len(key) > 256 {
+			key = key[:256]
+		}
+
+		if !s.config.Aggregate {
+			records = append(records, kinesisRecord{Data: data, PartitionKey: key})
+			continue
+		}
+		if agg.size()+len(data)+len(key) > kinesisMaxRecordBytes && agg.count() > 0 {
+			records = append(records, agg.record())
+			agg = kinesisAggregator{}
+		}
+		agg.add(key, data)
+	}
+	if agg.count() > 0 {
+		records = append(records, agg.record())
+	}
+
+	var chunk []kinesisRecord
+	size := 0
+	for _, record := range records {
+		recordSize := len(record.Data) + len(record.PartitionKey)
+		if len(chunk) > 0 && (len(chunk) >= kinesisMaxRecords || size+recordSize > kinesisMaxRequestBytes) {
+			err := s.putRecords(ctx, chunk)
+			if err != nil {
+				return err
+			}
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, record)
+		size += recordSize
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	return s.putRecords(ctx, chunk)
+}
+
+// putRecords sends a single PutRecords request.
+func (s *kinesisSink) putRecords(ctx context.Context, records []kinesisRecord) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"StreamName": s.config.StreamName,
+		"Records":    records,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Kinesis_20131202.PutRecords")
+	signAWSRequest(req, body, s.creds, s.config.Region, "kinesis", time.Now())
+
+	respBody, err := sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to put records: %v", err)
+	}
+
+	var resp struct {
+		FailedRecordCount int `json:"FailedRecordCount"`
+		Records           []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"Records"`
+	}
+	err = json.Unmarshal(respBody, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to decode put response: %v", err)
+	}
+	if resp.FailedRecordCount > 0 {
+		reason := ""
+		for _, r := range resp.Records {
+			if r.ErrorCode != "" {
+				reason = r.ErrorCode + ": " + r.ErrorMessage
+				break
+			}
+		}
+		return fmt.Errorf("failed to put %d of %d records: %s", resp.FailedRecordCount, len(records), reason)
+	}
+	return nil
+}
+
+// kinesisAggregator builds a KPL aggregated record:
+//
+//	magic | AggregatedRecord protobuf | md5(AggregatedRecord)
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records = 3;
+//	}
+//	message Record {
+//	  required uint64 partition_key_index = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes data = 3;
+//	}
+type kinesisAggregator struct {
+	keys     []string
+	keyIndex map[string]int
+	records  bytes.Buffer
+	n        int
+}
+
+// add appends a user record with the given partition key.
+func (a *kinesisAggregator) add(key string, data []byte) {
+	if a.keyIndex == nil {
+		a.keyIndex = map[string]int{}
+	}
+	index, ok := a.keyIndex[key]
+	if !ok {
+		index = len(a.keys)
+		a.keys = append(a.keys, key)
+		a.keyIndex[key] = index
+	}
+
+	var record bytes.Buffer
+	appendProtoUint(&record, 1, uint64(index))
+	appendProtoBytes(&record, 3, data)
+	appendProtoBytes(&a.records, 3, record.Bytes())
+	a.n++
+}
+
+// count returns the number of user records added.
+func (a *kinesisAggregator) count() int {
+	return a.n
+}
+
+// size estimates the encoded size of the aggregated record.
+func (a *kinesisAggregator) size() int {
+	size := len(kinesisAggregationMagic) + md5.Size + a.records.Len()
+	for _, key := range a.keys {
+		size += len(key) + 3
+	}
+	return size
+}
+
+// record returns the aggregated Kinesis record. Its partition key is the
+// key of the first user record, which determines the shard.
+func (a *kinesisAggregator) record() kinesisRecord {
+	var msg bytes.Buffer
+	for _, key := range a.keys {
+		appendProtoString(&msg, 1, key)
+	}
+	msg.Write(a.records.Bytes())
+
+	sum := md5.Sum(msg.Bytes())
+	data := make([]byte, 0, len(kinesisAggregationMagic)+msg.Len()+len(sum))
+	data = append(data, kinesisAggregationMagic...)
+	data = append(data, msg.Bytes()...)
+	data = append(data, sum[:]...)
+
+	return kinesisRecord{Data: data, PartitionKey: a.keys[0]}
+}
+
+// Close releases idle HTTP connections.
+func (s *kinesisSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,153 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("loki", newLokiSink, validateLokiConfig)
+}
+
+// LokiConfig holds the settings of the Grafana Loki sink.
+type LokiConfig struct {
+	URL      string `json:"url,omitempty"`
+	TenantID string `json:"tenantID,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Labels lists the record fields used as stream labels, e.g. host and
+	// method. Keep this to low-cardinality fields.
+	Labels []string `json:"labels,omitempty"`
+	// StaticLabels are added to every stream.
+	StaticLabels map[string]string `json:"staticLabels,omitempty"`
+}
+
+// validateLokiConfig checks the Loki settings.
+func validateLokiConfig(config *Config) error {
+	if config.Loki.URL == "" {
+		return fmt.Errorf("loki.url is required")
+	}
+	for _, label := range config.Loki.Labels {
+		if _, ok := templateFields[label]; !ok {
+			return fmt.Errorf("unsupported loki label %q", label)
+		}
+	}
+	return nil
+}
+
+// lokiSink pushes each request record as a JSON log line through the Loki
+// push API.
+type lokiSink struct {
+	client   *http.Client
+	endpoint string
+	config   LokiConfig
+}
+
+// newLokiSink creates the sink. No connection is made until the first write.
+func newLokiSink(config *Config) (Sink, error) {
+	err := validateLokiConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lokiSink{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: strings.TrimSuffix(config.Loki.URL, "/") + "/loki/api/v1/push",
+		config:   config.Loki,
+	}, nil
+}
+
+// lokiStream is a stream of the push API request body.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+
+	times []time.Time
+}
+
+// Len, Less and Swap sort the stream values by timestamp, which older Loki
+// versions require.
+func (s *lokiStream) Len() int           { return len(s.Values) }
+func (s *lokiStream) Less(i, j int) bool { return s.times[i].Before(s.times[j]) }
+func (s *lokiStream) Swap(i, j int) {
+	s.Values[i], s.Values[j] = s.Values[j], s.Values[i]
+	s.times[i], s.times[j] = s.times[j], s.times[i]
+}
+
+// Write groups the records into streams by label set and pushes them in a
+// single request.
+func (s *lokiSink) Write(ctx context.Context, batch []RequestData) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for i := range batch {
+		data := &batch[i]
+		labels := make(map[string]string, len(s.config.StaticLabels)+len(s.config.Labels))
+		for k, v := range s.config.StaticLabels {
+			labels[k] = v
+		}
+		var key strings.Builder
+		for _, name := range s.config.Labels {
+			value := templateFields[name](data)
+			labels[name] = value
+			key.WriteString(value)
+			key.WriteByte(0)
+		}
+
+		stream, ok := streams[key.String()]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key.String()] = stream
+			order = append(order, key.String())
+		}
+
+		line, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(data.Time.UnixNano(), 10), string(line)})
+		stream.times = append(stream.times, data.Time)
+	}
+
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range order {
+		sort.Sort(streams[key])
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.config.TenantID)
+	}
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to push logs: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *lokiSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,154 @@
+package traefik_analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	registerSink("mongodb", newMongoDBSink, validateMongoDBConfig)
+}
+
+// MongoDBConfig holds the settings of the MongoDB sink.
+type MongoDBConfig struct {
+	URI        string `json:"uri,omitempty"`
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// CollectionType controls how a missing collection is created: "" for a
+	// regular collection, "timeseries" for a time series collection keyed on
+	// request_time, or "capped" for a size-bounded collection.
+	CollectionType string `json:"collectionType,omitempty"`
+	// CappedSizeBytes is the maximum size of a capped collection.
+	CappedSizeBytes int64 `json:"cappedSizeBytes,omitempty"`
+	// ExpireAfterSeconds removes documents of a time series collection once
+	// they are older than this. Zero keeps them forever.
+	ExpireAfterSeconds int64 `json:"expireAfterSeconds,omitempty"`
+}
+
+// validateMongoDBConfig checks the MongoDB settings.
+func validateMongoDBConfig(config *Config) error {
+	c := config.MongoDB
+	switch {
+	case c.URI == "":
+		return fmt.Errorf("mongodb.uri is required")
+	case c.Database == "":
+		return fmt.Errorf("mongodb.database is required")
+	case c.Collection == "":
+		return fmt.Errorf("mongodb.collection is required")
+	}
+	switch c.CollectionType {
+	case "", "timeseries":
+	case "capped":
+		if c.CappedSizeBytes <= 0 {
+			return fmt.Errorf("mongodb.cappedSizeBytes is required for capped collections")
+		}
+	default:
+		return fmt.Errorf("unsupported mongodb.collectionType %q", c.CollectionType)
+	}
+	return nil
+}
+
+// mongoDBSink inserts request records as documents into a collection.
+// Documents use the same field names as the SQL columns.
+type mongoDBSink struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// newMongoDBSink connects to the server and creates the collection if it
+// does not exist yet.
+func newMongoDBSink(config *Config) (Sink, error) {
+	err := validateMongoDBConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientOptions := options.Client().
+		ApplyURI(config.MongoDB.URI).
+		SetAppName("traefik-analytics").
+		SetBSONOptions(&options.BSONOptions{UseJSONStructTags: true})
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	db := client.Database(config.MongoDB.Database)
+	err = ensureMongoDBCollection(ctx, db, config.MongoDB)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return &mongoDBSink{
+		client:     client,
+		collection: db.Collection(config.MongoDB.Collection),
+	}, nil
+}
+
+// ensureMongoDBCollection creates the configured collection unless it
+// already exists. Existing collections are used as they are.
+func ensureMongoDBCollection(ctx context.Context, db *mongo.Database, config MongoDBConfig) error {
+	names, err := db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: config.Collection}})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %v", err)
+	}
+	if len(names) > 0 {
+		return nil
+	}
+
+	create := options.CreateCollection()
+	switch config.CollectionType {
+	case "timeseries":
+		create.SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("request_time").
+			SetMetaField("host").
+			SetGranularity("seconds"))
+		if config.ExpireAfterSeconds > 0 {
+			create.SetExpireAfterSeconds(config.ExpireAfterSeconds)
+		}
+	case "capped":
+		create.SetCapped(true).SetSizeInBytes(config.CappedSizeBytes)
+	}
+
+	err = db.CreateCollection(ctx, config.Collection, create)
+	if err != nil {
+		return fmt.Errorf("failed to create collection: %v", err)
+	}
+	return nil
+}
+
+// Write inserts the records with a single unordered bulk insert, so one bad
+// document does not prevent the others from being stored.
+func (s *mongoDBSink) Write(ctx context.Context, batch []RequestData) error {
+	docs := make([]interface{}, len(batch))
+	for i := range batch {
+		docs[i] = &batch[i]
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("failed to insert data: %v", err)
+	}
+	return nil
+}
+
+// Close disconnects from the server.
+func (s *mongoDBSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
@@ -0,0 +1,110 @@
+package traefik_analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func init() {
+	registerSink("nats", newNATSSink, validateNATSConfig)
+}
+
+// NATSConfig holds the settings of the NATS JetStream sink.
+type NATSConfig struct {
+	URL string `json:"url,omitempty"`
+	// Subject is a template such as "analytics.{host}.{method}". Placeholder
+	// values have subject-reserved characters replaced by underscores.
+	Subject string `json:"subject,omitempty"`
+	// CredentialsFile is an optional path to a NATS .creds file.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+}
+
+// natsSubjectEscaper replaces characters that would split or wildcard a
+// subject token.
+var natsSubjectEscaper = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_")
+
+// validateNATSConfig checks the NATS settings.
+func validateNATSConfig(config *Config) error {
+	if config.NATS.URL == "" {
+		return fmt.Errorf("nats.url is required")
+	}
+	if config.NATS.Subject == "" {
+		return fmt.Errorf("nats.subject is required")
+	}
+	_, err := parseRecordTemplate(config.NATS.Subject, natsSubjectEscaper.Replace)
+	return err
+}
+
+// natsSink publishes request records as JSON messages to JetStream. Each
+// message is acknowledged by the stream bound to its subject.
+type natsSink struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject *recordTemplate
+}
+
+// newNATSSink connects to the NATS server.
+func newNATSSink(config *Config) (Sink, error) {
+	subject, err := parseRecordTemplate(config.NATS.Subject, natsSubjectEscaper.Replace)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []nats.Option{nats.Name("traefik-analytics")}
+	if config.NATS.CredentialsFile != "" {
+		options = append(options, nats.UserCredentials(config.NATS.CredentialsFile))
+	}
+
+	conn, err := nats.Connect(config.NATS.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %v", err)
+	}
+
+	return &natsSink{conn: conn, js: js, subject: subject}, nil
+}
+
+// Write publishes the records asynchronously and waits for all acks.
+func (s *natsSink) Write(ctx context.Context, batch []RequestData) error {
+	acks := make([]jetstream.PubAckFuture, 0, len(batch))
+	for i := range batch {
+		payload, err := json.Marshal(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		ack, err := s.js.PublishAsync(s.subject.expand(&batch[i]), payload)
+		if err != nil {
+			return fmt.Errorf("failed to publish data: %v", err)
+		}
+		acks = append(acks, ack)
+	}
+
+	var firstErr error
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to publish data: %v", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+// Close drains pending publishes and closes the connection.
+func (s *natsSink) Close() error {
+	return s.conn.Drain()
+}
@@ -0,0 +1,266 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("otlp", newOTLPSink, validateOTLPConfig)
+}
+
+// OTLPConfig holds the settings of the OpenTelemetry log exporter.
+type OTLPConfig struct {
+	// Endpoint is the OTLP/HTTP logs endpoint, e.g. "http://collector:4318/v1/logs".
+	Endpoint string `json:"endpoint,omitempty"`
+	// Headers are added to every export request, e.g. for authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// validateOTLPConfig checks the OTLP settings.
+func validateOTLPConfig(config *Config) error {
+	if config.OTLP.Endpoint == "" {
+		return fmt.Errorf("otlp.endpoint is required")
+	}
+	return nil
+}
+
+// otlpSink exports request records as OpenTelemetry log records over
+// OTLP/HTTP with JSON encoding. Record fields are mapped to the HTTP
+// semantic convention attributes.
+type otlpSink struct {
+	client   *http.Client
+	config   OTLPConfig
+	resource otlpResource
+}
+
+// newOTLPSink creates the exporter. No connection is made until the first write.
+func newOTLPSink(config *Config) (Sink, error) {
+	err := validateOTLPConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &otlpSink{
+		client: &http.Client{Timeout: 10 * time.Second},
+		config: config.OTLP,
+		resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpString("service.name", config.OTLP.ServiceName),
+		}},
+	}, nil
+}
+
+// The types below follow the protobuf JSON mapping of the OTLP logs
+// service, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	// IntValue is an int64 and therefore encoded as a string.
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId,omitempty"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpKeyValue {
+	s := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func otlpDouble(key string, value float64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{DoubleValue: &value}}
+}
+
+// otlpSeverityInfo is the INFO severity number of the logs data model.
+const otlpSeverityInfo = 9
+
+// logRecord maps a request record to an OTLP log record.
+func (s *otlpSink) logRecord(data *RequestData, observed string) otlpLogRecord {
+	body := data.Method + " " + data.Path
+	attrs := []otlpKeyValue{
+		otlpString("http.request.method", data.Method),
+		otlpString("url.path", data.Path),
+		otlpString("server.address", data.Host),
+		otlpString("client.address", data.IP),
+		otlpInt("http.response.status_code", int64(data.Status)),
+		otlpDouble("http.server.request.duration", data.ResponseTime.Seconds()),
+		otlpDouble("http.server.time_to_first_byte", data.TTFB.Seconds()),
+	}
+	if version := strings.TrimPrefix(data.Protocol, "HTTP/"); version != "" {
+		attrs = append(attrs, otlpString("network.protocol.version", version))
+	}
+	if version, found := strings.CutPrefix(data.TLSVersion, "TLS "); found {
+		attrs = append(attrs, otlpString("tls.protocol.name", "tls"))
+		attrs = append(attrs, otlpString("tls.protocol.version", version))
+		attrs = append(attrs, otlpString("tls.cipher", data.TLSCipher))
+	}
+	if data.TLSFingerprint != "" {
+		attrs = append(attrs, otlpString("tls.client.ja3", data.TLSFingerprint))
+	}
+	if data.Country != "" {
+		attrs = append(attrs, otlpString("geo.country.iso_code", data.Country))
+	}
+	if data.Region != "" {
+		attrs = append(attrs, otlpString("geo.region.name", data.Region))
+	}
+	if data.City != "" {
+		attrs = append(attrs, otlpString("geo.locality.name", data.City))
+	}
+	if data.ASN != 0 {
+		attrs = append(attrs, otlpInt("as.number", int64(data.ASN)))
+		attrs = append(attrs, otlpString("as.organization.name", data.ASOrg))
+	}
+	if data.ClientPort > 0 {
+		attrs = append(attrs, otlpInt("client.port", int64(data.ClientPort)))
+	}
+	if data.GRPCService != "" {
+		attrs = append(attrs,
+			otlpString("rpc.system", "grpc"),
+			otlpString("rpc.service", data.GRPCService),
+			otlpString("rpc.method", data.GRPCMethod),
+		)
+	}
+	if data.GRPCStatus != nil {
+		attrs = append(attrs, otlpInt("rpc.grpc.status_code", int64(*data.GRPCStatus)))
+	}
+	if data.QueryString != "" {
+		attrs = append(attrs, otlpString("url.query", data.QueryString))
+	}
+	if data.Router != "" {
+		attrs = append(attrs, otlpString("traefik.router.name", data.Router))
+	}
+	if data.Service != "" {
+		attrs = append(attrs, otlpString("traefik.service.name", data.Service))
+	}
+	if data.EntryPoint != "" {
+		attrs = append(attrs, otlpString("traefik.entrypoint.name", data.EntryPoint))
+	}
+	if data.Upstream != "" {
+		attrs = append(attrs, otlpString("traefik.upstream.address", data.Upstream))
+	}
+	if data.UserAgent != "" {
+		attrs = append(attrs, otlpString("user_agent.original", data.UserAgent))
+	}
+	if data.Referer != "" {
+		attrs = append(attrs, otlpString("http.request.header.referer", data.Referer))
+	}
+	if data.AcceptLanguage != "" {
+		attrs = append(attrs, otlpString("http.request.header.accept-language", data.AcceptLanguage))
+	}
+	if data.ContentType != "" {
+		attrs = append(attrs, otlpString("http.request.header.content-type", data.ContentType))
+	}
+	for name, value := range data.Headers {
+		attrs = append(attrs, otlpString("http.request.header."+name, value))
+	}
+	for name, value := range data.ResponseHeaders {
+		attrs = append(attrs, otlpString("http.response.header."+name, value))
+	}
+	for name, value := range data.Extra {
+		attrs = append(attrs, otlpString("extra."+name, value))
+	}
+	if data.ContentLength >= 0 {
+		attrs = append(attrs, otlpInt("http.request.body.size", data.ContentLength))
+	}
+	attrs = append(attrs, otlpInt("http.response.body.size", data.ResponseSize))
+	if data.ContentEncoding != "" {
+		attrs = append(attrs, otlpString("http.response.header.content-encoding", data.ContentEncoding))
+	}
+	if data.UncompressedSize != nil {
+		attrs = append(attrs, otlpInt("http.response.body.uncompressed_size", *data.UncompressedSize))
+	}
+
+	// Only a W3C trace ID can link the record to a trace; anything else,
+	// such as a request ID, is kept as an attribute.
+	var traceID string
+	if isTraceID(data.TraceID) {
+		traceID = data.TraceID
+	} else if data.TraceID != "" && data.TraceID != data.RequestID {
+		attrs = append(attrs, otlpString("correlation.id", data.TraceID))
+	}
+	if data.RequestID != "" {
+		attrs = append(attrs, otlpString("http.request.id", data.RequestID))
+	}
+
+	return otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(data.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: observed,
+		SeverityNumber:       otlpSeverityInfo,
+		SeverityText:         "INFO",
+		Body:                 otlpAnyValue{StringValue: &body},
+		Attributes:           attrs,
+		TraceID:              traceID,
+	}
+}
+
+// Write exports the records with a single request.
+func (s *otlpSink) Write(ctx context.Context, batch []RequestData) error {
+	observed := strconv.FormatInt(time.Now().UnixNano(), 10)
+	records := make([]otlpLogRecord, len(batch))
+	for i := range batch {
+		records[i] = s.logRecord(&batch[i], observed)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": s.resource,
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]string{"name": "traefik-analytics"},
+				"logRecords": records,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to export logs: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *otlpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,259 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+func init() {
+	registerSink("prometheus", newPrometheusSink, validatePrometheusConfig)
+}
+
+// PrometheusConfig holds the settings of the Prometheus remote-write output.
+type PrometheusConfig struct {
+	// URL is the remote-write endpoint, e.g. "http://prometheus:9090/api/v1/write".
+	URL         string `json:"url,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	BearerToken string `json:"bearerToken,omitempty"`
+	// PushInterval is how often the aggregated series are sent, as a Go
+	// duration string.
+	PushInterval string `json:"pushInterval,omitempty"`
+	// Buckets are the upper bounds, in seconds, of the latency histogram.
+	Buckets []float64 `json:"buckets,omitempty"`
+	// ExternalLabels are attached to every series, e.g. {"instance": "edge-1"}.
+	ExternalLabels map[string]string `json:"externalLabels,omitempty"`
+}
+
+// validatePrometheusConfig checks the Prometheus settings.
+func validatePrometheusConfig(config *Config) error {
+	c := config.Prometheus
+	if c.URL == "" {
+		return fmt.Errorf("prometheus.url is required")
+	}
+	interval, err := time.ParseDuration(c.PushInterval)
+	if err != nil {
+		return fmt.Errorf("invalid prometheus.pushInterval: %v", err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("prometheus.pushInterval must be positive")
+	}
+	if !sort.Float64sAreSorted(c.Buckets) {
+		return fmt.Errorf("prometheus.buckets must be sorted in increasing order")
+	}
+	return nil
+}
+
+// prometheusSeriesKey identifies the label set of aggregated metrics.
+type prometheusSeriesKey struct {
+	host    string
+	method  string
+	code    string
+	service string
+}
+
+// prometheusSeries holds the cumulative metrics of one label set.
+type prometheusSeries struct {
+	count   uint64
+	sum     float64
+	bytes   uint64
+	buckets []uint64
+}
+
+// prometheusSink aggregates request counts, response bytes and latency
+// histograms in memory and periodically pushes them with the Prometheus
+// remote-write protocol. Values are cumulative since the sink was created,
+// as for any other Prometheus counter.
+type prometheusSink struct {
+	client  *http.Client
+	config  PrometheusConfig
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[prometheusSeriesKey]*prometheusSeries
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newPrometheusSink creates the sink and starts the periodic push.
+func newPrometheusSink(config *Config) (Sink, error) {
+	err := validatePrometheusConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := time.ParseDuration(config.Prometheus.PushInterval)
+
+	s := &prometheusSink{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		config:  config.Prometheus,
+		buckets: config.Prometheus.Buckets,
+		series:  map[prometheusSeriesKey]*prometheusSeries{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.pushLoop(interval)
+
+	return s, nil
+}
+
+// Write adds the records to the aggregated metrics.
+func (s *prometheusSink) Write(ctx context.Context, batch []RequestData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range batch {
+		data := &batch[i]
+		key := prometheusSeriesKey{host: data.Host, method: data.Method, code: strconv.Itoa(data.Status), service: data.Service}
+		series, ok := s.series[key]
+		if !ok {
+			series = &prometheusSeries{buckets: make([]uint64, len(s.buckets))}
+			s.series[key] = series
+		}
+
+		seconds := data.ResponseTime.Seconds()
+		series.count++
+		series.sum += seconds
+		series.bytes += uint64(data.ResponseSize)
+		for j, bound := range s.buckets {
+			if seconds <= bound {
+				series.buckets[j]++
+			}
+		}
+	}
+	return nil
+}
+
+// pushLoop pushes the metrics every interval until the sink is closed.
+func (s *prometheusSink) pushLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.push(context.Background())
+			if err != nil {
+				log.Printf("Failed to push analytics metrics: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// push sends the current value of every series.
+func (s *prometheusSink) push(ctx context.Context) error {
+	timestamp := time.Now().UnixMilli()
+
+	var payload bytes.Buffer
+	s.mu.Lock()
+	for key, series := range s.series {
+		labels := []prometheusLabel{{"host", key.host}, {"method", key.method}, {"code", key.code}, {"service", key.service}}
+
+		appendPrometheusSeries(&payload, s.labels("analytics_requests_total", labels), float64(series.count), timestamp)
+		appendPrometheusSeries(&payload, s.labels("analytics_response_size_bytes_total", labels), float64(series.bytes), timestamp)
+		appendPrometheusSeries(&payload, s.labels("analytics_request_duration_seconds_count", labels), float64(series.count), timestamp)
+		appendPrometheusSeries(&payload, s.labels("analytics_request_duration_seconds_sum", labels), series.sum, timestamp)
+		for i, bound := range s.buckets {
+			le := prometheusLabel{"le", strconv.FormatFloat(bound, 'g', -1, 64)}
+			appendPrometheusSeries(&payload, s.labels("analytics_request_duration_seconds_bucket", append(labels, le)), float64(series.buckets[i]), timestamp)
+		}
+		inf := prometheusLabel{"le", "+Inf"}
+		appendPrometheusSeries(&payload, s.labels("analytics_request_duration_seconds_bucket", append(labels, inf)), float64(series.count), timestamp)
+	}
+	s.mu.Unlock()
+
+	if payload.Len() == 0 {
+		return nil
+	}
+
+	body := snappy.Encode(nil, payload.Bytes())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case s.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+s.config.BearerToken)
+	case s.config.Username != "":
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	_, err = sendRequest(s.client, req)
+	return err
+}
+
+// prometheusLabel is a name/value pair of a series.
+type prometheusLabel struct {
+	name, value string
+}
+
+// labels returns the full, sorted label set of a series named metric.
+func (s *prometheusSink) labels(metric string, labels []prometheusLabel) []prometheusLabel {
+	all := make([]prometheusLabel, 0, len(labels)+len(s.config.ExternalLabels)+1)
+	all = append(all, prometheusLabel{"__name__", metric})
+	for _, label := range labels {
+		// An empty value is the same as an absent label.
+		if label.value != "" {
+			all = append(all, label)
+		}
+	}
+	for name, value := range s.config.ExternalLabels {
+		all = append(all, prometheusLabel{name, value})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
+
+// appendPrometheusSeries appends a TimeSeries with a single sample as field
+// 1 of the remote-write WriteRequest protobuf message:
+//
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label      { string name = 1; string value = 2; }
+//	message Sample     { double value = 1; int64 timestamp = 2; }
+func appendPrometheusSeries(buf *bytes.Buffer, labels []prometheusLabel, value float64, timestamp int64) {
+	var series bytes.Buffer
+	for _, label := range labels {
+		var l bytes.Buffer
+		appendProtoString(&l, 1, label.name)
+		appendProtoString(&l, 2, label.value)
+		appendProtoBytes(&series, 1, l.Bytes())
+	}
+
+	var sample bytes.Buffer
+	sample.WriteByte(1<<3 | 1) // field 1, 64-bit
+	binary.Write(&sample, binary.LittleEndian, math.Float64bits(value))
+	sample.WriteByte(2<<3 | 0) // field 2, varint
+	appendProtoVarint(&sample, uint64(timestamp))
+	appendProtoBytes(&series, 2, sample.Bytes())
+
+	appendProtoBytes(buf, 1, series.Bytes())
+}
+
+// Close stops the periodic push and sends the final values.
+func (s *prometheusSink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := s.push(ctx)
+	s.client.CloseIdleConnections()
+	return err
+}
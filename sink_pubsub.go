@@ -0,0 +1,173 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("pubsub", newPubSubSink, validatePubSubConfig)
+}
+
+// PubSubConfig holds the settings of the Google Cloud Pub/Sub publisher.
+type PubSubConfig struct {
+	ProjectID string `json:"projectID,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	// Endpoint is the Pub/Sub API endpoint. Ordered delivery requires a
+	// regional endpoint such as "https://europe-west1-pubsub.googleapis.com".
+	Endpoint string `json:"endpoint,omitempty"`
+	// OrderingKey selects the record field used as ordering key, e.g. "ip".
+	// Messages are published without ordering key when empty; the
+	// subscription must have message ordering enabled for it to matter.
+	OrderingKey string `json:"orderingKey,omitempty"`
+	// MaxMessages and MaxBytes split large batches into several publish
+	// requests. The service allows at most 1000 messages and 10MB.
+	MaxMessages int `json:"maxMessages,omitempty"`
+	MaxBytes    int `json:"maxBytes,omitempty"`
+	// CredentialsFile is an optional service account key file. By default
+	// Application Default Credentials are used.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+}
+
+// validatePubSubConfig checks the Pub/Sub settings.
+func validatePubSubConfig(config *Config) error {
+	c := config.PubSub
+	switch {
+	case c.ProjectID == "":
+		return fmt.Errorf("pubsub.projectID is required")
+	case c.Topic == "":
+		return fmt.Errorf("pubsub.topic is required")
+	case c.Endpoint == "":
+		return fmt.Errorf("pubsub.endpoint is required")
+	case c.MaxMessages <= 0 || c.MaxMessages > 1000:
+		return fmt.Errorf("pubsub.maxMessages must be between 1 and 1000")
+	case c.MaxBytes <= 0:
+		return fmt.Errorf("pubsub.maxBytes must be positive")
+	}
+	if c.OrderingKey != "" {
+		if _, ok := templateFields[c.OrderingKey]; !ok {
+			return fmt.Errorf("unsupported pubsub.orderingKey %q", c.OrderingKey)
+		}
+	}
+	return nil
+}
+
+// pubSubSink publishes request records as JSON messages to a Pub/Sub topic
+// through the REST API.
+type pubSubSink struct {
+	client      *http.Client
+	tokens      *googleTokenSource
+	endpoint    string
+	config      PubSubConfig
+	orderingKey func(data *RequestData) string
+}
+
+// newPubSubSink loads the credentials. No connection is made until the
+// first write.
+func newPubSubSink(config *Config) (Sink, error) {
+	err := validatePubSubConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := newGoogleTokenSource(config.PubSub.CredentialsFile, []string{
+		"https://www.googleapis.com/auth/pubsub",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := config.PubSub
+	s := &pubSubSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		tokens: tokens,
+		endpoint: strings.TrimSuffix(c.Endpoint, "/") + "/v1/projects/" + url.PathEscape(c.ProjectID) +
+			"/topics/" + url.PathEscape(c.Topic) + ":publish",
+		config: c,
+	}
+	if c.OrderingKey != "" {
+		s.orderingKey = templateFields[c.OrderingKey]
+	}
+	return s, nil
+}
+
+// pubSubMessage is a message of the publish request. Data is base64
+// encoded by encoding/json.
+type pubSubMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// Write publishes the records, splitting them into requests that respect
+// the configured batching limits.
+func (s *pubSubSink) Write(ctx context.Context, batch []RequestData) error {
+	var messages []pubSubMessage
+	size := 0
+	for i := range batch {
+		data, err := json.Marshal(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		msg := pubSubMessage{
+			Data:       data,
+			Attributes: map[string]string{"host": batch[i].Host},
+		}
+		if s.orderingKey != nil {
+			msg.OrderingKey = s.orderingKey(&batch[i])
+		}
+
+		// Base64 grows the payload by a third.
+		msgSize := len(data)*4/3 + len(msg.OrderingKey) + 64
+		if len(messages) > 0 && (len(messages) >= s.config.MaxMessages || size+msgSize > s.config.MaxBytes) {
+			err = s.publish(ctx, messages)
+			if err != nil {
+				return err
+			}
+			messages, size = nil, 0
+		}
+		messages = append(messages, msg)
+		size += msgSize
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	return s.publish(ctx, messages)
+}
+
+// publish sends a single publish request.
+func (s *pubSubSink) publish(ctx context.Context, messages []pubSubMessage) error {
+	body, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = s.tokens.authorize(req)
+	if err != nil {
+		return err
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to publish %d messages: %v", len(messages), err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *pubSubSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
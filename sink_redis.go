@@ -0,0 +1,247 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerSink("redis", newRedisSink, validateRedisConfig)
+}
+
+// RedisConfig holds the settings of the Redis Streams sink.
+type RedisConfig struct {
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	TLS      bool   `json:"tls,omitempty"`
+	Stream   string `json:"stream,omitempty"`
+	// MaxLen approximately caps the stream length with XADD MAXLEN ~.
+	// Zero leaves the stream unbounded.
+	MaxLen int64 `json:"maxLen,omitempty"`
+}
+
+// validateRedisConfig checks the Redis settings.
+func validateRedisConfig(config *Config) error {
+	if config.Redis.Address == "" {
+		return fmt.Errorf("redis.address is required")
+	}
+	if config.Redis.Stream == "" {
+		return fmt.Errorf("redis.stream is required")
+	}
+	return nil
+}
+
+// redisSink appends request records to a Redis Stream with XADD. Each entry
+// has a single "data" field holding the JSON-encoded record.
+//
+// The sink speaks RESP directly over one connection and pipelines the
+// commands of a batch. A broken connection is dropped and redialed on the
+// next write.
+type redisSink struct {
+	config RedisConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// newRedisSink connects to the server.
+func newRedisSink(config *Config) (Sink, error) {
+	err := validateRedisConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &redisSink{config: config.Redis}
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the server, authenticates and selects the database.
+func (s *redisSink) connect() error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.config.Address, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", s.config.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %v", err)
+	}
+
+	s.conn = conn
+	s.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	var setup [][]string
+	if s.config.Password != "" {
+		if s.config.Username != "" {
+			setup = append(setup, []string{"AUTH", s.config.Username, s.config.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.config.Password})
+		}
+	}
+	if s.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.config.DB)})
+	}
+	for _, cmd := range setup {
+		err = s.roundTrip([][]string{cmd}, time.Now().Add(5*time.Second))
+		if err != nil {
+			s.disconnect()
+			return fmt.Errorf("failed to set up redis connection: %v", err)
+		}
+	}
+	return nil
+}
+
+// disconnect drops the current connection.
+func (s *redisSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.rw = nil
+	}
+}
+
+// Write pipelines one XADD per record.
+func (s *redisSink) Write(ctx context.Context, batch []RequestData) error {
+	cmds := make([][]string, len(batch))
+	for i := range batch {
+		payload, err := json.Marshal(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		cmd := []string{"XADD", s.config.Stream}
+		if s.config.MaxLen > 0 {
+			cmd = append(cmd, "MAXLEN", "~", strconv.FormatInt(s.config.MaxLen, 10))
+		}
+		cmds[i] = append(cmd, "*", "data", string(payload))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	err := s.roundTrip(cmds, deadline)
+	if err != nil {
+		if _, isReply := err.(redisError); !isReply {
+			s.disconnect()
+		}
+		return fmt.Errorf("failed to add data to stream: %v", err)
+	}
+	return nil
+}
+
+// redisError is an error reply sent by the server. The connection is still
+// usable after receiving one.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// roundTrip sends the commands and reads one reply per command. The first
+// error reply is returned after all replies have been consumed.
+func (s *redisSink) roundTrip(cmds [][]string, deadline time.Time) error {
+	err := s.conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		fmt.Fprintf(s.rw, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(s.rw, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	err = s.rw.Flush()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for range cmds {
+		err = s.readReply()
+		if err != nil {
+			if _, isReply := err.(redisError); !isReply {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// readReply consumes a single RESP reply, discarding its value.
+func (s *redisSink) readReply() error {
+	line, err := s.rw.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return fmt.Errorf("malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(payload)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return fmt.Errorf("malformed reply %q", line)
+		}
+		if n < 0 {
+			return nil
+		}
+		_, err = s.rw.Discard(n + 2)
+		return err
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return fmt.Errorf("malformed reply %q", line)
+		}
+		for i := 0; i < n; i++ {
+			err = s.readReply()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+// Close closes the connection.
+func (s *redisSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnect()
+	return nil
+}
@@ -0,0 +1,375 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func init() {
+	registerSink("s3", newS3Sink, validateS3Config)
+}
+
+// S3Config holds the settings of the S3 Parquet archival sink.
+type S3Config struct {
+	// Endpoint is the S3 API endpoint, e.g. "https://s3.eu-west-1.amazonaws.com"
+	// or the URL of an S3-compatible store such as MinIO.
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	// PathStyle addresses the bucket as endpoint/bucket instead of as a
+	// virtual host, as most S3-compatible stores require.
+	PathStyle bool `json:"pathStyle,omitempty"`
+	// AccessKeyID, SecretAccessKey and SessionToken default to the
+	// standard AWS_* environment variables.
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+	// KeyPrefix is the object key prefix. It may use the {year}, {month},
+	// {day} and {hour} placeholders, filled in from the oldest record of
+	// each file in UTC.
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+	// FlushInterval is the maximum time records are buffered before being
+	// uploaded, as a Go duration string.
+	FlushInterval string `json:"flushInterval,omitempty"`
+	// MaxRecords uploads a file as soon as this many records are buffered.
+	MaxRecords int `json:"maxRecords,omitempty"`
+}
+
+// validateS3Config checks the S3 settings.
+func validateS3Config(config *Config) error {
+	c := config.S3
+	switch {
+	case c.Endpoint == "":
+		return fmt.Errorf("s3.endpoint is required")
+	case c.Region == "":
+		return fmt.Errorf("s3.region is required")
+	case c.Bucket == "":
+		return fmt.Errorf("s3.bucket is required")
+	case c.MaxRecords <= 0:
+		return fmt.Errorf("s3.maxRecords must be positive")
+	}
+	interval, err := time.ParseDuration(c.FlushInterval)
+	if err != nil {
+		return fmt.Errorf("invalid s3.flushInterval: %v", err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("s3.flushInterval must be positive")
+	}
+	return nil
+}
+
+// parquetRecord is the Parquet schema of the archived files.
+type parquetRecord struct {
+	IP                  string            `parquet:"ip,dict"`
+	UserAgent           string            `parquet:"user_agent,dict"`
+	Path                string            `parquet:"path"`
+	RequestTime         time.Time         `parquet:"request_time,timestamp(microsecond)"`
+	Method              string            `parquet:"method,dict"`
+	Protocol            string            `parquet:"protocol,dict"`
+	Host                string            `parquet:"host,dict"`
+	AcceptLanguage      string            `parquet:"accept_language,dict"`
+	Referer             string            `parquet:"referer"`
+	ContentType         string            `parquet:"content_type,dict"`
+	ContentLength       int64             `parquet:"content_length"`
+	ResponseTime        int64             `parquet:"response_time"`
+	ResponseContentType string            `parquet:"response_content_type,dict"`
+	Status              int32             `parquet:"status"`
+	ResponseSize        int64             `parquet:"response_size"`
+	Router              string            `parquet:"router,dict"`
+	Service             string            `parquet:"service,dict"`
+	EntryPoint          string            `parquet:"entrypoint,dict"`
+	QueryString         string            `parquet:"query_string"`
+	Headers             map[string]string `parquet:"headers"`
+	Cookies             map[string]string `parquet:"cookies"`
+	TraceID             string            `parquet:"trace_id"`
+	RequestID           string            `parquet:"request_id"`
+	Upgrade             string            `parquet:"upgrade,dict"`
+	TunnelBytesReceived int64             `parquet:"tunnel_bytes_received"`
+	TunnelBytesSent     int64             `parquet:"tunnel_bytes_sent"`
+	GRPCService         string            `parquet:"grpc_service,dict"`
+	GRPCMethod          string            `parquet:"grpc_method,dict"`
+	GRPCStatus          *int32            `parquet:"grpc_status,optional"`
+	ClientPort          int32             `parquet:"client_port"`
+	ConnectionReused    bool              `parquet:"connection_reused"`
+	ResponseHeaders     map[string]string `parquet:"response_headers"`
+	TTFB                int64             `parquet:"ttfb"`
+	Upstream            string            `parquet:"upstream,dict"`
+	ContentEncoding     string            `parquet:"content_encoding,dict"`
+	UncompressedSize    *int64            `parquet:"uncompressed_size,optional"`
+	Country             string            `parquet:"country,dict"`
+	Region              string            `parquet:"region,dict"`
+	City                string            `parquet:"city,dict"`
+	ASN                 int64             `parquet:"asn"`
+	ASOrg               string            `parquet:"as_org,dict"`
+	Browser             string            `parquet:"browser,dict"`
+	BrowserVersion      string            `parquet:"browser_version,dict"`
+	OS                  string            `parquet:"os,dict"`
+	OSVersion           string            `parquet:"os_version,dict"`
+	DeviceType          string            `parquet:"device_type,dict"`
+	IsBot               bool              `parquet:"is_bot"`
+	BotName             string            `parquet:"bot_name,dict"`
+	RDNS                string            `parquet:"rdns"`
+	UTMSource           string            `parquet:"utm_source,dict"`
+	UTMMedium           string            `parquet:"utm_medium,dict"`
+	UTMCampaign         string            `parquet:"utm_campaign,dict"`
+	UTMTerm             string            `parquet:"utm_term,dict"`
+	UTMContent          string            `parquet:"utm_content,dict"`
+	ReferrerDomain      string            `parquet:"referrer_domain,dict"`
+	ReferrerType        string            `parquet:"referrer_type,dict"`
+	Language            string            `parquet:"language,dict"`
+	IsDatacenter        bool              `parquet:"is_datacenter"`
+	IsVPN               bool              `parquet:"is_vpn"`
+	IsTor               bool              `parquet:"is_tor"`
+	VisitorID           string            `parquet:"visitor_id"`
+	SessionID           string            `parquet:"session_id"`
+	TLSVersion          string            `parquet:"tls_version,dict"`
+	TLSCipher           string            `parquet:"tls_cipher,dict"`
+	TLSFingerprint      string            `parquet:"tls_fingerprint,dict"`
+	RawPath             string            `parquet:"raw_path,optional"`
+	Extra               map[string]string `parquet:"extra"`
+}
+
+// s3Sink buffers request records and periodically uploads them as Parquet
+// files to S3-compatible object storage.
+type s3Sink struct {
+	client *http.Client
+	config S3Config
+	creds  awsCredentials
+
+	mu     sync.Mutex
+	buffer []parquetRecord
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newS3Sink creates the sink and starts the periodic flush.
+func newS3Sink(config *Config) (Sink, error) {
+	err := validateS3Config(config)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := time.ParseDuration(config.S3.FlushInterval)
+
+	s := &s3Sink{
+		client: &http.Client{Timeout: 60 * time.Second},
+		config: config.S3,
+		creds: awsCredentialsFromEnv(awsCredentials{
+			AccessKeyID:     config.S3.AccessKeyID,
+			SecretAccessKey: config.S3.SecretAccessKey,
+			SessionToken:    config.S3.SessionToken,
+		}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.flushLoop(interval)
+
+	return s, nil
+}
+
+// flushLoop uploads the buffer every interval until the sink is closed.
+func (s *s3Sink) flushLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.flush(context.Background())
+			if err != nil {
+				log.Printf("Failed to upload analytics to S3: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Write buffers the records and uploads them once MaxRecords is reached.
+func (s *s3Sink) Write(ctx context.Context, batch []RequestData) error {
+	s.mu.Lock()
+	for i := range batch {
+		data := &batch[i]
+		s.buffer = append(s.buffer, parquetRecord{
+			IP:                  data.IP,
+			UserAgent:           data.UserAgent,
+			Path:                data.Path,
+			RequestTime:         data.Time,
+			Method:              data.Method,
+			Protocol:            data.Protocol,
+			Host:                data.Host,
+			AcceptLanguage:      data.AcceptLanguage,
+			Referer:             data.Referer,
+			ContentType:         data.ContentType,
+			ContentLength:       data.ContentLength,
+			ResponseTime:        int64(data.ResponseTime),
+			ResponseContentType: data.ResponseContentType,
+			Status:              int32(data.Status),
+			ResponseSize:        data.ResponseSize,
+			Router:              data.Router,
+			Service:             data.Service,
+			EntryPoint:          data.EntryPoint,
+			QueryString:         data.QueryString,
+			Headers:             data.Headers,
+			Cookies:             data.Cookies,
+			TraceID:             data.TraceID,
+			RequestID:           data.RequestID,
+			Upgrade:             data.Upgrade,
+			TunnelBytesReceived: data.TunnelBytesReceived,
+			TunnelBytesSent:     data.TunnelBytesSent,
+			GRPCService:         data.GRPCService,
+			GRPCMethod:          data.GRPCMethod,
+			GRPCStatus:          optionalInt32(data.GRPCStatus),
+			ClientPort:          int32(data.ClientPort),
+			ConnectionReused:    data.ConnectionReused,
+			ResponseHeaders:     data.ResponseHeaders,
+			TTFB:                int64(data.TTFB),
+			Upstream:            data.Upstream,
+			ContentEncoding:     data.ContentEncoding,
+			UncompressedSize:    data.UncompressedSize,
+			Country:             data.Country,
+			Region:              data.Region,
+			City:                data.City,
+			ASN:                 int64(data.ASN),
+			ASOrg:               data.ASOrg,
+			Browser:             data.Browser,
+			BrowserVersion:      data.BrowserVersion,
+			OS:                  data.OS,
+			OSVersion:           data.OSVersion,
+			DeviceType:          data.DeviceType,
+			IsBot:               data.IsBot,
+			BotName:             data.BotName,
+			RDNS:                data.RDNS,
+			UTMSource:           data.UTMSource,
+			UTMMedium:           data.UTMMedium,
+			UTMCampaign:         data.UTMCampaign,
+			UTMTerm:             data.UTMTerm,
+			UTMContent:          data.UTMContent,
+			ReferrerDomain:      data.ReferrerDomain,
+			ReferrerType:        data.ReferrerType,
+			Language:            data.Language,
+			IsDatacenter:        data.IsDatacenter,
+			IsVPN:               data.IsVPN,
+			IsTor:               data.IsTor,
+			VisitorID:           data.VisitorID,
+			SessionID:           data.SessionID,
+			TLSVersion:          data.TLSVersion,
+			TLSCipher:           data.TLSCipher,
+			TLSFingerprint:      data.TLSFingerprint,
+			RawPath:             data.RawPath,
+			Extra:               data.Extra,
+		})
+	}
+	full := len(s.buffer) >= s.config.MaxRecords
+	s.mu.Unlock()
+
+	if full {
+		return s.flush(ctx)
+	}
+	return nil
+}
+
+// flush uploads the buffered records as one Parquet file. Records of a
+// failed upload are dropped.
+func (s *s3Sink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	records := s.buffer
+	s.buffer = nil
+	s.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	writer := parquet.NewGenericWriter[parquetRecord](&body, parquet.Compression(&parquet.Snappy))
+	_, err := writer.Write(records)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode parquet file: %v", err)
+	}
+
+	key := s.objectKey(records[0].RequestTime)
+	err = s.putObject(ctx, key, body.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to upload %s (%d records): %v", key, len(records), err)
+	}
+	return nil
+}
+
+// objectKey returns a unique key below the prefix expanded for t.
+func (s *s3Sink) objectKey(t time.Time) string {
+	t = t.UTC()
+	prefix := strings.NewReplacer(
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+		"{hour}", t.Format("15"),
+	).Replace(s.config.KeyPrefix)
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + hex.EncodeToString(suffix) + ".parquet"
+
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		return prefix + "/" + name
+	}
+	return name
+}
+
+// putObject uploads body under key with a SigV4-signed PUT request.
+func (s *s3Sink) putObject(ctx context.Context, key string, body []byte) error {
+	endpoint := strings.TrimSuffix(s.config.Endpoint, "/")
+	var target string
+	if s.config.PathStyle {
+		target = endpoint + "/" + s.config.Bucket + "/" + key
+	} else {
+		scheme, host, _ := strings.Cut(endpoint, "://")
+		target = scheme + "://" + s.config.Bucket + "." + host + "/" + key
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.apache.parquet")
+	signAWSRequest(req, body, s.creds, s.config.Region, "s3", time.Now())
+
+	_, err = sendRequest(s.client, req)
+	return err
+}
+
+// Close stops the periodic flush and uploads the remaining records.
+func (s *s3Sink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	err := s.flush(ctx)
+	s.client.CloseIdleConnections()
+	return err
+}
+
+// optionalInt32 converts an optional int for an optional Parquet column.
+func optionalInt32(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	i := int32(*v)
+	return &i
+}
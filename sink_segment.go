@@ -0,0 +1,226 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("segment", newSegmentSink, validateSegmentConfig)
+}
+
+// SegmentConfig holds the settings of the Segment forwarding sink.
+type SegmentConfig struct {
+	WriteKey string `json:"writeKey,omitempty"`
+	// Endpoint is the tracking API, e.g. "https://events.eu1.segmentapis.com"
+	// for the EU workspace region.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Event is the name of the track event sent for every request.
+	Event        string `json:"event,omitempty"`
+	MaxRetries   int    `json:"maxRetries,omitempty"`
+	RetryBackoff string `json:"retryBackoff,omitempty"`
+}
+
+// Segment batch limits, see https://segment.com/docs/connections/sources/catalog/libraries/server/http-api/#batch
+const (
+	segmentMaxBatchBytes = 500 << 10
+	segmentMaxEventBytes = 32 << 10
+)
+
+// validateSegmentConfig checks the Segment settings.
+func validateSegmentConfig(config *Config) error {
+	c := config.Segment
+	switch {
+	case c.WriteKey == "":
+		return fmt.Errorf("segment.writeKey is required")
+	case c.Endpoint == "":
+		return fmt.Errorf("segment.endpoint is required")
+	case c.Event == "":
+		return fmt.Errorf("segment.event is required")
+	}
+	_, err := time.ParseDuration(c.RetryBackoff)
+	if err != nil {
+		return fmt.Errorf("invalid segment.retryBackoff: %v", err)
+	}
+	return nil
+}
+
+// segmentSink forwards each request as a Segment track call through the
+// batch endpoint of the HTTP tracking API.
+type segmentSink struct {
+	client   *http.Client
+	config   SegmentConfig
+	endpoint string
+	backoff  time.Duration
+}
+
+// newSegmentSink creates the sink. No connection is made until the first write.
+func newSegmentSink(config *Config) (Sink, error) {
+	err := validateSegmentConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	backoff, _ := time.ParseDuration(config.Segment.RetryBackoff)
+
+	return &segmentSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		config:   config.Segment,
+		endpoint: strings.TrimSuffix(config.Segment.Endpoint, "/") + "/v1/batch",
+		backoff:  backoff,
+	}, nil
+}
+
+// segmentEvent is a track call of the batch request.
+type segmentEvent struct {
+	Type        string                 `json:"type"`
+	Event       string                 `json:"event"`
+	MessageID   string                 `json:"messageId"`
+	AnonymousID string                 `json:"anonymousId"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Properties  map[string]interface{} `json:"properties"`
+	Context     map[string]interface{} `json:"context"`
+}
+
+// event maps a record to a track call. Without a user identity the
+// anonymous ID is a hash of IP and User-Agent, so no raw address is sent as
+// an identifier.
+func (s *segmentSink) event(data *RequestData) segmentEvent {
+	sum := sha256.Sum256([]byte(data.IP + "|" + data.UserAgent))
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	page := map[string]string{
+		"path":     data.Path,
+		"referrer": data.Referer,
+		"url":      pageURL(data),
+	}
+	if data.QueryString != "" {
+		page["search"] = "?" + data.QueryString
+	}
+	campaign := map[string]string{}
+	for key, value := range map[string]string{
+		"source":  data.UTMSource,
+		"medium":  data.UTMMedium,
+		"name":    data.UTMCampaign,
+		"term":    data.UTMTerm,
+		"content": data.UTMContent,
+	} {
+		if value != "" {
+			campaign[key] = value
+		}
+	}
+
+	return segmentEvent{
+		Type:        "track",
+		Event:       s.config.Event,
+		MessageID:   hex.EncodeToString(id),
+		AnonymousID: hex.EncodeToString(sum[:16]),
+		Timestamp:   data.Time,
+		Properties: map[string]interface{}{
+			"host":             data.Host,
+			"path":             data.Path,
+			"method":           data.Method,
+			"protocol":         data.Protocol,
+			"status":           data.Status,
+			"response_size":    data.ResponseSize,
+			"router":           data.Router,
+			"service":          data.Service,
+			"entrypoint":       data.EntryPoint,
+			"headers":          data.Headers,
+			"cookies":          data.Cookies,
+			"response_headers": data.ResponseHeaders,
+			"extra":            data.Extra,
+			"referrer":         data.Referer,
+			"content_type":     data.ContentType,
+			"response_time_ms": float64(data.ResponseTime) / float64(time.Millisecond),
+			"ttfb_ms":          float64(data.TTFB) / float64(time.Millisecond),
+		},
+		Context: map[string]interface{}{
+			"ip":        data.IP,
+			"userAgent": data.UserAgent,
+			"locale":    data.Language,
+			"page":      page,
+			"campaign":  campaign,
+			"library":   map[string]string{"name": "traefik-analytics"},
+		},
+	}
+}
+
+// Write sends the records as track calls, split into batches that respect
+// the API size limits. Oversized events are skipped.
+func (s *segmentSink) Write(ctx context.Context, batch []RequestData) error {
+	var events []json.RawMessage
+	size := 0
+	skipped := 0
+	for i := range batch {
+		event, err := json.Marshal(s.event(&batch[i]))
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		if len(event) > segmentMaxEventBytes {
+			skipped++
+			continue
+		}
+		// Leave room for the envelope.
+		if len(events) > 0 && size+len(event)+1 > segmentMaxBatchBytes-64 {
+			err = s.send(ctx, events)
+			if err != nil {
+				return err
+			}
+			events, size = nil, 0
+		}
+		events = append(events, event)
+		size += len(event) + 1
+	}
+
+	if len(events) > 0 {
+		err := s.send(ctx, events)
+		if err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("skipped %d events larger than %d bytes", skipped, segmentMaxEventBytes)
+	}
+	return nil
+}
+
+// send delivers a single batch, retrying transient failures.
+func (s *segmentSink) send(ctx context.Context, events []json.RawMessage) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"batch":  events,
+		"sentAt": time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	err = withRetries(ctx, s.config.MaxRetries, s.backoff, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(s.config.WriteKey, "")
+		_, err = sendRequest(s.client, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send events: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *segmentSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
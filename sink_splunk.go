@@ -0,0 +1,135 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSink("splunk", newSplunkSink, validateSplunkConfig)
+}
+
+// SplunkConfig holds the settings of the Splunk HTTP Event Collector sink.
+type SplunkConfig struct {
+	// URL is the HEC base URL, e.g. "https://splunk:8088".
+	URL        string `json:"url,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Index      string `json:"index,omitempty"`
+	Source     string `json:"source,omitempty"`
+	SourceType string `json:"sourceType,omitempty"`
+	// Compress gzips the request bodies.
+	Compress bool `json:"compress,omitempty"`
+	// InsecureSkipVerify disables certificate checks, as HEC commonly runs
+	// with a self-signed certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// validateSplunkConfig checks the Splunk settings.
+func validateSplunkConfig(config *Config) error {
+	if config.Splunk.URL == "" {
+		return fmt.Errorf("splunk.url is required")
+	}
+	if config.Splunk.Token == "" {
+		return fmt.Errorf("splunk.token is required")
+	}
+	return nil
+}
+
+// splunkSink sends request records to the HEC event endpoint, one event
+// per record and one request per batch.
+type splunkSink struct {
+	client   *http.Client
+	config   SplunkConfig
+	endpoint string
+}
+
+// newSplunkSink creates the sink. No connection is made until the first write.
+func newSplunkSink(config *Config) (Sink, error) {
+	err := validateSplunkConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Splunk.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &splunkSink{
+		client:   &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		config:   config.Splunk,
+		endpoint: strings.TrimSuffix(config.Splunk.URL, "/") + "/services/collector/event",
+	}, nil
+}
+
+// splunkEvent is the HEC event envelope.
+type splunkEvent struct {
+	Time       float64      `json:"time"`
+	Host       string       `json:"host,omitempty"`
+	Source     string       `json:"source,omitempty"`
+	SourceType string       `json:"sourcetype,omitempty"`
+	Index      string       `json:"index,omitempty"`
+	Event      *RequestData `json:"event"`
+}
+
+// Write sends the records as concatenated HEC events.
+func (s *splunkSink) Write(ctx context.Context, batch []RequestData) error {
+	var body bytes.Buffer
+	var enc *json.Encoder
+	var zw *gzip.Writer
+	if s.config.Compress {
+		zw = gzip.NewWriter(&body)
+		enc = json.NewEncoder(zw)
+	} else {
+		enc = json.NewEncoder(&body)
+	}
+
+	for i := range batch {
+		err := enc.Encode(splunkEvent{
+			Time:       float64(batch[i].Time.UnixNano()) / 1e9,
+			Host:       batch[i].Host,
+			Source:     s.config.Source,
+			SourceType: s.config.SourceType,
+			Index:      s.config.Index,
+			Event:      &batch[i],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+	if zw != nil {
+		err := zw.Close()
+		if err != nil {
+			return fmt.Errorf("failed to compress data: %v", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Splunk "+s.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+	if zw != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	_, err = sendRequest(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to send events: %v", err)
+	}
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (s *splunkSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
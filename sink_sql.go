@@ -0,0 +1,781 @@
+package traefik_analytics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	registerSink("postgres", newSQLSinkFactory(postgresDialect), validatePostgresConfig)
+	registerSink("mysql", newSQLSinkFactory(mysqlDialect), validateMySQLConfig)
+	registerSink("sqlite", newSQLSinkFactory(sqliteDialect), validateSQLiteConfig)
+}
+
+// maintenanceInterval is how often expired records are deleted and
+// partitions are created.
+const maintenanceInterval = time.Hour
+
+// maxSQLParams is the number of bind parameters allowed in a statement by
+// all supported databases; SQLite has the lowest limit.
+const maxSQLParams = 32766
+
+// sqlDialect describes the differences between the supported SQL databases.
+type sqlDialect struct {
+	// driver is the database/sql driver name.
+	driver string
+	// placeholder returns the bind parameter for the n-th (1-based) value.
+	placeholder func(n int) string
+	// quote quotes an identifier.
+	quote func(name string) string
+	// createSchema is the statement creating a schema, followed by its
+	// name; empty if the database has no schemas to create.
+	createSchema string
+	// setup holds statements executed once after connecting.
+	setup []string
+	// createTable creates request_logs, and migrations bring its schema
+	// up to date. They are applied with Config.AutoMigrate, or always with
+	// autoMigrate.
+	createTable string
+	migrations  []sqlMigration
+	autoMigrate bool
+	// transactional wraps each batch in a single transaction.
+	transactional bool
+	// maxOpenConns limits the connection pool; zero means unlimited.
+	maxOpenConns int
+	// copy loads batches with COPY FROM STDIN instead of INSERT.
+	copy bool
+	// partitioning supports Config.Partitioning.
+	partitioning bool
+	// onConflict ends inserts so that records whose record ID, stored in
+	// the given quoted column, is already stored, e.g. replayed from the
+	// spill queue, are skipped.
+	onConflict func(recordID string) string
+}
+
+var postgresDialect = sqlDialect{
+	driver:       "postgres",
+	placeholder:  func(n int) string { return "$" + strconv.Itoa(n) },
+	quote:        quoteDoubleQuotes,
+	createSchema: "CREATE SCHEMA IF NOT EXISTS ",
+	copy:         true,
+	partitioning: true,
+	onConflict:   func(string) string { return " ON CONFLICT DO NOTHING" },
+	createTable:  postgresCreateTable,
+	migrations:   postgresMigrations,
+}
+
+// mysqlDialect also covers MariaDB, which speaks the same protocol.
+var mysqlDialect = sqlDialect{
+	driver:       "mysql",
+	placeholder:  func(int) string { return "?" },
+	quote:        quoteBackticks,
+	createSchema: "CREATE DATABASE IF NOT EXISTS ",
+	onConflict:   func(recordID string) string { return " ON DUPLICATE KEY UPDATE " + recordID + " = " + recordID },
+	createTable:  mysqlCreateTable,
+	migrations:   mysqlMigrations,
+}
+
+// sqliteDialect stores records in a local database file. There is no server
+// to hand the schema to, so the table is created on first use. WAL mode and
+// one transaction per batch keep the number of fsyncs low.
+var sqliteDialect = sqlDialect{
+	driver:      "sqlite",
+	placeholder: func(int) string { return "?" },
+	quote:       quoteDoubleQuotes,
+	setup: []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+	},
+	onConflict:    func(string) string { return " ON CONFLICT DO NOTHING" },
+	createTable:   sqliteCreateTable,
+	migrations:    sqliteMigrations,
+	autoMigrate:   true,
+	transactional: true,
+	// A single writer connection keeps the per-connection pragmas in effect
+	// and avoids SQLITE_BUSY between our own connections.
+	maxOpenConns: 1,
+}
+
+// quoteDoubleQuotes quotes an identifier for PostgreSQL and SQLite.
+func quoteDoubleQuotes(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteBackticks quotes an identifier for MySQL and ClickHouse.
+func quoteBackticks(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// indexPlaceholder matches the {idx_<name>} placeholders of migrations.
+var indexPlaceholder = regexp.MustCompile(`\{idx_(\w+)\}`)
+
+// sqlTable is the table a SQL backend writes to.
+type sqlTable struct {
+	schema string
+	name   string
+	quote  func(name string) string
+	// quoted is the quoted, schema-qualified name.
+	quoted string
+}
+
+func newSQLTable(dialect sqlDialect, config *Config) sqlTable {
+	t := sqlTable{schema: config.SchemaName, name: config.TableName, quote: dialect.quote}
+	t.quoted = t.qualify(t.name)
+	return t
+}
+
+// qualify returns the quoted name of a table in the schema of t.
+func (t sqlTable) qualify(name string) string {
+	if t.schema == "" {
+		return t.quote(name)
+	}
+	return t.quote(t.schema) + "." + t.quote(name)
+}
+
+// expand replaces the placeholders of a migration statement.
+func (t sqlTable) expand(statement string) string {
+	statement = indexPlaceholder.ReplaceAllStringFunc(statement, func(placeholder string) string {
+		return t.quote("idx_" + t.name + "_" + indexPlaceholder.FindStringSubmatch(placeholder)[1])
+	})
+	return strings.ReplaceAll(statement, "{table}", t.quoted)
+}
+
+// validateTableName checks the table and schema names, which are quoted
+// wherever they are used.
+func validateTableName(config *Config) error {
+	if config.TableName == "" || strings.ContainsRune(config.TableName, 0) {
+		return fmt.Errorf("invalid tableName %q", config.TableName)
+	}
+	if strings.ContainsRune(config.SchemaName, 0) {
+		return fmt.Errorf("invalid schemaName %q", config.SchemaName)
+	}
+	return nil
+}
+
+// mappedColumn returns the table column of the given column of
+// request_logs under mapping, or an empty string if it is not written.
+func mappedColumn(mapping map[string]string, column string) string {
+	if len(mapping) == 0 {
+		return column
+	}
+	return mapping[column]
+}
+
+// validateColumnMapping checks Config.Columns against the columns of
+// request_logs.
+func validateColumnMapping(config *Config) error {
+	if len(config.Columns) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(sqlColumns))
+	for _, col := range sqlColumns {
+		known[col.name] = true
+	}
+	mapped := map[string]string{}
+	for column, name := range config.Columns {
+		if !known[column] {
+			return fmt.Errorf("invalid columns entry %q: unknown column", column)
+		}
+		if name == "" || strings.ContainsRune(name, 0) {
+			return fmt.Errorf("invalid columns[%q] %q", column, name)
+		}
+		if other, ok := mapped[name]; ok {
+			return fmt.Errorf("invalid columns[%q]: %q is also mapped from %s", column, name, other)
+		}
+		mapped[name] = column
+	}
+	if config.Columns["request_time"] == "" {
+		return fmt.Errorf("columns.request_time is required")
+	}
+	if config.AutoMigrate {
+		return fmt.Errorf("autoMigrate cannot be combined with columns")
+	}
+	return nil
+}
+
+// validateMySQLConfig rejects the interval unit, which MySQL has no column
+// type for.
+func validateMySQLConfig(config *Config) error {
+	if config.ResponseTimeUnit == responseTimeInterval {
+		return fmt.Errorf("responseTimeUnit %q is not supported by mysql", config.ResponseTimeUnit)
+	}
+	return validateSQLConfig(config)
+}
+
+// validatePostgresConfig rejects hashed addresses, which the INET ip column
+// does not accept. With a column mapping, the type of the column is up to
+// the table.
+func validatePostgresConfig(config *Config) error {
+	if config.AnonymizeIP.Mode == "hash" && len(config.Columns) == 0 {
+		return fmt.Errorf("anonymizeIP.mode %q is not supported by the INET ip column, map the columns to a table with a TEXT ip column to use it", config.AnonymizeIP.Mode)
+	}
+	return validateSQLConfig(config)
+}
+
+// validateSQLiteConfig rejects a schema name, as a database file has a
+// single schema, and the interval unit.
+func validateSQLiteConfig(config *Config) error {
+	if config.SchemaName != "" {
+		return fmt.Errorf("schemaName is not supported by sqlite")
+	}
+	if config.ResponseTimeUnit == responseTimeInterval {
+		return fmt.Errorf("responseTimeUnit %q is not supported by sqlite", config.ResponseTimeUnit)
+	}
+	return validateSQLConfig(config)
+}
+
+// sqlColumn maps a request_logs column to its value in a record.
+type sqlColumn struct {
+	name  string
+	value func(data *RequestData) interface{}
+}
+
+// sqlColumns lists the request_logs columns in insert order.
+var sqlColumns = []sqlColumn{
+	{"ip", func(d *RequestData) interface{} { return sqlNullString(d.IP) }},
+	{"user_agent", func(d *RequestData) interface{} { return d.UserAgent }},
+	{"path", func(d *RequestData) interface{} { return d.Path }},
+	{"request_time", func(d *RequestData) interface{} { return d.Time }},
+	{"method", func(d *RequestData) interface{} { return d.Method }},
+	{"protocol", func(d *RequestData) interface{} { return d.Protocol }},
+	{"host", func(d *RequestData) interface{} { return d.Host }},
+	{"accept_language", func(d *RequestData) interface{} { return d.AcceptLanguage }},
+	{"referer", func(d *RequestData) interface{} { return d.Referer }},
+	{"content_type", func(d *RequestData) interface{} { return d.ContentType }},
+	{"content_length", func(d *RequestData) interface{} { return d.ContentLength }},
+	{"response_time", func(d *RequestData) interface{} { return int64(d.ResponseTime) }},
+	{"response_content_type", func(d *RequestData) interface{} { return d.ResponseContentType }},
+	{"status", func(d *RequestData) interface{} { return d.Status }},
+	{"response_size", func(d *RequestData) interface{} { return d.ResponseSize }},
+	{"router", func(d *RequestData) interface{} { return d.Router }},
+	{"service", func(d *RequestData) interface{} { return d.Service }},
+	{"entrypoint", func(d *RequestData) interface{} { return d.EntryPoint }},
+	{"query_string", func(d *RequestData) interface{} { return d.QueryString }},
+	{"headers", func(d *RequestData) interface{} { return sqlJSON(d.Headers) }},
+	{"cookies", func(d *RequestData) interface{} { return sqlJSON(d.Cookies) }},
+	{"trace_id", func(d *RequestData) interface{} { return d.TraceID }},
+	{"request_id", func(d *RequestData) interface{} { return d.RequestID }},
+	{"upgrade", func(d *RequestData) interface{} { return d.Upgrade }},
+	{"tunnel_bytes_received", func(d *RequestData) interface{} { return d.TunnelBytesReceived }},
+	{"tunnel_bytes_sent", func(d *RequestData) interface{} { return d.TunnelBytesSent }},
+	{"grpc_service", func(d *RequestData) interface{} { return d.GRPCService }},
+	{"grpc_method", func(d *RequestData) interface{} { return d.GRPCMethod }},
+	{"grpc_status", func(d *RequestData) interface{} { return d.GRPCStatus }},
+	{"client_port", func(d *RequestData) interface{} { return d.ClientPort }},
+	{"connection_reused", func(d *RequestData) interface{} { return d.ConnectionReused }},
+	{"response_headers", func(d *RequestData) interface{} { return sqlJSON(d.ResponseHeaders) }},
+	{"ttfb", func(d *RequestData) interface{} { return int64(d.TTFB) }},
+	{"upstream", func(d *RequestData) interface{} { return d.Upstream }},
+	{"content_encoding", func(d *RequestData) interface{} { return d.ContentEncoding }},
+	{"uncompressed_size", func(d *RequestData) interface{} { return d.UncompressedSize }},
+	{"country", func(d *RequestData) interface{} { return d.Country }},
+	{"region", func(d *RequestData) interface{} { return d.Region }},
+	{"city", func(d *RequestData) interface{} { return d.City }},
+	{"asn", func(d *RequestData) interface{} { return int64(d.ASN) }},
+	{"as_org", func(d *RequestData) interface{} { return d.ASOrg }},
+	{"browser", func(d *RequestData) interface{} { return d.Browser }},
+	{"browser_version", func(d *RequestData) interface{} { return d.BrowserVersion }},
+	{"os", func(d *RequestData) interface{} { return d.OS }},
+	{"os_version", func(d *RequestData) interface{} { return d.OSVersion }},
+	{"device_type", func(d *RequestData) interface{} { return d.DeviceType }},
+	{"is_bot", func(d *RequestData) interface{} { return d.IsBot }},
+	{"bot_name", func(d *RequestData) interface{} { return d.BotName }},
+	{"rdns", func(d *RequestData) interface{} { return d.RDNS }},
+	{"utm_source", func(d *RequestData) interface{} { return d.UTMSource }},
+	{"utm_medium", func(d *RequestData) interface{} { return d.UTMMedium }},
+	{"utm_campaign", func(d *RequestData) interface{} { return d.UTMCampaign }},
+	{"utm_term", func(d *RequestData) interface{} { return d.UTMTerm }},
+	{"utm_content", func(d *RequestData) interface{} { return d.UTMContent }},
+	{"referrer_domain", func(d *RequestData) interface{} { return d.ReferrerDomain }},
+	{"referrer_type", func(d *RequestData) interface{} { return d.ReferrerType }},
+	{"language", func(d *RequestData) interface{} { return d.Language }},
+	{"is_datacenter", func(d *RequestData) interface{} { return d.IsDatacenter }},
+	{"is_vpn", func(d *RequestData) interface{} { return d.IsVPN }},
+	{"is_tor", func(d *RequestData) interface{} { return d.IsTor }},
+	{"visitor_id", func(d *RequestData) interface{} { return d.VisitorID }},
+	{"session_id", func(d *RequestData) interface{} { return d.SessionID }},
+	{"tls_version", func(d *RequestData) interface{} { return d.TLSVersion }},
+	{"tls_cipher", func(d *RequestData) interface{} { return d.TLSCipher }},
+	{"tls_fingerprint", func(d *RequestData) interface{} { return d.TLSFingerprint }},
+	{"raw_path", func(d *RequestData) interface{} { return sqlNullString(d.RawPath) }},
+	{"extra", func(d *RequestData) interface{} { return sqlJSON(d.Extra) }},
+	{"record_id", func(d *RequestData) interface{} { return sqlNullString(d.RecordID) }},
+}
+
+// sqlNullString returns NULL for an empty string, e.g. a dropped address.
+func sqlNullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqlJSON encodes a map for a JSON column, or returns NULL for an empty map.
+func sqlJSON(m map[string]string) interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// insertStatement builds an INSERT into table of the given number of rows
+// for the dialect.
+func (d sqlDialect) insertStatement(table string, columns []sqlColumn, rows int) string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = d.quote(col.name)
+	}
+	values := make([]string, rows)
+	params := make([]string, len(columns))
+	for row := range values {
+		for i := range columns {
+			params[i] = d.placeholder(row*len(columns) + i + 1)
+		}
+		values[row] = "(" + strings.Join(params, ", ") + ")"
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(names, ", ") +
+		") VALUES " + strings.Join(values, ", ")
+}
+
+// sqlSink stores request records in a table through database/sql.
+type sqlSink struct {
+	db    *sql.DB
+	table sqlTable
+	// stmt inserts rowsPerStmt rows; shorter remainders are inserted with
+	// an unprepared statement. It is nil until setup has succeeded.
+	stmt        *sql.Stmt
+	rowsPerStmt int
+	insert      func(rows int) string
+	// copyMerge returns the statement moving rows copied into copyTable
+	// into the table, skipping records already stored, or "" if records
+	// are copied into the table directly.
+	copyMerge     func() string
+	columns       []sqlColumn
+	transactional bool
+	copy          bool
+	placeholder   func(n int) string
+	// timeColumn is the quoted column holding the request time.
+	timeColumn string
+	// retentionDays and partitions are the upkeep done by maintain; with
+	// partitions, expired partitions are dropped instead of deleting rows.
+	retentionDays int
+	partitions    *partitionManager
+	// existingColumns limits the columns written to those the table has,
+	// as found during setup.
+	existingColumns bool
+	// statementTimeout bounds every insert; zero means no limit.
+	statementTimeout time.Duration
+	// deadLetterInsert stores a rejected record in the dead-letter table.
+	deadLetterInsert string
+	// setup creates the tables. It runs with the first write rather than
+	// when the sink is opened, and again with later writes until it
+	// succeeds, so that the worker keeps its sink while the database is
+	// unreachable; database/sql reconnects and re-prepares stmt as needed.
+	setup []func(db *sql.DB) error
+}
+
+// newSQLSinkFactory returns a SinkFactory for the given dialect.
+func newSQLSinkFactory(dialect sqlDialect) SinkFactory {
+	return func(config *Config) (Sink, error) {
+		if dialect.partitioning && config.Partitioning.Interval != "" {
+			s, err := newSQLSink(partitionedDialect(dialect), config)
+			if err != nil {
+				return nil, err
+			}
+			s.partitions = newPartitionManager(s.table, config)
+			s.setup = append(s.setup, func(db *sql.DB) error { return s.partitions.maintain(context.Background(), db) })
+			return s, nil
+		}
+
+		s, err := newSQLSink(dialect, config)
+		if err != nil {
+			return nil, err
+		}
+		s.retentionDays = config.RetentionDays
+		return s, nil
+	}
+}
+
+// newSQLSink opens the connection pool of the database. The database is
+// set up with the first write.
+func newSQLSink(dialect sqlDialect, config *Config) (*sqlSink, error) {
+	settings, err := parseSQLPoolConfig(config.SQLPool)
+	if err != nil {
+		return nil, err
+	}
+	dialect, responseTime, err := responseTimeDialect(dialect, config.ResponseTimeUnit)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]sqlColumn, 0, len(sqlColumns))
+	for _, col := range sqlColumns {
+		if col.name == responseTime.name {
+			col = responseTime
+		}
+		if len(config.Columns) > 0 {
+			name, ok := config.Columns[col.name]
+			if !ok {
+				continue
+			}
+			col.name = name
+		}
+		columns = append(columns, col)
+	}
+	db, err := openSQLDB(dialect, config)
+	if err != nil {
+		return nil, err
+	}
+
+	table := newSQLTable(dialect, config)
+	setup := []func(db *sql.DB) error{func(db *sql.DB) error {
+		for _, query := range dialect.setup {
+			_, err := db.Exec(query)
+			if err != nil {
+				return fmt.Errorf("failed to set up database: %v", err)
+			}
+		}
+		return nil
+	}}
+	if len(config.Columns) == 0 && (config.AutoMigrate || dialect.autoMigrate) {
+		setup = append(setup, func(db *sql.DB) error { return migrateSQL(db, dialect, table) })
+	}
+	deadLetterTable := table.qualify(config.DeadLetter.Table)
+	if config.DeadLetter.Table != "" {
+		setup = append(setup, func(db *sql.DB) error {
+			_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + deadLetterTable +
+				" (failed_at TIMESTAMP NOT NULL, backend VARCHAR(64) NOT NULL, error TEXT NOT NULL, record TEXT NOT NULL)")
+			if err != nil {
+				return fmt.Errorf("failed to create dead-letter table: %v", err)
+			}
+			return nil
+		})
+	}
+
+	rowsPerStmt := min(config.BatchSize, maxSQLParams/len(columns))
+	s := &sqlSink{
+		db:               db,
+		table:            table,
+		setup:            setup,
+		rowsPerStmt:      rowsPerStmt,
+		timeColumn:       dialect.quote(mappedColumn(config.Columns, "request_time")),
+		existingColumns:  len(config.Columns) == 0,
+		columns:          columns,
+		transactional:    dialect.transactional,
+		copy:             dialect.copy,
+		placeholder:      dialect.placeholder,
+		statementTimeout: settings.statementTimeout,
+		deadLetterInsert: "INSERT INTO " + deadLetterTable + " (failed_at, backend, error, record) VALUES (" +
+			dialect.placeholder(1) + ", " + dialect.placeholder(2) + ", " + dialect.placeholder(3) + ", " + dialect.placeholder(4) + ")",
+	}
+	recordID := mappedColumn(config.Columns, "record_id")
+	writesRecordID := func() bool {
+		for _, col := range s.columns {
+			if col.name == recordID {
+				return true
+			}
+		}
+		return false
+	}
+	s.insert = func(rows int) string {
+		statement := dialect.insertStatement(table.quoted, s.columns, rows)
+		if writesRecordID() {
+			return statement + dialect.onConflict(dialect.quote(recordID))
+		}
+		return statement
+	}
+	s.copyMerge = func() string {
+		if !writesRecordID() {
+			return ""
+		}
+		names := make([]string, len(s.columns))
+		for i, col := range s.columns {
+			names[i] = dialect.quote(col.name)
+		}
+		list := strings.Join(names, ", ")
+		return "INSERT INTO " + table.quoted + " (" + list + ") SELECT " + list +
+			" FROM " + dialect.quote(copyTable) + dialect.onConflict(dialect.quote(recordID))
+	}
+	return s, nil
+}
+
+// copyTable is the temporary table COPY loads batches into before they
+// are merged into the table, since COPY cannot skip duplicate rows.
+const copyTable = "traefik_analytics_copy"
+
+// sqlExecer is implemented by *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Write inserts the records with multi-row INSERT statements. If a
+// statement fails, its rows are retried one at a time, so that a failing
+// row does not stop the others from being inserted; the first error is
+// returned.
+func (s *sqlSink) Write(ctx context.Context, batch []RequestData) error {
+	if s.stmt == nil {
+		err := s.prepare(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if s.copy {
+		err := s.copyRows(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		log.Printf("COPY failed, inserting the batch instead: %v", err)
+	}
+	if !s.transactional {
+		stored, rejected, err := s.insertRows(ctx, s.db, s.stmt, batch)
+		return s.insertError(ctx, stored, rejected, err, false)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	stored, rejected, insertErr := s.insertRows(ctx, tx, tx.StmtContext(ctx, s.stmt), batch)
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	// The commit shows that the database is reachable.
+	return s.insertError(ctx, stored, rejected, insertErr, true)
+}
+
+// prepare sets up the database and prepares the insert statement.
+func (s *sqlSink) prepare(ctx context.Context) error {
+	for _, setup := range s.setup {
+		err := setup(s.db)
+		if err != nil {
+			return err
+		}
+	}
+	if s.existingColumns {
+		err := s.dropMissingColumns()
+		if err != nil {
+			return err
+		}
+	}
+	stmt, err := s.db.PrepareContext(ctx, s.insert(s.rowsPerStmt))
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	s.stmt = stmt
+	return nil
+}
+
+// dropMissingColumns stops writing the columns the table lacks, e.g. if it
+// was created from an older schema file and AutoMigrate is off, so that
+// inserts do not fail on schema drift.
+func (s *sqlSink) dropMissingColumns() error {
+	existing, err := tableColumns(s.db, s.table)
+	if err != nil {
+		return err
+	}
+	var columns []sqlColumn
+	var missing []string
+	for _, col := range s.columns {
+		if existing[col.name] {
+			columns = append(columns, col)
+		} else {
+			missing = append(missing, col.name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("Not writing columns missing from %s, enable autoMigrate to add them: %s", s.table.quoted, strings.Join(missing, ", "))
+	}
+	s.columns = columns
+	return nil
+}
+
+// insertError returns the error of insertRows as a partialWriteError if
+// the database rejected the rows. Without any stored row, that is only the
+// case if the database is still reachable.
+func (s *sqlSink) insertError(ctx context.Context, stored int, rejected []rejectedRecord, err error, reachable bool) error {
+	if err == nil {
+		return nil
+	}
+	if stored > 0 || reachable || s.db.PingContext(ctx) == nil {
+		return &partialWriteError{err: err, rejected: rejected}
+	}
+	return err
+}
+
+// copyRows loads batch with a single COPY in its own transaction, so that
+// either all or none of the rows are stored. If the record ID is written,
+// the rows are copied into a temporary table first and merged from there,
+// so that records written again are skipped as with INSERT.
+func (s *sqlSink) copyRows(ctx context.Context, batch []RequestData) error {
+	names := make([]string, len(s.columns))
+	for i, col := range s.columns {
+		names[i] = col.name
+	}
+
+	ctx, cancel := s.statementContext(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	copyIn := pq.CopyIn(s.table.name, names...)
+	if s.table.schema != "" {
+		copyIn = pq.CopyInSchema(s.table.schema, s.table.name, names...)
+	}
+	merge := s.copyMerge()
+	if merge != "" {
+		_, err = tx.ExecContext(ctx, "CREATE TEMPORARY TABLE "+s.table.quote(copyTable)+
+			" (LIKE "+s.table.quoted+" INCLUDING DEFAULTS) ON COMMIT DROP")
+		if err != nil {
+			return fmt.Errorf("failed to create COPY table: %v", err)
+		}
+		copyIn = pq.CopyIn(copyTable, names...)
+	}
+	stmt, err := tx.PrepareContext(ctx, copyIn)
+	if err != nil {
+		return fmt.Errorf("failed to start COPY: %v", err)
+	}
+	defer stmt.Close()
+
+	args := make([]interface{}, len(s.columns))
+	for i := range batch {
+		for j, col := range s.columns {
+			args[j] = col.value(&batch[i])
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("failed to copy data: %v", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to copy data: %v", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to copy data: %v", err)
+	}
+	if merge != "" {
+		if _, err := tx.ExecContext(ctx, merge); err != nil {
+			return fmt.Errorf("failed to merge copied data: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// insertRows inserts batch in chunks of up to rowsPerStmt rows, using stmt
+// for full chunks. It returns the number of stored rows, the rejected ones
+// and the first error.
+func (s *sqlSink) insertRows(ctx context.Context, db sqlExecer, stmt *sql.Stmt, batch []RequestData) (int, []rejectedRecord, error) {
+	var firstErr error
+	var rejected []rejectedRecord
+	stored := 0
+	for len(batch) > 0 {
+		chunk := batch[:min(len(batch), s.rowsPerStmt)]
+		batch = batch[len(chunk):]
+
+		err := s.execInsert(ctx, db, stmt, chunk)
+		if err == nil {
+			stored += len(chunk)
+			continue
+		}
+		if len(chunk) == 1 {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to insert data: %v", err)
+			}
+			rejected = append(rejected, rejectedRecord{data: chunk[0], err: err})
+			continue
+		}
+		for i := range chunk {
+			err := s.execInsert(ctx, db, stmt, chunk[i:i+1])
+			if err == nil {
+				stored++
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to insert data: %v", err)
+			}
+			rejected = append(rejected, rejectedRecord{data: chunk[i], err: err})
+		}
+	}
+	return stored, rejected, firstErr
+}
+
+// execInsert inserts rows with a single statement.
+func (s *sqlSink) execInsert(ctx context.Context, db sqlExecer, stmt *sql.Stmt, rows []RequestData) error {
+	ctx, cancel := s.statementContext(ctx)
+	defer cancel()
+	args := make([]interface{}, 0, len(rows)*len(s.columns))
+	for i := range rows {
+		for _, col := range s.columns {
+			args = append(args, col.value(&rows[i]))
+		}
+	}
+	if len(rows) == s.rowsPerStmt {
+		_, err := stmt.ExecContext(ctx, args...)
+		return err
+	}
+	_, err := db.ExecContext(ctx, s.insert(len(rows)), args...)
+	return err
+}
+
+// writeDeadLetters stores rejected records in the dead-letter table.
+func (s *sqlSink) writeDeadLetters(ctx context.Context, letters []deadLetter) error {
+	for _, letter := range letters {
+		record, err := json.Marshal(letter.Record)
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+		_, err = s.db.ExecContext(ctx, s.deadLetterInsert, letter.Time, letter.Backend, letter.Error, string(record))
+		if err != nil {
+			return fmt.Errorf("failed to insert dead letter: %v", err)
+		}
+	}
+	return nil
+}
+
+// maintain deletes records older than retentionDays, or maintains the
+// partitions of the table.
+func (s *sqlSink) maintain(ctx context.Context) {
+	if s.partitions != nil {
+		if err := s.partitions.maintain(ctx, s.db); err != nil {
+			log.Printf("Failed to maintain partitions: %v", err)
+		}
+		return
+	}
+	if s.retentionDays == 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -s.retentionDays)
+	result, err := s.db.ExecContext(ctx, "DELETE FROM "+s.table.quoted+" WHERE "+s.timeColumn+" < "+s.placeholder(1), cutoff)
+	if err != nil {
+		log.Printf("Failed to delete expired records: %v", err)
+	} else if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d records older than %d days", n, s.retentionDays)
+	}
+}
+
+// Close releases the prepared statement and the connection pool.
+func (s *sqlSink) Close() error {
+	if s.stmt != nil {
+		s.stmt.Close()
+	}
+	return releaseSQLDB(s.db)
+}
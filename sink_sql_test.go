@@ -0,0 +1,168 @@
+package traefik_analytics
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLSinkSkipsReplayedRecords(t *testing.T) {
+	config := CreateConfig()
+	config.DatabaseDSN = filepath.Join(t.TempDir(), "analytics.db")
+	sink, err := newSQLSink(sqliteDialect, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	now := time.Now()
+	batch := make([]RequestData, 3)
+	for i := range batch {
+		batch[i] = RequestData{
+			IP:       "203.0.113.7",
+			Path:     "/",
+			Time:     now,
+			Method:   "GET",
+			Protocol: "HTTP/1.1",
+			Host:     "example.com",
+			RecordID: newUUIDv7(now),
+		}
+	}
+	// A batch written again after a crash, partly overlapping new records.
+	replayed := append(batch[1:], RequestData{
+		IP: "203.0.113.8", Path: "/", Time: now, Method: "GET",
+		Protocol: "HTTP/1.1", Host: "example.com", RecordID: newUUIDv7(now),
+	})
+	for _, b := range [][]RequestData{batch, batch, replayed} {
+		if err := sink.Write(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rows int
+	if err := sink.db.QueryRow(`SELECT COUNT(*) FROM request_logs`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 4 {
+		t.Errorf("stored %d rows, want 4", rows)
+	}
+}
+
+func TestCopyMergeStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns map[string]string
+		want    string
+	}{
+		{"record ID written", nil, `INSERT INTO "request_logs" (`},
+		{"record ID not mapped", map[string]string{"request_time": "ts", "path": "uri"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.DatabaseDSN = "postgres://localhost/analytics"
+			config.Columns = tt.columns
+			sink, err := newSQLSink(postgresDialect, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+			got := sink.copyMerge()
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("copyMerge() = %q, want prefix %q", got, tt.want)
+			}
+			if tt.want != "" && !strings.HasSuffix(got, `FROM "traefik_analytics_copy" ON CONFLICT DO NOTHING`) {
+				t.Errorf("copyMerge() = %q does not skip duplicates", got)
+			}
+		})
+	}
+}
+
+func TestSQLSinkDeadLetterTable(t *testing.T) {
+	config := CreateConfig()
+	config.DatabaseDSN = filepath.Join(t.TempDir(), "analytics.db")
+	config.DeadLetter.Table = "dead letters"
+	sink, err := newSQLSink(sqliteDialect, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	letter := deadLetter{Time: time.Now(), Backend: "sqlite", Error: "rejected", Record: RequestData{Path: "/"}}
+	if err := sink.writeDeadLetters(context.Background(), []deadLetter{letter}); err != nil {
+		t.Fatal(err)
+	}
+	var record string
+	if err := sink.db.QueryRow(`SELECT record FROM "dead letters"`).Scan(&record); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(record, `"path":"/"`) {
+		t.Errorf("stored record %s, want the rejected one", record)
+	}
+}
+
+func TestValidatePostgresConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		columns map[string]string
+		wantErr bool
+	}{
+		{"truncated addresses", "truncate", nil, false},
+		{"hashed addresses", "hash", nil, true},
+		{"hashed addresses in a mapped table", "hash", map[string]string{"request_time": "ts", "ip": "client"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.DatabaseDSN = "postgres://localhost/analytics"
+			config.AnonymizeIP.Mode = tt.mode
+			config.Columns = tt.columns
+			for _, validate := range []func(*Config) error{validatePostgresConfig, validateTimescaleDBConfig} {
+				config.TimescaleDB.ChunkTimeInterval = "1 day"
+				if err := validate(config); (err != nil) != tt.wantErr {
+					t.Errorf("got error %v, want error %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSQLDBSharesPoolsWithSameSettings(t *testing.T) {
+	open := func(maxOpenConns int) *Config {
+		config := CreateConfig()
+		config.DatabaseDSN = "postgres://analytics@127.0.0.1:1/analytics"
+		config.SQLPool.MaxOpenConns = maxOpenConns
+		return config
+	}
+	first, err := openSQLDB(postgresDialect, open(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseSQLDB(first)
+	same, err := openSQLDB(postgresDialect, open(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseSQLDB(same)
+	other, err := openSQLDB(postgresDialect, open(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseSQLDB(other)
+
+	if same != first {
+		t.Error("same settings opened a new pool")
+	}
+	if other == first {
+		t.Error("different settings reused the pool")
+	}
+	if got := other.Stats().MaxOpenConnections; got != 8 {
+		t.Errorf("pool allows %d connections, want 8", got)
+	}
+}
@@ -0,0 +1,246 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerSink("syslog", newSyslogSink, validateSyslogConfig)
+}
+
+// SyslogConfig holds the settings of the RFC 5424 syslog sink.
+type SyslogConfig struct {
+	// Network is "udp", "tcp" or "tls".
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Facility is a syslog facility name such as "local0" or "daemon".
+	Facility string `json:"facility,omitempty"`
+	AppName  string `json:"appName,omitempty"`
+	// SDID is the structured data element ID carrying the request fields.
+	// Custom IDs must have the form name@<private enterprise number>.
+	SDID string `json:"sdID,omitempty"`
+	// InsecureSkipVerify disables certificate checks for "tls".
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverityInfo is the severity of every emitted message.
+const syslogSeverityInfo = 6
+
+// syslogParamEscaper escapes PARAM-VALUE characters, see RFC 5424 section 6.3.3.
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// validateSyslogConfig checks the syslog settings.
+func validateSyslogConfig(config *Config) error {
+	c := config.Syslog
+	switch c.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("unsupported syslog.network %q", c.Network)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("syslog.address is required")
+	}
+	if _, ok := syslogFacilities[c.Facility]; !ok {
+		return fmt.Errorf("unknown syslog.facility %q", c.Facility)
+	}
+	if c.SDID == "" || strings.ContainsAny(c.SDID, ` ="]`) {
+		return fmt.Errorf("invalid syslog.sdID %q", c.SDID)
+	}
+	return nil
+}
+
+// syslogSink sends one RFC 5424 message per request record. Stream
+// transports use octet-counting framing (RFC 6587). A broken connection is
+// dropped and redialed on the next write.
+type syslogSink struct {
+	config   SyslogConfig
+	priority string
+	hostname string
+	procID   string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogSink connects to the syslog server.
+func newSyslogSink(config *Config) (Sink, error) {
+	err := validateSyslogConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &syslogSink{
+		config:   config.Syslog,
+		priority: strconv.Itoa(syslogFacilities[config.Syslog.Facility]*8 + syslogSeverityInfo),
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the syslog server.
+func (s *syslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.config.Network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.config.Address, &tls.Config{
+			InsecureSkipVerify: s.config.InsecureSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial(s.config.Network, s.config.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write sends the records, one message each.
+func (s *syslogSink) Write(ctx context.Context, batch []RequestData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	err := s.conn.SetWriteDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for i := range batch {
+		msg := s.format(&batch[i])
+		if s.config.Network == "udp" {
+			_, err = s.conn.Write(msg)
+		} else {
+			buf.WriteString(strconv.Itoa(len(msg)))
+			buf.WriteByte(' ')
+			buf.Write(msg)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && buf.Len() > 0 {
+		_, err = s.conn.Write(buf.Bytes())
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to send syslog message: %v", err)
+	}
+	return nil
+}
+
+// format renders a record as an RFC 5424 message.
+func (s *syslogSink) format(data *RequestData) []byte {
+	var b bytes.Buffer
+	b.WriteString("<" + s.priority + ">1 ")
+	b.WriteString(data.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	b.WriteString(" " + s.hostname + " " + s.config.AppName + " " + s.procID + " request ")
+
+	b.WriteString("[" + s.config.SDID)
+	params := []struct{ name, value string }{
+		{"ip", data.IP},
+		{"client_port", strconv.Itoa(data.ClientPort)},
+		{"country", data.Country},
+		{"region", data.Region},
+		{"city", data.City},
+		{"asn", strconv.FormatUint(uint64(data.ASN), 10)},
+		{"as_org", data.ASOrg},
+		{"browser", data.Browser},
+		{"os", data.OS},
+		{"device_type", data.DeviceType},
+		{"is_bot", strconv.FormatBool(data.IsBot)},
+		{"bot_name", data.BotName},
+		{"rdns", data.RDNS},
+		{"referrer_type", data.ReferrerType},
+		{"is_datacenter", strconv.FormatBool(data.IsDatacenter)},
+		{"is_vpn", strconv.FormatBool(data.IsVPN)},
+		{"is_tor", strconv.FormatBool(data.IsTor)},
+		{"method", data.Method},
+		{"host", data.Host},
+		{"path", data.Path},
+		{"query", data.QueryString},
+		{"status", strconv.Itoa(data.Status)},
+		{"router", data.Router},
+		{"service", data.Service},
+		{"entrypoint", data.EntryPoint},
+		{"upstream", data.Upstream},
+		{"trace_id", data.TraceID},
+		{"request_id", data.RequestID},
+		{"upgrade", data.Upgrade},
+		{"grpc_service", data.GRPCService},
+		{"grpc_method", data.GRPCMethod},
+		{"protocol", data.Protocol},
+		{"user_agent", data.UserAgent},
+		{"referer", data.Referer},
+		{"accept_language", data.AcceptLanguage},
+		{"language", data.Language},
+		{"content_type", data.ContentType},
+		{"content_length", strconv.FormatInt(data.ContentLength, 10)},
+		{"response_size", strconv.FormatInt(data.ResponseSize, 10)},
+		{"content_encoding", data.ContentEncoding},
+		{"response_time_ms", strconv.FormatFloat(float64(data.ResponseTime)/float64(time.Millisecond), 'f', 3, 64)},
+		{"ttfb_ms", strconv.FormatFloat(float64(data.TTFB)/float64(time.Millisecond), 'f', 3, 64)},
+	}
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		b.WriteString(" " + p.name + `="` + syslogParamEscaper.Replace(p.value) + `"`)
+	}
+	b.WriteString("] ")
+
+	b.WriteString(data.Method + " " + data.Host + data.Path)
+	return b.Bytes()
+}
+
+// Close closes the connection.
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
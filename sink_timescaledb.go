@@ -0,0 +1,176 @@
+package traefik_analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	registerSink("timescaledb", newTimescaleDBSink, validateTimescaleDBConfig)
+}
+
+// TimescaleDBConfig holds the hypertable settings of the TimescaleDB sink.
+// Intervals use PostgreSQL interval syntax, e.g. "1 day" or "12 hours".
+type TimescaleDBConfig struct {
+	ChunkTimeInterval string `json:"chunkTimeInterval,omitempty"`
+	// CompressAfter enables native compression of chunks older than the
+	// given interval. Compression is left untouched when empty.
+	CompressAfter string `json:"compressAfter,omitempty"`
+	// CompressSegmentBy is the column compressed chunks are segmented by.
+	CompressSegmentBy string `json:"compressSegmentBy,omitempty"`
+}
+
+// validateTimescaleDBConfig checks the TimescaleDB settings.
+func validateTimescaleDBConfig(config *Config) error {
+	err := validatePostgresConfig(config)
+	if err != nil {
+		return err
+	}
+	if config.TimescaleDB.ChunkTimeInterval == "" {
+		return fmt.Errorf("timescaleDB.chunkTimeInterval is required")
+	}
+	return nil
+}
+
+// timescaleDialect is the postgres dialect with the schema of
+// schema_timescaledb.sql.
+var timescaleDialect = func() sqlDialect {
+	d := postgresDialect
+	d.createTable = timescaleCreateTable
+	d.migrations = timescaleMigrations
+	return d
+}()
+
+// newTimescaleDBSink connects like the postgres sink and turns its table
+// into a hypertable partitioned on request_time. Retention is left to a
+// TimescaleDB policy, which drops whole chunks instead of deleting rows.
+func newTimescaleDBSink(config *Config) (Sink, error) {
+	s, err := newSQLSink(timescaleDialect, config)
+	if err != nil {
+		return nil, err
+	}
+	s.setup = append(s.setup,
+		func(db *sql.DB) error {
+			return setupHypertable(db, s.table.quoted, mappedColumn(config.Columns, "request_time"), config.TimescaleDB)
+		},
+		func(db *sql.DB) error { return setupRetentionPolicy(db, s.table.quoted, config.RetentionDays) },
+	)
+	return s, nil
+}
+
+// setupHypertable creates the hypertable and compression policy. All steps
+// are idempotent so they can run on every start.
+func setupHypertable(db *sql.DB, table, timeColumn string, config TimescaleDBConfig) error {
+	var version string
+	err := db.QueryRow(`SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`).Scan(&version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("timescaledb extension is not installed in the database")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to detect timescaledb: %v", err)
+	}
+
+	_, err = db.Exec(`SELECT create_hypertable($1::regclass, $3::name,
+        chunk_time_interval => $2::interval, if_not_exists => TRUE, migrate_data => TRUE)`,
+		table, config.ChunkTimeInterval, timeColumn)
+	if err != nil {
+		return fmt.Errorf("failed to create hypertable: %v", err)
+	}
+
+	_, err = db.Exec(`SELECT set_chunk_time_interval($1::regclass, $2::interval)`, table, config.ChunkTimeInterval)
+	if err != nil {
+		return fmt.Errorf("failed to set chunk time interval: %v", err)
+	}
+
+	if config.CompressAfter == "" {
+		return nil
+	}
+
+	// Changing the compression settings fails once chunks are compressed,
+	// so they are only set when they differ.
+	enabled, segmentBy, err := compressionSettings(db, table)
+	if err != nil {
+		return err
+	}
+	if !enabled || segmentBy != strings.ReplaceAll(config.CompressSegmentBy, " ", "") {
+		settings := "timescaledb.compress"
+		if config.CompressSegmentBy != "" {
+			settings += ", timescaledb.compress_segmentby = " + pq.QuoteLiteral(config.CompressSegmentBy)
+		}
+		_, err = db.Exec(`ALTER TABLE ` + table + ` SET (` + settings + `)`)
+		if err != nil {
+			return fmt.Errorf("failed to enable compression: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`SELECT add_compression_policy($1::regclass, $2::interval, if_not_exists => TRUE)`, table, config.CompressAfter)
+	if err != nil {
+		return fmt.Errorf("failed to add compression policy: %v", err)
+	}
+
+	return nil
+}
+
+// compressionSettings reports whether compression is enabled on table and
+// the comma-separated columns it is segmented by.
+func compressionSettings(db *sql.DB, table string) (bool, string, error) {
+	rows, err := db.Query(`SELECT attname, segmentby_column_index IS NOT NULL
+        FROM timescaledb_information.compression_settings
+        WHERE format('%I.%I', hypertable_schema, hypertable_name)::regclass = $1::regclass
+        ORDER BY segmentby_column_index`, table)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read compression settings: %v", err)
+	}
+	defer rows.Close()
+	enabled := false
+	var segmentBy []string
+	for rows.Next() {
+		var column string
+		var segment bool
+		if err := rows.Scan(&column, &segment); err != nil {
+			return false, "", fmt.Errorf("failed to read compression settings: %v", err)
+		}
+		enabled = true
+		if segment {
+			segmentBy = append(segmentBy, column)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, "", fmt.Errorf("failed to read compression settings: %v", err)
+	}
+	return enabled, strings.Join(segmentBy, ","), nil
+}
+
+// setupRetentionPolicy replaces the policy dropping chunks older than
+// retentionDays unless it already drops them after that many days. A
+// policy is left untouched if retention is disabled, so that one managed
+// outside the plugin is kept.
+func setupRetentionPolicy(db *sql.DB, table string, retentionDays int) error {
+	if retentionDays == 0 {
+		return nil
+	}
+	var current bool
+	err := db.QueryRow(`SELECT (config->>'drop_after')::interval = make_interval(days => $2)
+        FROM timescaledb_information.jobs
+        WHERE proc_name = 'policy_retention'
+          AND format('%I.%I', hypertable_schema, hypertable_name)::regclass = $1::regclass`,
+		table, retentionDays).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read retention policy: %v", err)
+	}
+	if current {
+		return nil
+	}
+	_, err = db.Exec(`SELECT remove_retention_policy($1::regclass, if_exists => TRUE)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to remove retention policy: %v", err)
+	}
+	_, err = db.Exec(`SELECT add_retention_policy($1::regclass, make_interval(days => $2))`, table, retentionDays)
+	if err != nil {
+		return fmt.Errorf("failed to add retention policy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package traefik_analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func init() {
+	registerSink("webhook", newWebhookSink, validateWebhookConfig)
+}
+
+// WebhookConfig holds the settings of the generic HTTP webhook sink.
+type WebhookConfig struct {
+	URL string `json:"url,omitempty"`
+	// Headers are added to every request, e.g. an Authorization token.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds each attempt, as a Go duration string.
+	Timeout string `json:"timeout,omitempty"`
+	// MaxRetries is the number of additional attempts after a failed
+	// request. Only transport errors, 408, 429 and 5xx responses are retried.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the delay before the first retry; it doubles on each
+	// further attempt.
+	RetryBackoff string `json:"retryBackoff,omitempty"`
+}
+
+// validateWebhookConfig checks the webhook settings.
+func validateWebhookConfig(config *Config) error {
+	if config.Webhook.URL == "" {
+		return fmt.Errorf("webhook.url is required")
+	}
+	_, err := time.ParseDuration(config.Webhook.Timeout)
+	if err != nil {
+		return fmt.Errorf("invalid webhook.timeout: %v", err)
+	}
+	_, err = time.ParseDuration(config.Webhook.RetryBackoff)
+	if err != nil {
+		return fmt.Errorf("invalid webhook.retryBackoff: %v", err)
+	}
+	return nil
+}
+
+// webhookSink POSTs each batch as a JSON array of request records.
+type webhookSink struct {
+	client  *http.Client
+	config  WebhookConfig
+	backoff time.Duration
+}
+
+// newWebhookSink creates the sink. No connection is made until the first write.
+func newWebhookSink(config *Config) (Sink, error) {
+	err := validateWebhookConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	timeout, _ := time.ParseDuration(config.Webhook.Timeout)
+	backoff, _ := time.ParseDuration(config.Webhook.RetryBackoff)
+
+	return &webhookSink{
+		client:  &http.Client{Timeout: timeout},
+		config:  config.Webhook,
+		backoff: backoff,
+	}, nil
+}
+
+// Write sends the batch, retrying transient failures.
+func (s *webhookSink) Write(ctx context.Context, batch []RequestData) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+
+	err = withRetries(ctx, s.config.MaxRetries, s.backoff, func() error {
+		return s.post(ctx, body)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver data: %v", err)
+	}
+	return nil
+}
+
+// post sends a single attempt.
+func (s *webhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "traefik-analytics")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	_, err = sendRequest(s.client, req)
+	return err
+}
+
+// Close releases idle HTTP connections.
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,186 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// SpillConfig keeps records in local files while a backend is unreachable
+// and writes them to it once it is back, e.g. during a database upgrade.
+type SpillConfig struct {
+	// Directory holds one append-only file per middleware and backend.
+	// Spilling is disabled when empty.
+	Directory string `json:"directory,omitempty"`
+	// MaxSizeBytes bounds each file; records beyond it are discarded.
+	MaxSizeBytes int64 `json:"maxSizeBytes,omitempty"`
+}
+
+// unsafeFileChars are replaced in the names of spill files.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// spillFile stores records as JSON lines while a backend is unreachable.
+type spillFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+// newSpillFile opens the spill file of a backend, keeping records left by
+// a previous run.
+func newSpillFile(c SpillConfig, name, storageType string) (*spillFile, error) {
+	err := os.MkdirAll(c.Directory, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %v", err)
+	}
+	s := &spillFile{
+		path:    filepath.Join(c.Directory, unsafeFileChars.ReplaceAllString(name+"-"+storageType, "_")+".jsonl"),
+		maxSize: c.MaxSizeBytes,
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.size = info.Size()
+	}
+	return s, nil
+}
+
+// pending reports whether records are waiting to be replayed.
+func (s *spillFile) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size > 0
+}
+
+// append adds batch to the file, unless that would exceed its maximum size.
+func (s *spillFile) append(batch []RequestData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range batch {
+		err := enc.Encode(&batch[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size+int64(buf.Len()) > s.maxSize {
+		return fmt.Errorf("spill file %s is full, discarding %d records", s.path, len(batch))
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open spill file: %v", err)
+	}
+	defer file.Close()
+	n, err := file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write spill file: %v", err)
+	}
+	return nil
+}
+
+// replay writes the spilled records to sink in batches and removes them
+// from the file. Records the sink rejects are passed to onRejected. If a
+// batch fails, it and the following records are kept for the next attempt.
+func (s *spillFile) replay(ctx context.Context, sink Sink, batchSize int, onRejected func([]rejectedRecord)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open spill file: %v", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	batch := make([]RequestData, 0, batchSize)
+	var offset, batchStart int64
+	replayed := 0
+	for {
+		line, readErr := r.ReadBytes('\n')
+		offset += int64(len(line))
+		// A line without newline was cut short by a crash and is dropped.
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var data RequestData
+			if err := json.Unmarshal(line, &data); err != nil {
+				log.Printf("Skipping unreadable spilled record: %v", err)
+			} else {
+				batch = append(batch, data)
+			}
+		}
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read spill file: %v", readErr)
+		}
+
+		if len(batch) == batchSize || (readErr == io.EOF && len(batch) > 0) {
+			err := sink.Write(ctx, batch)
+			var partial *partialWriteError
+			if errors.As(err, &partial) {
+				log.Printf("Failed to write some spilled records: %v", err)
+				onRejected(partial.rejected)
+			} else if err != nil {
+				if replayed > 0 {
+					log.Printf("Replayed %d spilled records", replayed)
+				}
+				return s.keepFrom(file, batchStart, err)
+			}
+			replayed += len(batch)
+			batch = batch[:0]
+			batchStart = offset
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	file.Close()
+	err = os.Remove(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to remove spill file: %v", err)
+	}
+	s.size = 0
+	log.Printf("Replayed %d spilled records", replayed)
+	return nil
+}
+
+// keepFrom replaces the file with its contents from offset on, after a
+// replay failed with writeErr.
+func (s *spillFile) keepFrom(file *os.File, offset int64, writeErr error) error {
+	if offset == 0 {
+		return fmt.Errorf("failed to replay spilled records: %v", writeErr)
+	}
+	tmp, err := os.OpenFile(s.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %v", err)
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(tmp, file)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		os.Remove(s.path + ".tmp")
+		return fmt.Errorf("failed to rewrite spill file: %v", err)
+	}
+	s.size -= offset
+	return fmt.Errorf("failed to replay spilled records: %v", writeErr)
+}
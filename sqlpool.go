@@ -0,0 +1,162 @@
+package traefik_analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SQLPoolConfig tunes the connection pool of the postgres, mysql, sqlite
+// and timescaledb backends. Durations are Go duration strings; zero values
+// keep the database/sql defaults.
+type SQLPoolConfig struct {
+	// MaxOpenConns limits the open connections of the pool, which is shared
+	// by all workers writing to the same database with the same pool
+	// settings. SQLite always uses one pool per database file, with a
+	// single connection and the settings of the first backend opening it.
+	MaxOpenConns    int    `json:"maxOpenConns,omitempty"`
+	MaxIdleConns    int    `json:"maxIdleConns,omitempty"`
+	ConnMaxLifetime string `json:"connMaxLifetime,omitempty"`
+	ConnMaxIdleTime string `json:"connMaxIdleTime,omitempty"`
+	// StatementTimeout cancels an insert that takes longer, e.g. while the
+	// database is overloaded.
+	StatementTimeout string `json:"statementTimeout,omitempty"`
+}
+
+// sqlPoolSettings are the parsed SQLPoolConfig durations.
+type sqlPoolSettings struct {
+	connMaxLifetime  time.Duration
+	connMaxIdleTime  time.Duration
+	statementTimeout time.Duration
+}
+
+// parseSQLPoolConfig checks the pool settings.
+func parseSQLPoolConfig(c SQLPoolConfig) (sqlPoolSettings, error) {
+	var settings sqlPoolSettings
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return settings, fmt.Errorf("sqlPool.maxOpenConns and sqlPool.maxIdleConns must not be negative")
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"connMaxLifetime", c.ConnMaxLifetime, &settings.connMaxLifetime},
+		{"connMaxIdleTime", c.ConnMaxIdleTime, &settings.connMaxIdleTime},
+		{"statementTimeout", c.StatementTimeout, &settings.statementTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed < 0 {
+			return settings, fmt.Errorf("invalid sqlPool.%s %q", d.name, d.value)
+		}
+		*d.dest = parsed
+	}
+	return settings, nil
+}
+
+// validateSQLConfig is the configuration check of the database/sql
+// backends.
+func validateSQLConfig(config *Config) error {
+	err := requireDSN(config)
+	if err != nil {
+		return err
+	}
+	err = validateTableName(config)
+	if err != nil {
+		return err
+	}
+	err = validateColumnMapping(config)
+	if err != nil {
+		return err
+	}
+	_, err = parseSQLPoolConfig(config.SQLPool)
+	return err
+}
+
+// sharedSQLDB is a connection pool used by several sinks.
+type sharedSQLDB struct {
+	db   *sql.DB
+	refs int
+}
+
+// sqlDBs holds the open pools keyed by driver, DSN and pool settings, so
+// that workers and worker restarts reuse connections instead of opening
+// new ones.
+var sqlDBs = struct {
+	sync.Mutex
+	pools map[string]*sharedSQLDB
+}{pools: map[string]*sharedSQLDB{}}
+
+// openSQLDB returns the pool for the database, opening it if it is not in
+// use yet. Connections are only made once the pool is used, so an
+// unreachable database is reported by the writes rather than here. Each
+// call must be paired with releaseSQLDB.
+func openSQLDB(dialect sqlDialect, config *Config) (*sql.DB, error) {
+	key := dialect.driver + "\x00" + config.DatabaseDSN
+	// A database limited to one connection gets a single pool, so that its
+	// writers do not lock each other out.
+	if dialect.maxOpenConns == 0 {
+		pool := config.SQLPool
+		key += fmt.Sprintf("\x00%d\x00%d\x00%s\x00%s", pool.MaxOpenConns, pool.MaxIdleConns, pool.ConnMaxLifetime, pool.ConnMaxIdleTime)
+	}
+	sqlDBs.Lock()
+	defer sqlDBs.Unlock()
+	if shared, ok := sqlDBs.pools[key]; ok {
+		shared.refs++
+		return shared.db, nil
+	}
+
+	settings, err := parseSQLPoolConfig(config.SQLPool)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(dialect.driver, config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	maxOpenConns := config.SQLPool.MaxOpenConns
+	if dialect.maxOpenConns > 0 {
+		maxOpenConns = dialect.maxOpenConns
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	if config.SQLPool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.SQLPool.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(settings.connMaxLifetime)
+	db.SetConnMaxIdleTime(settings.connMaxIdleTime)
+
+	sqlDBs.pools[key] = &sharedSQLDB{db: db, refs: 1}
+	return db, nil
+}
+
+// releaseSQLDB gives up a pool returned by openSQLDB and closes it once no
+// sink uses it anymore.
+func releaseSQLDB(db *sql.DB) error {
+	sqlDBs.Lock()
+	defer sqlDBs.Unlock()
+	for key, shared := range sqlDBs.pools {
+		if shared.db != db {
+			continue
+		}
+		shared.refs--
+		if shared.refs > 0 {
+			return nil
+		}
+		delete(sqlDBs.pools, key)
+		break
+	}
+	return db.Close()
+}
+
+// statementContext bounds ctx by the statement timeout, if any.
+func (s *sqlSink) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.statementTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.statementTimeout)
+}
@@ -0,0 +1,71 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// templateFields maps the placeholders usable in templates such as
+// "analytics.{host}.{method}" to record fields.
+var templateFields = map[string]func(data *RequestData) string{
+	"host":            func(d *RequestData) string { return d.Host },
+	"method":          func(d *RequestData) string { return d.Method },
+	"protocol":        func(d *RequestData) string { return d.Protocol },
+	"ip":              func(d *RequestData) string { return d.IP },
+	"path":            func(d *RequestData) string { return d.Path },
+	"status":          func(d *RequestData) string { return strconv.Itoa(d.Status) },
+	"router":          func(d *RequestData) string { return d.Router },
+	"service":         func(d *RequestData) string { return d.Service },
+	"entrypoint":      func(d *RequestData) string { return d.EntryPoint },
+	"upstream":        func(d *RequestData) string { return d.Upstream },
+	"country":         func(d *RequestData) string { return d.Country },
+	"browser":         func(d *RequestData) string { return d.Browser },
+	"os":              func(d *RequestData) string { return d.OS },
+	"device_type":     func(d *RequestData) string { return d.DeviceType },
+	"bot_name":        func(d *RequestData) string { return d.BotName },
+	"utm_source":      func(d *RequestData) string { return d.UTMSource },
+	"utm_medium":      func(d *RequestData) string { return d.UTMMedium },
+	"utm_campaign":    func(d *RequestData) string { return d.UTMCampaign },
+	"utm_term":        func(d *RequestData) string { return d.UTMTerm },
+	"utm_content":     func(d *RequestData) string { return d.UTMContent },
+	"referrer_domain": func(d *RequestData) string { return d.ReferrerDomain },
+	"referrer_type":   func(d *RequestData) string { return d.ReferrerType },
+	"language":        func(d *RequestData) string { return d.Language },
+	"visitor_id":      func(d *RequestData) string { return d.VisitorID },
+	"session_id":      func(d *RequestData) string { return d.SessionID },
+	"tls_version":     func(d *RequestData) string { return d.TLSVersion },
+	"tls_cipher":      func(d *RequestData) string { return d.TLSCipher },
+	"tls_fingerprint": func(d *RequestData) string { return d.TLSFingerprint },
+}
+
+// templatePlaceholder matches a {field} placeholder.
+var templatePlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// recordTemplate expands {field} placeholders with values from a record.
+type recordTemplate struct {
+	raw string
+	// escape makes a field value safe for the target, e.g. a subject token.
+	escape func(string) string
+}
+
+// parseRecordTemplate checks that all placeholders in raw are known.
+func parseRecordTemplate(raw string, escape func(string) string) (*recordTemplate, error) {
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(raw, -1) {
+		if _, ok := templateFields[m[1]]; !ok {
+			return nil, fmt.Errorf("unknown placeholder %q in template %q", m[0], raw)
+		}
+	}
+	return &recordTemplate{raw: raw, escape: escape}, nil
+}
+
+// expand returns the template with placeholders replaced by record values.
+func (t *recordTemplate) expand(data *RequestData) string {
+	return templatePlaceholder.ReplaceAllStringFunc(t.raw, func(m string) string {
+		value := templateFields[m[1:len(m)-1]](data)
+		if t.escape != nil {
+			value = t.escape(value)
+		}
+		return value
+	})
+}
@@ -0,0 +1,43 @@
+package traefik_analytics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+)
+
+// maxTLSFingerprintLength bounds the recorded fingerprint; JA3 strings
+// before hashing can be long.
+const maxTLSFingerprintLength = 256
+
+// tlsFingerprint returns the TLS client fingerprint, such as a JA3 or JA4
+// hash, from the first of headers present. Traefik does not expose the
+// ClientHello to middlewares, so the fingerprint must be computed by a proxy
+// in front of it; the headers are only read from trusted proxies.
+func (r *clientIPResolver) tlsFingerprint(req *http.Request, headers []string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	peer, _, ok := parseAddr(req.RemoteAddr)
+	if !ok || !r.isTrusted(peer) {
+		return ""
+	}
+	for _, header := range headers {
+		if value := strings.TrimSpace(req.Header.Get(header)); value != "" {
+			if len(value) > maxTLSFingerprintLength {
+				value = value[:maxTLSFingerprintLength]
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// tlsParameters returns the negotiated TLS version and cipher suite of req,
+// which Traefik does expose, or empty strings for plain HTTP.
+func tlsParameters(req *http.Request) (string, string) {
+	if req.TLS == nil {
+		return "", ""
+	}
+	return tls.VersionName(req.TLS.Version), tls.CipherSuiteName(req.TLS.CipherSuite)
+}
@@ -0,0 +1,62 @@
+package traefik_analytics
+
+import (
+	"net/http"
+	"strings"
+)
+
+// maxTraceIDLength bounds the length of a trace ID taken from a free-form
+// header such as X-Request-ID.
+const maxTraceIDLength = 128
+
+// extractTraceID returns the trace or correlation ID of the first of the
+// named headers present in the request. W3C traceparent and B3 headers
+// are reduced to their trace ID; other headers are taken as they are.
+func extractTraceID(header http.Header, names []string) string {
+	for _, name := range names {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "traceparent":
+			// version-traceid-parentid-flags
+			parts := strings.Split(value, "-")
+			if len(parts) < 4 || !isTraceID(parts[1]) {
+				continue
+			}
+			return parts[1]
+		case "b3":
+			// traceid-spanid[-sampled[-parentspanid]], or just a sampling decision
+			traceID, _, found := strings.Cut(value, "-")
+			if !found || !isHexID(traceID) {
+				continue
+			}
+			return traceID
+		}
+		if len(value) > maxTraceIDLength {
+			value = value[:maxTraceIDLength]
+		}
+		return value
+	}
+	return ""
+}
+
+// isTraceID reports whether s is a valid W3C trace ID: 32 lowercase hex
+// digits, not all zero.
+func isTraceID(s string) bool {
+	return len(s) == 32 && isHexID(s) && strings.Trim(s, "0") != ""
+}
+
+// isHexID reports whether s consists of lowercase hex digits only.
+func isHexID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
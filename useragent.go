@@ -0,0 +1,195 @@
+package traefik_analytics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UserAgentConfig controls parsing of the User-Agent header into browser,
+// operating system and device type.
+type UserAgentConfig struct {
+	Parse bool `json:"parse,omitempty"`
+	// CacheSize is the number of distinct User-Agent strings whose parsed
+	// result is kept.
+	CacheSize int `json:"cacheSize,omitempty"`
+}
+
+// maxUserAgentLength bounds the User-Agent strings that are parsed and
+// cached; longer values are left unparsed.
+const maxUserAgentLength = 512
+
+// userAgentInfo is the result of parsing a User-Agent string.
+type userAgentInfo struct {
+	browser        string
+	browserVersion string
+	os             string
+	osVersion      string
+	deviceType     string
+}
+
+// userAgentEnricher sets the parsed User-Agent fields of records.
+type userAgentEnricher struct {
+	cache *lruCache
+}
+
+func newUserAgentEnricher(c UserAgentConfig) (*userAgentEnricher, error) {
+	if c.CacheSize < 1 {
+		return nil, fmt.Errorf("userAgent.cacheSize must be at least 1")
+	}
+	return &userAgentEnricher{cache: newLRUCache(c.CacheSize)}, nil
+}
+
+func (e *userAgentEnricher) enrich(data *RequestData) {
+	if data.UserAgent == "" || len(data.UserAgent) > maxUserAgentLength {
+		return
+	}
+	var info userAgentInfo
+	if cached, ok := e.cache.get(data.UserAgent); ok {
+		info = cached.(userAgentInfo)
+	} else {
+		info = parseUserAgent(data.UserAgent)
+		e.cache.add(data.UserAgent, info)
+	}
+	data.Browser = info.browser
+	data.BrowserVersion = info.browserVersion
+	data.OS = info.os
+	data.OSVersion = info.osVersion
+	data.DeviceType = info.deviceType
+}
+
+// uaBrowsers maps product tokens to browser names. Order matters: browsers
+// built on Chromium or WebKit also send the tokens of their base, so the
+// more specific tokens come first.
+var uaBrowsers = []struct {
+	token string
+	name  string
+}{
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"OPiOS/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"UCBrowser/", "UC Browser"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chromium/", "Chromium"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+	{"Go-http-client/", "Go-http-client"},
+	{"python-requests/", "python-requests"},
+	{"okhttp/", "okhttp"},
+}
+
+// uaBotTokens mark automated clients, matched case-insensitively.
+var uaBotTokens = []string{"bot", "crawler", "spider", "slurp", "headless"}
+
+// parseUserAgent extracts browser, operating system and device type from a
+// User-Agent string. Unknown values are left empty.
+func parseUserAgent(ua string) userAgentInfo {
+	var info userAgentInfo
+
+	for _, b := range uaBrowsers {
+		if version, ok := uaToken(ua, b.token); ok {
+			info.browser, info.browserVersion = b.name, version
+			break
+		}
+	}
+	if info.browser == "" {
+		if strings.Contains(ua, "Trident/") {
+			info.browser = "Internet Explorer"
+			info.browserVersion, _ = uaToken(ua, "rv:")
+		} else if strings.Contains(ua, "Safari/") {
+			info.browser = "Safari"
+			info.browserVersion, _ = uaToken(ua, "Version/")
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "Windows Phone"):
+		info.os = "Windows Phone"
+		info.osVersion, _ = uaToken(ua, "Windows Phone ")
+	case strings.Contains(ua, "Windows"):
+		info.os = "Windows"
+		if nt, ok := uaToken(ua, "Windows NT "); ok {
+			info.osVersion = windowsVersion(nt)
+		}
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad") || strings.Contains(ua, "iPod"):
+		info.os = "iOS"
+		version, ok := uaToken(ua, "iPhone OS ")
+		if !ok {
+			version, _ = uaToken(ua, "CPU OS ")
+		}
+		info.osVersion = strings.ReplaceAll(version, "_", ".")
+	case strings.Contains(ua, "Android"):
+		info.os = "Android"
+		info.osVersion, _ = uaToken(ua, "Android ")
+	case strings.Contains(ua, "Mac OS X"):
+		info.os = "macOS"
+		version, _ := uaToken(ua, "Mac OS X ")
+		info.osVersion = strings.ReplaceAll(version, "_", ".")
+	case strings.Contains(ua, "CrOS"):
+		info.os = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		info.os = "Linux"
+	}
+
+	lower := strings.ToLower(ua)
+	for _, token := range uaBotTokens {
+		if strings.Contains(lower, token) {
+			info.deviceType = "bot"
+			return info
+		}
+	}
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(info.os == "Android" && !strings.Contains(ua, "Mobile")):
+		info.deviceType = "tablet"
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod") ||
+		info.os == "Windows Phone":
+		info.deviceType = "mobile"
+	case info.os == "Windows" || info.os == "macOS" || info.os == "Linux" || info.os == "ChromeOS":
+		info.deviceType = "desktop"
+	}
+	return info
+}
+
+// uaToken returns the version following token in ua, up to the next
+// space, semicolon or closing parenthesis.
+func uaToken(ua, token string) (string, bool) {
+	i := strings.Index(ua, token)
+	if i < 0 {
+		return "", false
+	}
+	rest := ua[i+len(token):]
+	if end := strings.IndexAny(rest, " ;)"); end >= 0 {
+		rest = rest[:end]
+	}
+	return rest, true
+}
+
+// windowsVersion maps a Windows NT version to the marketing version.
+// Windows 11 still reports NT 10.0.
+func windowsVersion(nt string) string {
+	switch nt {
+	case "10.0":
+		return "10"
+	case "6.3":
+		return "8.1"
+	case "6.2":
+		return "8"
+	case "6.1":
+		return "7"
+	case "6.0":
+		return "Vista"
+	case "5.1", "5.2":
+		return "XP"
+	}
+	return nt
+}
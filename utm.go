@@ -0,0 +1,58 @@
+package traefik_analytics
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxUTMLength bounds each recorded campaign parameter.
+const maxUTMLength = 256
+
+// utmParams holds the campaign parameters of a request.
+type utmParams struct {
+	source, medium, campaign, term, content string
+}
+
+// extractUTM returns the utm_* parameters of rawQuery. It reads the query as
+// sent, so campaigns are recorded whatever the query.mode. Parameter names
+// are matched case-insensitively and the first occurrence wins.
+func extractUTM(rawQuery string) utmParams {
+	var p utmParams
+	if !strings.Contains(strings.ToLower(rawQuery), "utm_") {
+		return p
+	}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name := strings.ToLower(rawName)
+		if !strings.HasPrefix(name, "utm_") {
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+		if len(value) > maxUTMLength {
+			value = value[:maxUTMLength]
+		}
+
+		var field *string
+		switch name {
+		case "utm_source":
+			field = &p.source
+		case "utm_medium":
+			field = &p.medium
+		case "utm_campaign":
+			field = &p.campaign
+		case "utm_term":
+			field = &p.term
+		case "utm_content":
+			field = &p.content
+		default:
+			continue
+		}
+		if *field == "" {
+			*field = value
+		}
+	}
+	return p
+}
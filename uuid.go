@@ -0,0 +1,36 @@
+package traefik_analytics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// newUUIDv7 returns a random RFC 9562 version 7 UUID for the given time.
+// The leading millisecond timestamp makes the IDs sortable by creation time.
+func newUUIDv7(now time.Time) string {
+	var u [16]byte
+	rand.Read(u[6:])
+
+	ms := uint64(now.UnixMilli())
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
@@ -0,0 +1,48 @@
+package traefik_analytics
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUUIDv7(t *testing.T) {
+	tests := []struct {
+		time   time.Time
+		prefix string
+	}{
+		{time.UnixMilli(0), "00000000-0000-7"},
+		{time.UnixMilli(1), "00000000-0001-7"},
+		// The example timestamp of RFC 9562, appendix A.6.
+		{time.UnixMilli(0x017F22E279B0), "017f22e2-79b0-7"},
+		{time.UnixMilli(1<<48 - 1), "ffffffff-ffff-7"},
+	}
+	for _, tt := range tests {
+		id := newUUIDv7(tt.time)
+		if len(id) != 36 || id[:15] != tt.prefix {
+			t.Errorf("newUUIDv7(%d) = %s, want prefix %s", tt.time.UnixMilli(), id, tt.prefix)
+		}
+		if variant := id[19]; variant < '8' || variant > 'b' {
+			t.Errorf("newUUIDv7(%d) = %s, variant is not RFC 9562", tt.time.UnixMilli(), id)
+		}
+	}
+}
+
+func TestUUIDv7Ordering(t *testing.T) {
+	start := time.Now()
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = newUUIDv7(start.Add(time.Duration(i) * time.Millisecond))
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("IDs of later milliseconds do not sort after earlier ones")
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := newUUIDv7(start)
+		if seen[id] {
+			t.Fatalf("duplicate ID %s", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,119 @@
+package traefik_analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxTrackedSessions bounds the memory used by visitorEnricher.
+const maxTrackedSessions = 100000
+
+// VisitorConfig controls the cookieless visitor and session IDs.
+//
+// The visitor ID is a hash of the client address, User-Agent and host keyed
+// with a salt that changes every day (UTC), so visitors can be counted per
+// day but not followed across days, and the address cannot be recovered.
+type VisitorConfig struct {
+	// Enabled turns the IDs on. It is off by default, as Secret has to be
+	// set with it.
+	Enabled bool `json:"enabled,omitempty"`
+	// Secret derives the daily salt, so that all Traefik instances and
+	// restarts compute the same IDs. It is required; keep it private, as it
+	// lets addresses be matched to IDs.
+	Secret string `json:"secret,omitempty"`
+	// SessionTimeout is the inactivity after which a visitor starts a new
+	// session.
+	SessionTimeout string `json:"sessionTimeout,omitempty"`
+}
+
+// visitorSession is the current session of a visitor.
+type visitorSession struct {
+	id       string
+	lastSeen time.Time
+}
+
+// visitorEnricher sets the visitor and session IDs of records.
+type visitorEnricher struct {
+	secret  []byte
+	timeout time.Duration
+
+	mu       sync.Mutex
+	day      string
+	salt     []byte
+	sessions map[string]*visitorSession
+}
+
+func newVisitorEnricher(c VisitorConfig) (*visitorEnricher, error) {
+	timeout, err := time.ParseDuration(c.SessionTimeout)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid visitor.sessionTimeout %q", c.SessionTimeout)
+	}
+	if c.Secret == "" {
+		return nil, fmt.Errorf("visitor.secret is required")
+	}
+	return &visitorEnricher{
+		secret:   []byte(c.Secret),
+		timeout:  timeout,
+		sessions: map[string]*visitorSession{},
+	}, nil
+}
+
+func (e *visitorEnricher) enrich(data *RequestData) {
+	if data.stripIdentifying {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rotate(data.Time)
+	mac := hmac.New(sha256.New, e.salt)
+	mac.Write([]byte(data.IP + "|" + data.UserAgent + "|" + data.Host))
+	visitorID := hex.EncodeToString(mac.Sum(nil)[:16])
+
+	session, ok := e.sessions[visitorID]
+	if !ok || data.Time.Sub(session.lastSeen) > e.timeout {
+		if !ok && len(e.sessions) >= maxTrackedSessions {
+			e.prune(data.Time)
+		}
+		session = &visitorSession{id: newUUIDv7(data.Time)}
+		e.sessions[visitorID] = session
+	}
+	if data.Time.After(session.lastSeen) {
+		session.lastSeen = data.Time
+	}
+
+	data.VisitorID = visitorID
+	data.SessionID = session.id
+}
+
+// rotate switches to the salt of the day of now. Sessions end with the
+// salt, as their visitors can no longer be recognized. Late records of a
+// previous day, such as closed WebSocket connections, keep the current salt.
+func (e *visitorEnricher) rotate(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if day <= e.day {
+		return
+	}
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(day))
+	e.salt = mac.Sum(nil)
+	e.day = day
+	e.sessions = map[string]*visitorSession{}
+}
+
+// prune drops expired sessions. If that frees too little, all sessions are
+// dropped; their visitors then start new ones.
+func (e *visitorEnricher) prune(now time.Time) {
+	for id, session := range e.sessions {
+		if now.Sub(session.lastSeen) > e.timeout {
+			delete(e.sessions, id)
+		}
+	}
+	if len(e.sessions) >= maxTrackedSessions*9/10 {
+		e.sessions = map[string]*visitorSession{}
+	}
+}
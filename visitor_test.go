@@ -0,0 +1,45 @@
+package traefik_analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVisitorIDs(t *testing.T) {
+	config := VisitorConfig{Enabled: true, Secret: "shared", SessionTimeout: "30m"}
+	newEnricher := func(c VisitorConfig) *visitorEnricher {
+		t.Helper()
+		e, err := newVisitorEnricher(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	visitorID := func(e *visitorEnricher, at time.Time) string {
+		data := RequestData{IP: "203.0.113.7", UserAgent: "Mozilla/5.0", Host: "example.com", Time: at}
+		e.enrich(&data)
+		return data.VisitorID
+	}
+	day := time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC)
+	first := visitorID(newEnricher(config), day)
+
+	tests := []struct {
+		name string
+		id   string
+		same bool
+	}{
+		// Another Traefik instance, or the same one after a restart.
+		{"other instance", visitorID(newEnricher(config), day.Add(time.Hour)), true},
+		{"next day", visitorID(newEnricher(config), day.AddDate(0, 0, 1)), false},
+		{"other secret", visitorID(newEnricher(VisitorConfig{Secret: "other", SessionTimeout: "30m"}), day), false},
+	}
+	for _, tt := range tests {
+		if (tt.id == first) != tt.same {
+			t.Errorf("%s: visitor ID %s, first %s, want same %v", tt.name, tt.id, first, tt.same)
+		}
+	}
+
+	if _, err := newVisitorEnricher(VisitorConfig{Enabled: true, SessionTimeout: "30m"}); err == nil {
+		t.Error("missing secret accepted")
+	}
+}
@@ -0,0 +1,346 @@
+package traefik_analytics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WALConfig enables at-least-once delivery: every record is appended to a
+// local write-ahead log before the request completes, and a single shipper
+// per backend writes the log to it, removing records only once they are
+// stored. Records are delivered again after a crash between the two, so
+// backends may see duplicates.
+type WALConfig struct {
+	// Directory holds one log per middleware and backend. The log is
+	// disabled when empty; it cannot be combined with spill.
+	Directory string `json:"directory,omitempty"`
+	// MaxSizeBytes bounds each log; records beyond it are discarded and
+	// counted as dropped. Zero means no bound.
+	MaxSizeBytes int64 `json:"maxSizeBytes,omitempty"`
+	// Fsync syncs the log after every record. Without it, records survive
+	// a crash of Traefik but not of the host.
+	Fsync bool `json:"fsync,omitempty"`
+}
+
+func validateWALConfig(c WALConfig, spill SpillConfig) error {
+	if c.Directory == "" {
+		return nil
+	}
+	if c.MaxSizeBytes < 0 {
+		return fmt.Errorf("wal.maxSizeBytes must not be negative")
+	}
+	if spill.Directory != "" {
+		return fmt.Errorf("wal.directory and spill.directory cannot be combined")
+	}
+	return nil
+}
+
+// walCompactSize is the number of shipped bytes at the start of a log from
+// which it is compacted.
+const walCompactSize = 1 << 20
+
+// writeAheadLog is an append-only file of JSON lines. The offset of the
+// first record not yet shipped is kept in a second file. The log is
+// truncated whenever all of its records have been shipped, and compacted
+// once its shipped part reaches walCompactSize and half of the log, so that
+// it stays bounded while the shipper lags behind the writers.
+type writeAheadLog struct {
+	path       string
+	offsetPath string
+	maxSize    int64
+	fsync      bool
+	// notify is signalled when records are appended.
+	notify chan struct{}
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	offset int64
+	// pending is the number of records not yet shipped.
+	pending int
+}
+
+// openWriteAheadLog opens the log of a backend, keeping the records a
+// previous run did not ship. A record cut short by a crash is dropped.
+func openWriteAheadLog(c WALConfig, name, storageType string) (*writeAheadLog, error) {
+	err := os.MkdirAll(c.Directory, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %v", err)
+	}
+	base := filepath.Join(c.Directory, unsafeFileChars.ReplaceAllString(name+"-"+storageType, "_"))
+	w := &writeAheadLog{
+		path:       base + ".wal",
+		offsetPath: base + ".offset",
+		maxSize:    c.MaxSizeBytes,
+		fsync:      c.Fsync,
+		notify:     make(chan struct{}, 1),
+	}
+	w.file, err = os.OpenFile(w.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %v", err)
+	}
+	if err := w.recover(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// recover restores the size and offset of the log left by a previous run.
+func (w *writeAheadLog) recover() error {
+	content, err := io.ReadAll(w.file)
+	if err != nil {
+		return fmt.Errorf("failed to read wal: %v", err)
+	}
+	complete := int64(bytes.LastIndexByte(content, '\n') + 1)
+	if complete < int64(len(content)) {
+		log.Printf("Dropping a record cut short in %s", w.path)
+		if err := w.file.Truncate(complete); err != nil {
+			return fmt.Errorf("failed to truncate wal: %v", err)
+		}
+	}
+	w.size = complete
+
+	if raw, err := os.ReadFile(w.offsetPath); err == nil {
+		w.offset, _ = strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	}
+	// The log may have been truncated after the offset was saved.
+	if w.offset < 0 || w.offset > w.size {
+		w.offset = 0
+	}
+	w.pending = bytes.Count(content[w.offset:complete], []byte{'\n'})
+	if w.pending > 0 {
+		log.Printf("Resuming %d unshipped records from %s", w.pending, w.path)
+		w.notify <- struct{}{}
+	}
+	return nil
+}
+
+// append adds data to the log, unless that would exceed its maximum size.
+func (w *writeAheadLog) append(data *RequestData) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %v", err)
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxSize > 0 && w.size+int64(len(line)) > w.maxSize {
+		return fmt.Errorf("wal %s is full", w.path)
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write wal: %v", err)
+	}
+	if w.fsync {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync wal: %v", err)
+		}
+	}
+	w.pending++
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// unshipped returns the number of records not yet shipped.
+func (w *writeAheadLog) unshipped() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// read returns up to limit unshipped records, the offset following them and
+// the number of log lines they span, including unreadable ones.
+func (w *writeAheadLog) read(limit int) ([]RequestData, int64, int, error) {
+	w.mu.Lock()
+	file, offset, size := w.file, w.offset, w.size
+	w.mu.Unlock()
+
+	r := bufio.NewReader(io.NewSectionReader(file, offset, size-offset))
+	var batch []RequestData
+	lines := 0
+	for len(batch) < limit {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read wal: %v", err)
+		}
+		offset += int64(len(line))
+		lines++
+		var data RequestData
+		if err := json.Unmarshal(line, &data); err != nil {
+			log.Printf("Skipping unreadable record in %s: %v", w.path, err)
+			continue
+		}
+		batch = append(batch, data)
+	}
+	return batch, offset, lines, nil
+}
+
+// commit marks the records up to offset, spanning lines log lines, as
+// shipped.
+func (w *writeAheadLog) commit(offset int64, lines int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.offset = offset
+	w.pending -= lines
+	switch {
+	case w.offset == w.size:
+		if err := w.file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate wal: %v", err)
+		}
+		w.offset, w.size = 0, 0
+	case w.offset >= walCompactSize && w.offset >= w.size/2:
+		return w.compact()
+	}
+	return w.saveOffset()
+}
+
+// saveOffset stores the offset of the first unshipped record.
+func (w *writeAheadLog) saveOffset() error {
+	err := os.WriteFile(w.offsetPath, []byte(strconv.FormatInt(w.offset, 10)), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to save wal offset: %v", err)
+	}
+	return nil
+}
+
+// compact replaces the log with its unshipped records. The offset is reset
+// before the new log takes the place of the old one, so a crash in between
+// ships records again rather than skipping any.
+func (w *writeAheadLog) compact() error {
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact wal: %v", err)
+	}
+	size, err := io.Copy(tmp, io.NewSectionReader(w.file, w.offset, w.size-w.offset))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact wal: %v", err)
+	}
+
+	shipped := w.offset
+	w.offset = 0
+	if err := w.saveOffset(); err != nil {
+		w.offset = shipped
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		// Keep the old log; should the offset not be restored, it is
+		// shipped again from its start after a restart.
+		w.offset = shipped
+		w.saveOffset()
+		return fmt.Errorf("failed to compact wal: %v", err)
+	}
+	w.file.Close()
+	w.file, w.size = tmp, size
+	// Appends go to the end of the log.
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to compact wal: %v", err)
+	}
+	return nil
+}
+
+// shipWAL writes the log to sink in batches until the middleware shuts
+// down. Fewer than batchSize records are written once the first of them
+// has waited for flushInterval. A failed write is retried with backoff.
+func (o *output) shipWAL(sink Sink) error {
+	timer := time.NewTimer(o.flushInterval)
+	timer.Stop()
+	waiting := false
+	failures := 0
+	for {
+		if pending := o.wal.unshipped(); pending < o.batchSize {
+			if pending > 0 && !waiting {
+				timer.Reset(o.flushInterval)
+				waiting = true
+			}
+			select {
+			case <-o.wal.notify:
+				continue
+			case <-timer.C:
+				waiting = false
+			case <-o.done:
+				o.flushWAL(sink)
+				return nil
+			}
+		} else if waiting {
+			timer.Stop()
+			waiting = false
+		}
+
+		err := o.shipBatch(context.Background(), sink)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		delay := o.retryDelay(failures)
+		log.Printf("Failed to write data to %s (attempt %d), retrying in %s: %v", o.storageType, failures, delay, err)
+		if !o.wait(delay) {
+			o.flushWAL(sink)
+			return nil
+		}
+	}
+}
+
+// shipBatch writes the next batch of the log to sink and removes it from
+// the log. Records the backend rejects are dead-lettered, not retried.
+func (o *output) shipBatch(ctx context.Context, sink Sink) error {
+	batch, offset, lines, err := o.wal.read(o.batchSize)
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		err = sink.Write(ctx, batch)
+		var partial *partialWriteError
+		if errors.As(err, &partial) {
+			log.Printf("Failed to write data to %s: %v", o.storageType, err)
+			o.storeRejected(ctx, sink, partial.rejected)
+		} else if err != nil {
+			return fmt.Errorf("failed to ship wal: %v", err)
+		}
+	}
+	return o.wal.commit(offset, lines)
+}
+
+// flushWAL ships the rest of the log before shutdown. Records not shipped
+// within shutdownTimeout stay in the log for the next start.
+func (o *output) flushWAL(sink Sink) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	for o.wal.unshipped() > 0 && ctx.Err() == nil {
+		if err := o.shipBatch(ctx, sink); err != nil {
+			log.Printf("Keeping %d records in the wal of %s: %v", o.wal.unshipped(), o.storageType, err)
+			return
+		}
+	}
+}
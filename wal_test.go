@@ -0,0 +1,118 @@
+package traefik_analytics
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func openTestWAL(t *testing.T, dir string) *writeAheadLog {
+	t.Helper()
+	w, err := openWriteAheadLog(WALConfig{Directory: dir}, "test", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.file.Close() })
+	return w
+}
+
+func TestWriteAheadLogStaysBounded(t *testing.T) {
+	w := openTestWAL(t, t.TempDir())
+	padding := strings.Repeat("x", 200)
+	next := 0
+	var maxSize int64
+	for i := 0; i < 20000; i++ {
+		if err := w.append(&RequestData{Path: "/" + strconv.Itoa(i), UserAgent: padding}); err != nil {
+			t.Fatal(err)
+		}
+		// The shipper lags behind by up to 500 records.
+		if w.unshipped() < 500 {
+			continue
+		}
+		batch, offset, lines, err := w.read(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range batch {
+			if data.Path != "/"+strconv.Itoa(next) {
+				t.Fatalf("shipped %s, want /%d", data.Path, next)
+			}
+			next++
+		}
+		if err := w.commit(offset, lines); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(w.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		maxSize = max(maxSize, info.Size())
+	}
+	if limit := int64(2 * walCompactSize); maxSize > limit {
+		t.Errorf("wal grew to %d bytes, want at most %d", maxSize, limit)
+	}
+
+	// The records left after the last compaction survive a restart.
+	w.file.Close()
+	w = openTestWAL(t, filepath.Dir(w.path))
+	batch, _, _, err := w.read(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 20000-next || batch[0].Path != "/"+strconv.Itoa(next) {
+		t.Errorf("recovered %d records starting at %s, want %d starting at /%d", len(batch), batch[0].Path, 20000-next, next)
+	}
+}
+
+func TestWriteAheadLogRecover(t *testing.T) {
+	lines := `{"path":"/0"}` + "\n" + `{"path":"/1"}` + "\n" + `{"path":"/2"}` + "\n"
+	tests := []struct {
+		name    string
+		content string
+		offset  string
+		want    []string
+	}{
+		{"nothing shipped", lines, "", []string{"/0", "/1", "/2"}},
+		{"partly shipped", lines, strconv.Itoa(len(`{"path":"/0"}` + "\n")), []string{"/1", "/2"}},
+		{"fully shipped", lines, strconv.Itoa(len(lines)), nil},
+		{"cut short by a crash", lines + `{"path":"/3"`, "", []string{"/0", "/1", "/2"}},
+		{"offset beyond the log", lines, "100000", []string{"/0", "/1", "/2"}},
+		// A compaction that saved the offset but did not replace the log
+		// ships the old log again.
+		{"interrupted compaction", lines, "0", []string{"/0", "/1", "/2"}},
+		{"unreadable record", `{"path":"/0"}` + "\n" + "garbage\n" + `{"path":"/2"}` + "\n", "", []string{"/0", "/2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(dir+"/test-file.wal", []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.offset != "" {
+				if err := os.WriteFile(dir+"/test-file.offset", []byte(tt.offset), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w := openTestWAL(t, dir)
+			batch, offset, n, err := w.read(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, data := range batch {
+				got = append(got, data.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+			if err := w.commit(offset, n); err != nil {
+				t.Fatal(err)
+			}
+			if w.unshipped() != 0 || w.size != 0 {
+				t.Errorf("after committing everything: %d unshipped, size %d", w.unshipped(), w.size)
+			}
+		})
+	}
+}